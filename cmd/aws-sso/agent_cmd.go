@@ -0,0 +1,84 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/internal/server"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+type AgentCmd struct {
+	RefreshWindow int `kong:"help='Refresh credentials expiring within this many minutes',default=10"`
+}
+
+func (cc *AgentCmd) Run(ctx *RunContext) error {
+	awssso, err := authenticate(ctx)
+	if err != nil {
+		return err
+	}
+
+	ssoName, err := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
+	if err != nil {
+		return err
+	}
+
+	getCreds := func(arn string, refresh bool) (*storage.RoleCredentials, string, error) {
+		accountId, role, err := utils.ParseRoleARN(arn)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// our SSO token may have expired while we've been running
+		if awssso.Token.Expired() {
+			if err = awssso.Authenticate(ctx.Settings.UrlAction, ctx.Settings.Browser); err != nil {
+				return nil, "", err
+			}
+		}
+
+		// our clients record the audit log entry with their own command
+		creds, source, err := getRoleCredentials(ctx, awssso, accountId, role, refresh)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := ctx.Settings.Cache.SetRoleExpires(arn, creds.ExpireEpoch()); err != nil {
+			log.WithError(err).Warnf("Unable to update cache")
+		}
+		return creds, source, nil
+	}
+
+	socket := utils.GetHomePath(ctx.Cli.AgentSocket)
+	window := time.Duration(cc.RefreshWindow) * time.Minute
+	s, err := server.NewAgentServer(socket, ssoName, window, getCreds)
+	if err != nil {
+		return err
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("aws-sso agent for %s listening on %s", ssoName, s.Socket())
+	return s.Serve(sigCtx)
+}
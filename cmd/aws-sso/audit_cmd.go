@@ -0,0 +1,145 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/internal/audit"
+	"github.com/synfinatic/gotable"
+)
+
+type AuditCmd struct {
+	Role  string `kong:"short='r',help='Only show credentials for this role name or ARN'"`
+	Since string `kong:"help='Only show credentials issued after this RFC3339 time or duration ago'"`
+	Until string `kong:"help='Only show credentials issued before this RFC3339 time or duration ago'"`
+	Json  bool   `kong:"help='Print each record as JSON'"`
+}
+
+// auditRow is a single row in our audit report
+type auditRow struct {
+	Time    string `header:"Time"`
+	SSO     string `header:"SSO"`
+	RoleArn string `header:"RoleArn"`
+	Source  string `header:"Source"`
+	Command string `header:"Command"`
+	Parent  string `header:"Parent"`
+	Client  string `header:"Client"`
+}
+
+// GetHeader is required for GenerateTable()
+func (ar auditRow) GetHeader(fieldName string) (string, error) {
+	v := reflect.ValueOf(ar)
+	return gotable.GetHeaderTag(v, fieldName)
+}
+
+func (cc *AuditCmd) Run(ctx *RunContext) error {
+	l := ctx.Settings.AuditLogger()
+	if l == nil {
+		return fmt.Errorf("The audit log is not enabled.  Please set AuditLog.File in %s", ctx.Cli.ConfigFile)
+	}
+
+	var err error
+	filter := audit.Filter{
+		Role: ctx.Cli.Audit.Role,
+	}
+	if filter.Since, err = parseAuditTime(ctx.Cli.Audit.Since); err != nil {
+		return fmt.Errorf("Invalid --since: %s", err.Error())
+	}
+	if filter.Until, err = parseAuditTime(ctx.Cli.Audit.Until); err != nil {
+		return fmt.Errorf("Invalid --until: %s", err.Error())
+	}
+
+	records, err := l.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	if ctx.Cli.Audit.Json {
+		for _, r := range records {
+			line, _ := json.Marshal(r)
+			fmt.Printf("%s\n", line)
+		}
+		return nil
+	}
+
+	if len(records) == 0 {
+		fmt.Printf("No matching records in %s\n", l.File())
+		return nil
+	}
+
+	tr := []gotable.TableStruct{}
+	for _, r := range records {
+		tr = append(tr, auditRow{
+			Time:    r.Time.Local().Format(time.RFC3339),
+			SSO:     r.SSO,
+			RoleArn: r.RoleArn,
+			Source:  r.Source,
+			Command: r.Command,
+			Parent:  r.Parent,
+			Client:  r.Client,
+		})
+	}
+
+	fields := []string{"Time", "SSO", "RoleArn", "Source", "Command", "Parent", "Client"}
+	err = gotable.GenerateTable(tr, fields)
+	if err == nil {
+		fmt.Printf("\n")
+	}
+	return err
+}
+
+// parseAuditTime parses an RFC3339 time or a duration before now
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// auditCommand returns the name of the aws-sso command without the
+// placeholders for its arguments, ie: exec instead of exec <command> <args>
+func auditCommand(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// auditCreds records in the audit log that we returned credentials for the role
+func auditCreds(ctx *RunContext, ssoName, arn, source string) {
+	err := ctx.Settings.AuditLogger().Log(audit.Record{
+		SSO:     ssoName,
+		RoleArn: arn,
+		Command: auditCommand(ctx.Kctx.Command()),
+		Parent:  audit.ParentName(),
+		Source:  source,
+	})
+	if err != nil {
+		log.WithError(err).Warnf("Unable to write audit log")
+	}
+}
@@ -20,6 +20,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/synfinatic/aws-sso-cli/internal/awsconfig"
 	"github.com/synfinatic/aws-sso-cli/internal/url"
@@ -45,9 +46,9 @@ func NewSelectCliArgs(arn string, accountId int64, role, profile string) *Select
 
 func (a *SelectCliArgs) Update(ctx *RunContext) (*sso.AWSSSO, error) {
 	if a.AccountId != 0 && a.RoleName != "" {
-		return doAuth(ctx), nil
+		return doAuthUnlessAgent(ctx), nil
 	} else if a.Profile != "" {
-		awssso := doAuth(ctx)
+		awssso := doAuthUnlessAgent(ctx)
 		cache := ctx.Settings.Cache.GetSSO()
 		rFlat, err := cache.Roles.GetRoleByProfile(a.Profile, ctx.Settings)
 		if err != nil {
@@ -59,7 +60,7 @@ func (a *SelectCliArgs) Update(ctx *RunContext) (*sso.AWSSSO, error) {
 
 		return awssso, nil
 	} else if a.Arn != "" {
-		awssso := doAuth(ctx)
+		awssso := doAuthUnlessAgent(ctx)
 		accountId, role, err := utils.ParseRoleARN(a.Arn)
 		if err != nil {
 			return awssso, err
@@ -74,26 +75,72 @@ func (a *SelectCliArgs) Update(ctx *RunContext) (*sso.AWSSSO, error) {
 
 // Creates a singleton AWSSO object post authentication
 func doAuth(ctx *RunContext) *sso.AWSSSO {
+	awssso, err := authenticate(ctx)
+	if err != nil {
+		log.WithError(err).Fatalf("Unable to authenticate")
+	}
+	return awssso
+}
+
+// doAuthUnlessAgent returns nil if the aws-sso agent will provide our
+// credentials, otherwise it calls doAuth()
+func doAuthUnlessAgent(ctx *RunContext) *sso.AWSSSO {
+	if ctx.Agent != nil {
+		return nil
+	}
+	return doAuth(ctx)
+}
+
+// mfaTokenFunc returns how to get the MFA token selected via --mfa-token
+// or nil to prompt the user
+func mfaTokenFunc(ctx *RunContext) sso.MfaTokenFunc {
+	switch ctx.Cli.MfaToken {
+	case "":
+		return nil
+	case "-":
+		return sso.ReaderMfaToken(os.Stdin)
+	default:
+		return sso.FixedMfaToken(ctx.Cli.MfaToken)
+	}
+}
+
+// authenticate creates a singleton AWSSO object post authentication and
+// refreshes our cache if necessary
+func authenticate(ctx *RunContext) (*sso.AWSSSO, error) {
 	if AwsSSO != nil {
-		return AwsSSO
+		return AwsSSO, nil
+	}
+	if ctx.Store == nil {
+		// we skipped this for the agent
+		loadSecureStore(ctx)
 	}
 	s, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
 	if err != nil {
-		log.Fatalf("%s", err.Error())
+		return nil, err
 	}
-	AwsSSO = sso.NewAWSSSO(s, &ctx.Store)
-	err = AwsSSO.Authenticate(ctx.Settings.UrlAction, ctx.Settings.Browser)
+	awssso, err := sso.NewAWSSSO(s, &ctx.Store)
 	if err != nil {
-		log.WithError(err).Fatalf("Unable to authenticate")
+		return nil, err
 	}
+	if f := mfaTokenFunc(ctx); f != nil {
+		awssso.SetMfaTokenFunc(f)
+	}
+	err = awssso.Authenticate(ctx.Settings.UrlAction, ctx.Settings.Browser)
+	if err != nil {
+		return nil, err
+	}
+	AwsSSO = awssso
 	if err = ctx.Settings.Cache.Expired(s); err != nil {
 		ssoName, err := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
 		log.Infof("Refreshing AWS SSO role cache for %s, please wait...", ssoName)
 		if err != nil {
-			log.Fatalf(err.Error())
+			return nil, err
 		}
 		if err = ctx.Settings.Cache.Refresh(AwsSSO, s, ssoName); err != nil {
-			log.WithError(err).Fatalf("Unable to refresh cache")
+			return nil, fmt.Errorf("Unable to refresh cache: %s", err.Error())
+		}
+		if summary := ctx.Settings.Cache.GetRefreshSummary(ssoName); summary != nil && summary.Changed() {
+			log.Infof("%s", summary.String())
 		}
 		if err = ctx.Settings.Cache.Save(true); err != nil {
 			log.WithError(err).Errorf("Unable to save cache")
@@ -110,5 +157,5 @@ func doAuth(ctx *RunContext) *sso.AWSSSO {
 			}
 		}
 	}
-	return AwsSSO
+	return AwsSSO, nil
 }
@@ -20,11 +20,22 @@ package main
 
 import (
 	"fmt"
+	"sort"
+
+	"github.com/synfinatic/aws-sso-cli/sso"
 )
 
-type CacheCmd struct{}
+type CacheCmd struct {
+	All  bool `kong:"help='Refresh the cache for all configured AWS SSO instances'"`
+	Full bool `kong:"help='Query the roles of every account instead of only new, changed or stale accounts'"`
+}
 
 func (cc *CacheCmd) Run(ctx *RunContext) error {
+	ctx.Settings.Cache.SetFullRefresh(ctx.Cli.Cache.Full)
+	if ctx.Cli.Cache.All {
+		return refreshAllCaches(ctx)
+	}
+
 	awssso := doAuth(ctx)
 	s, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
 	if err != nil {
@@ -47,5 +58,82 @@ func (cc *CacheCmd) Run(ctx *RunContext) error {
 		return fmt.Errorf("Unable to save role cache: %s", err.Error())
 	}
 
+	printRefreshSummary(ctx.Settings.Cache.GetRefreshSummary(ssoName))
+	return nil
+}
+
+// printRefreshSummary prints the accounts & roles which were added or removed
+func printRefreshSummary(summary *sso.RefreshSummary) {
+	if summary == nil {
+		return
+	}
+
+	fmt.Printf("%s (queried %d accounts, %d unchanged)\n", summary.String(), summary.Fetched, summary.Reused)
+	for _, accountId := range summary.AddedAccounts {
+		fmt.Printf("  + account %s\n", accountId)
+	}
+	for _, accountId := range summary.RemovedAccounts {
+		fmt.Printf("  - account %s\n", accountId)
+	}
+	for _, arn := range summary.AddedRoles {
+		fmt.Printf("  + %s\n", arn)
+	}
+	for _, arn := range summary.RemovedRoles {
+		fmt.Printf("  - %s\n", arn)
+	}
+}
+
+// refreshAllCaches authenticates to every AWS SSO instance and then refreshes
+// their role caches in parallel, reporting the result for each instance
+func refreshAllCaches(ctx *RunContext) error {
+	names := []string{}
+	for name := range ctx.Settings.SSO {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := map[string]error{}
+	instances := map[string]*sso.AWSSSO{}
+	for _, name := range names {
+		s := ctx.Settings.SSO[name]
+		s.Refresh(ctx.Settings)
+		awssso, err := sso.NewAWSSSO(s, &ctx.Store)
+		if err != nil {
+			results[name] = err
+			continue
+		}
+
+		// Authenticate one at a time since this may require the user
+		if err := awssso.Authenticate(ctx.Settings.UrlAction, ctx.Settings.Browser); err != nil {
+			results[name] = fmt.Errorf("Unable to authenticate: %s", err.Error())
+			continue
+		}
+		instances[name] = awssso
+	}
+
+	log.Infof("Refreshing AWS SSO role cache for %d instances, please wait...", len(instances))
+	for name, err := range ctx.Settings.Cache.RefreshAll(instances) {
+		results[name] = err
+	}
+	ctx.Settings.Cache.PruneSSO(ctx.Settings)
+
+	if err := ctx.Settings.Cache.Save(false); err != nil {
+		return fmt.Errorf("Unable to save role cache: %s", err.Error())
+	}
+
+	failed := 0
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			fmt.Printf("%s: FAILED: %s\n", name, err.Error())
+			failed++
+		} else {
+			fmt.Printf("%s: OK\n", name)
+			printRefreshSummary(ctx.Settings.Cache.GetRefreshSummary(name))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Unable to refresh role cache for %d of %d AWS SSO instances", failed, len(names))
+	}
 	return nil
 }
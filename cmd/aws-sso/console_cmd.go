@@ -30,7 +30,6 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	// "github.com/davecgh/go-spew/spew"
@@ -42,6 +41,14 @@ import (
 
 const AWS_FEDERATED_URL = "https://signin.aws.amazon.com/federation"
 
+// federatedUrl returns the AWS console sign-in federation endpoint
+func federatedUrl() string {
+	if endpoint := sso.EndpointURL(); endpoint != "" {
+		return endpoint + "/federation"
+	}
+	return AWS_FEDERATED_URL
+}
+
 type ConsoleCmd struct {
 	// Console actually should honor the --region flag
 	Region   string `kong:"help='AWS Region',env='AWS_DEFAULT_REGION',predictor='region'"`
@@ -103,21 +110,16 @@ func stsSession(ctx *RunContext) (*sts.Client, error) {
 		ctx.Cli.Console.SessionToken,
 	)
 
-	sso, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
+	s, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
 	if err != nil {
 		return &sts.Client{}, err
 	}
 
-	ssoRegion := sso.SSORegion
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(ssoRegion),
-		config.WithCredentialsProvider(cfgCreds),
-	)
+	stsHandle, err := s.STSClient(cfgCreds)
 	if err != nil {
 		return &sts.Client{}, err
 	}
-
-	return sts.NewFromConfig(cfg), nil
+	return stsHandle, nil
 }
 
 func consoleViaEnvVars(ctx *RunContext) error {
@@ -249,7 +251,20 @@ func openConsoleAccessKey(ctx *RunContext, creds *storage.RoleCredentials,
 		},
 	}
 
-	resp, err := http.Get(signin.GetUrl())
+	s, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
+	if err != nil {
+		return err
+	}
+	httpClient, err := s.HTTPClient()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, signin.GetUrl(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		log.Debugf(err.Error())
 		// sanitize error and remove sensitive URL from normal output
@@ -270,11 +285,7 @@ func openConsoleAccessKey(ctx *RunContext, creds *storage.RoleCredentials,
 		return fmt.Errorf("Error parsing Login response: %s", err.Error())
 	}
 
-	sso, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
-	if err != nil {
-		return err
-	}
-	issuer := sso.StartUrl
+	issuer := s.StartUrl
 
 	login := LoginUrlParams{
 		Issuer:      issuer,
@@ -315,7 +326,7 @@ type SigninTokenUrlParams struct {
 
 func (stup *SigninTokenUrlParams) GetUrl() string {
 	return fmt.Sprintf("%s?Action=getSigninToken&SessionDuration=%d&Session=%s",
-		AWS_FEDERATED_URL, stup.SessionDuration, stup.Session.Encode())
+		federatedUrl(), stup.SessionDuration, stup.Session.Encode())
 }
 
 type SessionUrlParams struct {
@@ -337,6 +348,6 @@ type LoginUrlParams struct {
 
 func (lup *LoginUrlParams) GetUrl() string {
 	return fmt.Sprintf("%s?Action=login&Issuer=%s&Destination=%s&SigninToken=%s",
-		AWS_FEDERATED_URL, lup.Issuer, lup.Destination,
+		federatedUrl(), lup.Issuer, lup.Destination,
 		lup.SigninToken)
 }
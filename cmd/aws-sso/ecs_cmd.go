@@ -21,8 +21,13 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/synfinatic/aws-sso-cli/internal/server"
@@ -32,7 +37,10 @@ import (
 )
 
 const (
-	ECS_PORT = 4144
+	ECS_PORT            = 4144
+	ECS_AUTH_TOKEN_FILE = CONFIG_DIR + "/ecs-server.token"
+	ECS_TLS_CERT_FILE   = CONFIG_DIR + "/ecs-server.crt"
+	ECS_TLS_KEY_FILE    = CONFIG_DIR + "/ecs-server.key"
 )
 
 type EcsCmd struct {
@@ -41,13 +49,26 @@ type EcsCmd struct {
 	Load    EcsLoadCmd    `kong:"cmd,help='Load new IAM Role credentials into the ECS Server'"`
 	Unload  EcsUnloadCmd  `kong:"cmd,help='Unload the current IAM Role credentials from the ECS Server'"`
 	Profile EcsProfileCmd `kong:"cmd,help='Get the current role profile name in the default slot'"`
+	Status  EcsStatusCmd  `kong:"cmd,help='Show the status of the ECS Server'"`
+	Env     EcsEnvCmd     `kong:"cmd,help='Print ECS Server environment vars for use with eval $(aws-sso ecs env ...)'"`
 }
 
 type EcsRunCmd struct {
-	Port int `kong:"help='TCP port to listen on',env='AWS_SSO_ECS_PORT',default=4144"`
+	Port      int      `kong:"help='TCP port to listen on',env='AWS_SSO_ECS_PORT',default=4144"`
+	Bind      string   `kong:"help='IP address to listen on (requires --tls if not loopback)',env='AWS_SSO_ECS_BIND',default='127.0.0.1'"`
+	Socket    string   `kong:"help='Listen on the Unix socket instead of TCP',env='AWS_SSO_ECS_SOCKET',xor='tls'"`
+	Tls       bool     `kong:"help='Use HTTPS with a self-signed certificate',env='AWS_SSO_ECS_TLS',xor='tls'"`
+	TlsHost   []string `kong:"help='Additional hostname or IP address for the TLS certificate'"`
+	OnDemand  bool     `kong:"help='Fetch and refresh IAM Role credentials on demand',env='AWS_SSO_ECS_ON_DEMAND'"`
+	Persist   bool     `kong:"help='Save loaded credentials in the SecureStore and restore them on restart',env='AWS_SSO_ECS_PERSIST'"`
+	AuthToken string   `kong:"help='Bearer token clients must provide (default: random)',env='AWS_SSO_ECS_AUTH_TOKEN'"`
 }
 
-type EcsListCmd struct{}
+type EcsListCmd struct {
+	Port   int    `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
+	Socket string `kong:"help='Unix socket of aws-sso ECS Server',env='AWS_SSO_ECS_SOCKET',xor='tls'"`
+	Tls    bool   `kong:"help='Use HTTPS to talk to the aws-sso ECS Server',env='AWS_SSO_ECS_TLS',xor='tls'"`
+}
 
 type EcsLoadCmd struct {
 	// AWS Params
@@ -57,25 +78,129 @@ type EcsLoadCmd struct {
 	Profile   string `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile',xor='account,role'"`
 
 	// Other params
-	Port    int  `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
-	Slotted bool `kong:"short='s',help='Load credentials in a unique slot using the ProfileName as the key'"`
+	Port    int    `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
+	Socket  string `kong:"help='Unix socket of aws-sso ECS Server',env='AWS_SSO_ECS_SOCKET',xor='tls'"`
+	Tls     bool   `kong:"help='Use HTTPS to talk to the aws-sso ECS Server',env='AWS_SSO_ECS_TLS',xor='tls'"`
+	Slotted bool   `kong:"short='s',help='Load credentials in a unique slot using the ProfileName as the key'"`
 }
 
 type EcsProfileCmd struct {
-	Port int `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
+	Port   int    `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
+	Socket string `kong:"help='Unix socket of aws-sso ECS Server',env='AWS_SSO_ECS_SOCKET',xor='tls'"`
+	Tls    bool   `kong:"help='Use HTTPS to talk to the aws-sso ECS Server',env='AWS_SSO_ECS_TLS',xor='tls'"`
+}
+
+type EcsStatusCmd struct {
+	Port   int    `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
+	Socket string `kong:"help='Unix socket of aws-sso ECS Server',env='AWS_SSO_ECS_SOCKET',xor='tls'"`
+	Tls    bool   `kong:"help='Use HTTPS to talk to the aws-sso ECS Server',env='AWS_SSO_ECS_TLS',xor='tls'"`
+}
+
+type EcsEnvCmd struct {
+	Port    int    `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
+	Tls     bool   `kong:"help='Use HTTPS to talk to the aws-sso ECS Server',env='AWS_SSO_ECS_TLS'"`
+	Profile string `kong:"short='p',help='Name of AWS Profile in a named slot',predictor='profile'"`
 }
 
 type EcsUnloadCmd struct {
 	Port    int    `kong:"help='TCP port of aws-sso ECS Server',env='AWS_SSO_ECS_PORT',default=4144"`
+	Socket  string `kong:"help='Unix socket of aws-sso ECS Server',env='AWS_SSO_ECS_SOCKET',xor='tls'"`
+	Tls     bool   `kong:"help='Use HTTPS to talk to the aws-sso ECS Server',env='AWS_SSO_ECS_TLS',xor='tls'"`
 	Profile string `kong:"short='p',help='Name of AWS Profile to unload',predictor='profile'"`
 }
 
 func (cc *EcsRunCmd) Run(ctx *RunContext) error {
-	s, err := server.NewEcsServer(context.TODO(), "", ctx.Cli.Ecs.Run.Port)
+	var err error
+	tokenFile := utils.GetHomePath(ECS_AUTH_TOKEN_FILE)
+	authToken := ctx.Cli.Ecs.Run.AuthToken
+	if authToken == "" {
+		if ctx.Cli.Ecs.Run.Persist {
+			// persisted slots are useless to clients holding the old token
+			authToken, err = server.LoadOrNewAuthToken(tokenFile)
+		} else {
+			authToken, err = server.NewAuthToken()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var s *server.EcsServer
+	if ctx.Cli.Ecs.Run.Socket != "" {
+		s, err = server.NewUnixEcsServer(context.TODO(), authToken, utils.GetHomePath(ctx.Cli.Ecs.Run.Socket))
+	} else {
+		s, err = ecsTcpServer(ctx, authToken)
+	}
 	if err != nil {
 		return err
 	}
-	return s.Serve()
+
+	// only replace the token once we own the listener so a failed start
+	// does not break the clients of an already running server
+	if err = server.WriteAuthToken(tokenFile, authToken); err != nil {
+		return err
+	}
+	log.Infof("ECS Server auth token written to %s", tokenFile)
+
+	if ctx.Cli.Ecs.Run.OnDemand {
+		s.EnableOnDemand(ctx.Settings, doAuth(ctx), ctx.Store)
+	}
+	if ctx.Cli.Ecs.Run.Persist {
+		s.EnablePersistence(ctx.Store, s.BaseURL())
+	}
+	if l := ctx.Settings.AuditLogger(); l != nil {
+		ssoName, _ := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
+		s.EnableAudit(l, ssoName)
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("ECS Server listening on %s", s.BaseURL())
+	return s.Serve(sigCtx)
+}
+
+// ecsTcpServer creates our ECS Server listening on TCP, optionally using HTTPS
+func ecsTcpServer(ctx *RunContext, authToken string) (*server.EcsServer, error) {
+	bind := net.ParseIP(ctx.Cli.Ecs.Run.Bind)
+	if bind == nil {
+		return nil, fmt.Errorf("Invalid --bind IP address: %s", ctx.Cli.Ecs.Run.Bind)
+	}
+	if !bind.IsLoopback() && !ctx.Cli.Ecs.Run.Tls {
+		return nil, fmt.Errorf("Listening on %s requires --tls", bind.String())
+	}
+
+	s, err := server.NewEcsServer(context.TODO(), authToken, bind.String(), ctx.Cli.Ecs.Run.Port)
+	if err != nil || !ctx.Cli.Ecs.Run.Tls {
+		return s, err
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if !bind.IsLoopback() && !bind.IsUnspecified() {
+		hosts = append(hosts, bind.String())
+	}
+	hosts = append(hosts, ctx.Cli.Ecs.Run.TlsHost...)
+
+	certFile := utils.GetHomePath(ECS_TLS_CERT_FILE)
+	keyFile := utils.GetHomePath(ECS_TLS_KEY_FILE)
+	if err = server.EnsureCertificate(certFile, keyFile, hosts); err != nil {
+		return nil, err
+	}
+	s.EnableTLS(certFile, keyFile)
+	return s, nil
+}
+
+// ecsClient returns a Client for the ECS Server using the selected transport
+func ecsClient(port int, socket string, useTls bool) (*server.Client, error) {
+	tokenFile := utils.GetHomePath(ECS_AUTH_TOKEN_FILE)
+	switch {
+	case socket != "":
+		return server.NewUnixClient(utils.GetHomePath(socket), tokenFile)
+	case useTls:
+		return server.NewTLSClient(port, utils.GetHomePath(ECS_TLS_CERT_FILE), tokenFile)
+	default:
+		return server.NewClient(port, tokenFile)
+	}
 }
 
 func (cc *EcsLoadCmd) Run(ctx *RunContext) error {
@@ -89,7 +214,10 @@ func (cc *EcsLoadCmd) Run(ctx *RunContext) error {
 }
 
 func (cc *EcsProfileCmd) Run(ctx *RunContext) error {
-	c := server.NewClient(ctx.Cli.Ecs.Profile.Port)
+	c, err := ecsClient(ctx.Cli.Ecs.Profile.Port, ctx.Cli.Ecs.Profile.Socket, ctx.Cli.Ecs.Profile.Tls)
+	if err != nil {
+		return err
+	}
 
 	profile, err := c.GetProfile()
 	if err != nil {
@@ -100,13 +228,25 @@ func (cc *EcsProfileCmd) Run(ctx *RunContext) error {
 }
 
 func (cc *EcsUnloadCmd) Run(ctx *RunContext) error {
-	c := server.NewClient(ctx.Cli.Ecs.Unload.Port)
+	c, err := ecsClient(ctx.Cli.Ecs.Unload.Port, ctx.Cli.Ecs.Unload.Socket, ctx.Cli.Ecs.Unload.Tls)
+	if err != nil {
+		return err
+	}
 
 	return c.Delete(ctx.Cli.Ecs.Unload.Profile)
 }
 
 // Loads our AWS API creds into the ECS Server
 func ecsLoadCmd(ctx *RunContext, awssso *sso.AWSSSO, accountId int64, role string) error {
+	c, err := ecsClient(ctx.Cli.Ecs.Load.Port, ctx.Cli.Ecs.Load.Socket, ctx.Cli.Ecs.Load.Tls)
+	if err != nil {
+		return err
+	}
+	return submitCreds(ctx, c, awssso, accountId, role, ctx.Cli.Ecs.Load.Slotted)
+}
+
+// submitCreds loads the IAM Role credentials into the ECS/IMDS Server
+func submitCreds(ctx *RunContext, c *server.Client, awssso *sso.AWSSSO, accountId int64, role string, slotted bool) error {
 	creds := GetRoleCredentials(ctx, awssso, accountId, role)
 
 	cache := ctx.Settings.Cache.GetSSO() // ctx.Settings.Cache.Refresh(awssso, ssoConfig, ctx.Cli.SSO)
@@ -127,16 +267,20 @@ func ecsLoadCmd(ctx *RunContext, awssso *sso.AWSSSO, accountId int64, role strin
 		log.WithError(err).Warnf("Unable to update cache")
 	}
 
-	// do something
-	c := server.NewClient(ctx.Cli.Ecs.Load.Port)
-
 	log.Debugf("%s", spew.Sdump(rFlat))
-	return c.SubmitCreds(creds, rFlat.Profile, ctx.Cli.Ecs.Load.Slotted)
+	return c.SubmitCreds(creds, rFlat.Profile, slotted)
 }
 
 func (cc *EcsListCmd) Run(ctx *RunContext) error {
-	c := server.NewClient(ctx.Cli.Ecs.Profile.Port)
+	c, err := ecsClient(ctx.Cli.Ecs.List.Port, ctx.Cli.Ecs.List.Socket, ctx.Cli.Ecs.List.Tls)
+	if err != nil {
+		return err
+	}
+	return printProfiles(c)
+}
 
+// printProfiles prints the profiles loaded in named slots of the ECS/IMDS Server
+func printProfiles(c *server.Client) error {
 	profiles, err := c.ListProfiles()
 	if err != nil {
 		return err
@@ -164,3 +308,61 @@ func (cc *EcsListCmd) Run(ctx *RunContext) error {
 
 	return err
 }
+
+// Prints the environment variables AWS SDKs need to use the ECS Server
+func (cc *EcsEnvCmd) Run(ctx *RunContext) error {
+	c, err := ecsClient(ctx.Cli.Ecs.Env.Port, "", ctx.Cli.Ecs.Env.Tls)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("export AWS_CONTAINER_CREDENTIALS_FULL_URI=\"%s\"\n", c.LoadUrl(ctx.Cli.Ecs.Env.Profile))
+	fmt.Printf("export AWS_CONTAINER_AUTHORIZATION_TOKEN=\"%s\"\n", c.AuthToken())
+	return nil
+}
+
+// Prints the status of the ECS Server
+func (cc *EcsStatusCmd) Run(ctx *RunContext) error {
+	c, err := ecsClient(ctx.Cli.Ecs.Status.Port, ctx.Cli.Ecs.Status.Socket, ctx.Cli.Ecs.Status.Tls)
+	if err != nil {
+		return err
+	}
+	return printStatus(c)
+}
+
+// printStatus prints the status of the ECS/IMDS Server
+func printStatus(c *server.Client) error {
+	status, err := c.Status()
+	if err != nil {
+		return err
+	}
+
+	started := time.Unix(status.Started, 0)
+	fmt.Printf("PID:        %d\n", status.Pid)
+	fmt.Printf("Started:    %s\n", started.Format(time.RFC3339))
+	fmt.Printf("Uptime:     %s\n", time.Since(started).Round(time.Second))
+	fmt.Printf("On Demand:  %v\n", status.OnDemand)
+	fmt.Printf("Persistent: %v\n", status.Persist)
+	if status.Default != nil {
+		fmt.Printf("Default:    %s (%s)\n", status.Default.ProfileName, status.Default.Expires)
+	} else {
+		fmt.Printf("Default:    <none>\n")
+	}
+	fmt.Printf("Slots:      %d\n", len(status.Slots))
+
+	if len(status.Slots) == 0 {
+		return nil
+	}
+
+	sort.Slice(status.Slots, func(i, j int) bool {
+		return strings.Compare(status.Slots[i].ProfileName, status.Slots[j].ProfileName) < 0
+	})
+
+	tr := []gotable.TableStruct{}
+	for _, row := range status.Slots {
+		tr = append(tr, row)
+	}
+
+	fmt.Printf("\n")
+	return gotable.GenerateTable(tr, []string{"ProfileName", "AccountIdPad", "RoleName", "Expires"})
+}
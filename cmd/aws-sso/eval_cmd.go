@@ -82,7 +82,7 @@ func (cc *EvalCmd) Run(ctx *RunContext) error {
 	}
 	region := ctx.Settings.GetDefaultRegion(accountid, role, ctx.Cli.Eval.NoRegion)
 
-	awssso := doAuth(ctx)
+	awssso := doAuthUnlessAgent(ctx)
 
 	for k, v := range execShellEnvs(ctx, awssso, accountid, role, region) {
 		if isBashLike() {
@@ -34,7 +34,10 @@ func (cc *FlushCmd) Run(ctx *RunContext) error {
 	if err != nil {
 		return err
 	}
-	awssso := sso.NewAWSSSO(s, &ctx.Store)
+	awssso, err := sso.NewAWSSSO(s, &ctx.Store)
+	if err != nil {
+		return err
+	}
 
 	switch ctx.Cli.Flush.Type {
 	case "sts":
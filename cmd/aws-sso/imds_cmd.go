@@ -0,0 +1,201 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/synfinatic/aws-sso-cli/internal/server"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+	"github.com/synfinatic/aws-sso-cli/sso"
+)
+
+const (
+	IMDS_PORT            = 4145
+	IMDS_AUTH_TOKEN_FILE = CONFIG_DIR + "/imds-server.token"
+)
+
+type ImdsCmd struct {
+	Run     ImdsRunCmd     `kong:"cmd,help='Run the IMDS Server'"`
+	List    ImdsListCmd    `kong:"cmd,help='List profiles loaded in the IMDS Server'"`
+	Load    ImdsLoadCmd    `kong:"cmd,help='Load new IAM Role credentials into the IMDS Server'"`
+	Unload  ImdsUnloadCmd  `kong:"cmd,help='Unload the current IAM Role credentials from the IMDS Server'"`
+	Profile ImdsProfileCmd `kong:"cmd,help='Get the current role profile name in the default slot'"`
+	Status  ImdsStatusCmd  `kong:"cmd,help='Show the status of the IMDS Server'"`
+	Env     ImdsEnvCmd     `kong:"cmd,help='Print IMDS Server environment vars for use with eval $(aws-sso imds env)'"`
+}
+
+type ImdsRunCmd struct {
+	Port      int    `kong:"help='TCP port to listen on',env='AWS_SSO_IMDS_PORT',default=4145"`
+	OnDemand  bool   `kong:"help='Fetch and refresh IAM Role credentials on demand',env='AWS_SSO_IMDS_ON_DEMAND'"`
+	Persist   bool   `kong:"help='Save loaded credentials in the SecureStore and restore them on restart',env='AWS_SSO_IMDS_PERSIST'"`
+	AuthToken string `kong:"help='Bearer token management clients must provide (default: random)',env='AWS_SSO_IMDS_AUTH_TOKEN'"`
+}
+
+type ImdsListCmd struct {
+	Port int `kong:"help='TCP port of aws-sso IMDS Server',env='AWS_SSO_IMDS_PORT',default=4145"`
+}
+
+type ImdsLoadCmd struct {
+	// AWS Params
+	Arn       string `kong:"short='a',help='ARN of role to assume',env='AWS_SSO_ROLE_ARN',predictor='arn'"`
+	AccountId int64  `kong:"name='account',short='A',help='AWS AccountID of role to assume',env='AWS_SSO_ACCOUNT_ID',predictor='accountId',xor='account'"`
+	Role      string `kong:"short='R',help='Name of AWS Role to assume',env='AWS_SSO_ROLE_NAME',predictor='role',xor='role'"`
+	Profile   string `kong:"short='p',help='Name of AWS Profile to assume',predictor='profile',xor='account,role'"`
+
+	// Other params
+	Port    int  `kong:"help='TCP port of aws-sso IMDS Server',env='AWS_SSO_IMDS_PORT',default=4145"`
+	Slotted bool `kong:"short='s',help='Load credentials in a unique slot using the ProfileName as the key'"`
+}
+
+type ImdsUnloadCmd struct {
+	Port    int    `kong:"help='TCP port of aws-sso IMDS Server',env='AWS_SSO_IMDS_PORT',default=4145"`
+	Profile string `kong:"short='p',help='Name of AWS Profile to unload',predictor='profile'"`
+}
+
+type ImdsProfileCmd struct {
+	Port int `kong:"help='TCP port of aws-sso IMDS Server',env='AWS_SSO_IMDS_PORT',default=4145"`
+}
+
+type ImdsStatusCmd struct {
+	Port int `kong:"help='TCP port of aws-sso IMDS Server',env='AWS_SSO_IMDS_PORT',default=4145"`
+}
+
+type ImdsEnvCmd struct {
+	Port int `kong:"help='TCP port of aws-sso IMDS Server',env='AWS_SSO_IMDS_PORT',default=4145"`
+}
+
+func (cc *ImdsRunCmd) Run(ctx *RunContext) error {
+	var err error
+	tokenFile := utils.GetHomePath(IMDS_AUTH_TOKEN_FILE)
+	authToken := ctx.Cli.Imds.Run.AuthToken
+	if authToken == "" {
+		if ctx.Cli.Imds.Run.Persist {
+			// persisted slots are useless to clients holding the old token
+			authToken, err = server.LoadOrNewAuthToken(tokenFile)
+		} else {
+			authToken, err = server.NewAuthToken()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := server.NewImdsServer(context.TODO(), authToken, ctx.Cli.Imds.Run.Port)
+	if err != nil {
+		return err
+	}
+
+	// only replace the token once we own the listener so a failed start
+	// does not break the clients of an already running server
+	if err = server.WriteAuthToken(tokenFile, authToken); err != nil {
+		return err
+	}
+	log.Infof("IMDS Server auth token written to %s", tokenFile)
+
+	if ctx.Cli.Imds.Run.OnDemand {
+		s.EnableOnDemand(ctx.Settings, doAuth(ctx), ctx.Store)
+	}
+	if ctx.Cli.Imds.Run.Persist {
+		s.EnablePersistence(ctx.Store, s.BaseURL())
+	}
+	if l := ctx.Settings.AuditLogger(); l != nil {
+		ssoName, _ := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
+		s.EnableAudit(l, ssoName)
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("IMDS Server listening on %s", s.BaseURL())
+	return s.Serve(sigCtx)
+}
+
+// imdsClient returns a Client for the management API of the IMDS Server
+func imdsClient(port int) (*server.Client, error) {
+	return server.NewClient(port, utils.GetHomePath(IMDS_AUTH_TOKEN_FILE))
+}
+
+func (cc *ImdsLoadCmd) Run(ctx *RunContext) error {
+	sci := NewSelectCliArgs(ctx.Cli.Imds.Load.Arn, ctx.Cli.Imds.Load.AccountId, ctx.Cli.Imds.Load.Role, ctx.Cli.Imds.Load.Profile)
+	if awssso, err := sci.Update(ctx); err == nil {
+		// successful lookup?
+		return imdsLoadCmd(ctx, awssso, sci.AccountId, sci.RoleName)
+	}
+
+	return ctx.PromptExec(imdsLoadCmd)
+}
+
+// Loads our AWS API creds into the IMDS Server
+func imdsLoadCmd(ctx *RunContext, awssso *sso.AWSSSO, accountId int64, role string) error {
+	c, err := imdsClient(ctx.Cli.Imds.Load.Port)
+	if err != nil {
+		return err
+	}
+	return submitCreds(ctx, c, awssso, accountId, role, ctx.Cli.Imds.Load.Slotted)
+}
+
+func (cc *ImdsUnloadCmd) Run(ctx *RunContext) error {
+	c, err := imdsClient(ctx.Cli.Imds.Unload.Port)
+	if err != nil {
+		return err
+	}
+
+	return c.Delete(ctx.Cli.Imds.Unload.Profile)
+}
+
+func (cc *ImdsProfileCmd) Run(ctx *RunContext) error {
+	c, err := imdsClient(ctx.Cli.Imds.Profile.Port)
+	if err != nil {
+		return err
+	}
+
+	profile, err := c.GetProfile()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", profile)
+	return nil
+}
+
+func (cc *ImdsListCmd) Run(ctx *RunContext) error {
+	c, err := imdsClient(ctx.Cli.Imds.List.Port)
+	if err != nil {
+		return err
+	}
+	return printProfiles(c)
+}
+
+func (cc *ImdsStatusCmd) Run(ctx *RunContext) error {
+	c, err := imdsClient(ctx.Cli.Imds.Status.Port)
+	if err != nil {
+		return err
+	}
+	return printStatus(c)
+}
+
+// Prints the environment variable AWS SDKs need to use the IMDS Server
+func (cc *ImdsEnvCmd) Run(ctx *RunContext) error {
+	fmt.Printf("export AWS_EC2_METADATA_SERVICE_ENDPOINT=\"http://127.0.0.1:%d\"\n", ctx.Cli.Imds.Env.Port)
+	return nil
+}
@@ -194,7 +194,10 @@ func printRoles(ctx *RunContext, fields []string, csv bool, prefixSearch []strin
 		if err != nil {
 			log.Fatalf("%s", err.Error())
 		}
-		AwsSSO = sso.NewAWSSSO(s, &ctx.Store)
+		AwsSSO, err = sso.NewAWSSSO(s, &ctx.Store)
+		if err != nil {
+			log.Fatalf("%s", err.Error())
+		}
 
 		expires := ""
 		ctr := storage.CreateTokenResponse{}
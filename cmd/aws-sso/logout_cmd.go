@@ -32,7 +32,10 @@ func (cc *LogoutCmd) Run(ctx *RunContext) error {
 	if err != nil {
 		return err
 	}
-	awssso := sso.NewAWSSSO(s, &ctx.Store)
+	awssso, err := sso.NewAWSSSO(s, &ctx.Store)
+	if err != nil {
+		return err
+	}
 
 	// Call logout to invalidate our session
 	if err := awssso.Logout(); err != nil {
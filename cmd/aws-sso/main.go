@@ -22,11 +22,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/alecthomas/kong"
 	"github.com/posener/complete"
 	// "github.com/davecgh/go-spew/spew"
 	"github.com/sirupsen/logrus"
+	"github.com/synfinatic/aws-sso-cli/internal/audit"
 	"github.com/synfinatic/aws-sso-cli/internal/awscreds"
 	"github.com/synfinatic/aws-sso-cli/internal/helper"
 	"github.com/synfinatic/aws-sso-cli/internal/predictor"
@@ -54,13 +56,16 @@ type RunContext struct {
 	Cli      *CLI
 	Settings *sso.Settings // unified config & cache
 	Store    storage.SecureStorage
+	Agent    *server.AgentClient // set if we are using the aws-sso agent
 }
 
 const (
 	CONFIG_DIR          = "~/.aws-sso"
 	CONFIG_FILE         = CONFIG_DIR + "/config.yaml"
 	JSON_STORE_FILE     = CONFIG_DIR + "/store.json"
+	ENCRYPTED_JSON_FILE = CONFIG_DIR + "/store.json.enc"
 	INSECURE_CACHE_FILE = CONFIG_DIR + "/cache.json"
+	AGENT_SOCKET        = CONFIG_DIR + "/agent.sock"
 	DEFAULT_STORE       = "file"
 	COPYRIGHT_YEAR      = "2021-2023"
 )
@@ -95,10 +100,14 @@ var DEFAULT_CONFIG map[string]interface{} = map[string]interface{}{
 	"AutoConfigCheck":                           false,
 	"FullTextSearch":                            true,
 	"ProfileFormat":                             sso.DEFAULT_PROFILE_TEMPLATE,
+	"RoleSessionNameFormat":                     sso.DEFAULT_ROLE_SESSION_NAME_FORMAT,
 	"CacheRefresh":                              168, // 7 days in hours
+	"CacheAccountRefresh":                       24,  // hours
 	"Threads":                                   5,
 	"MaxBackoff":                                5, // seconds
 	"MaxRetry":                                  10,
+	"AuditLog.MaxSize":                          audit.DEFAULT_MAX_SIZE,
+	"AuditLog.MaxBackups":                       audit.DEFAULT_MAX_BACKUPS,
 }
 
 type CLI struct {
@@ -112,8 +121,13 @@ type CLI struct {
 	STSRefresh    bool   `kong:"help='Force refresh of STS Token Credentials'"`
 	NoConfigCheck bool   `kong:"help='Disable automatic ~/.aws/config updates'"`
 	Threads       int    `kong:"help='Override number of threads for talking to AWS'"`
+	AgentSocket   string `kong:"default='${AGENT_SOCKET}',help='Path to the aws-sso agent socket',env='AWS_SSO_AGENT_SOCKET'"`
+	NoAgent       bool   `kong:"help='Do not use the aws-sso agent',env='AWS_SSO_NO_AGENT'"`
+	MfaToken      string `kong:"help='MFA token code for roles or static credentials which require MFA (- to read from stdin)',env='AWS_SSO_MFA_TOKEN'"`
 
 	// Commands
+	Agent          AgentCmd          `kong:"cmd,help='Run the aws-sso agent to serve credentials over a Unix socket'"`
+	Audit          AuditCmd          `kong:"cmd,help='Query the audit log of issued credentials'"`
 	Cache          CacheCmd          `kong:"cmd,help='Force reload of cached AWS SSO role info and config.yaml'"`
 	Console        ConsoleCmd        `kong:"cmd,help='Open AWS Console using specificed AWS role/profile'"`
 	Default        DefaultCmd        `kong:"cmd,hidden,default='1'"` // list command without args
@@ -124,12 +138,14 @@ type CLI struct {
 	Logout         LogoutCmd         `kong:"cmd,help='Logout in browser and invalidate all credentials'"`
 	Process        ProcessCmd        `kong:"cmd,help='Generate JSON for credential_process in ~/.aws/config'"`
 	Static         StaticCmd         `kong:"cmd,help='Manage static AWS API credentials',hidden"`
+	Store          StoreCmd          `kong:"cmd,help='Manage the SecureStore'"`
 	Tags           TagsCmd           `kong:"cmd,help='List tags'"`
 	Time           TimeCmd           `kong:"cmd,help='Print how much time before current STS Token expires'"`
 	Completions    CompleteCmd       `kong:"cmd,help='Manage shell completions'"`
 	ConfigProfiles ConfigProfilesCmd `kong:"cmd,help='Update ~/.aws/config with AWS SSO profiles from the cache'"`
 	Config         ConfigCmd         `kong:"cmd,help='Run the configuration wizard'"`
 	Ecs            EcsCmd            `kong:"cmd,help='ECS Server commands'"`
+	Imds           ImdsCmd           `kong:"cmd,help='IMDSv2 Server commands'"`
 	Version        VersionCmd        `kong:"cmd,help='Print version and exit'"`
 }
 
@@ -139,6 +155,7 @@ func main() {
 
 	log = logrus.New()
 	ctx, override := parseArgs(&cli)
+	audit.SetLogger(log)
 	awscreds.SetLogger(log)
 	helper.SetLogger(log)
 	predictor.SetLogger(log)
@@ -188,39 +205,96 @@ func main() {
 		log.Fatalf("%s", err.Error())
 	}
 
-	// Load the secure store data
-	switch runCtx.Settings.SecureStore {
+	// Avoid loading the secure store if the agent can give us our creds.
+	// The agent can't use our MFA token.
+	socket := utils.GetHomePath(cli.AgentSocket)
+	if usesAgent(ctx.Command()) && !cli.NoAgent && cli.MfaToken == "" && server.AgentAvailable(socket) {
+		log.Debugf("Using aws-sso agent: %s", socket)
+		runCtx.Agent = server.NewAgentClient(socket)
+	} else if usesSecureStore(ctx.Command()) {
+		loadSecureStore(&runCtx)
+	}
+
+	err = ctx.Run(&runCtx)
+	if err != nil {
+		log.Fatalf("Error running command: %s", err.Error())
+	}
+}
+
+// usesSecureStore returns true if the command needs our SecureStore
+func usesSecureStore(command string) bool {
+	switch {
+	case command == "audit":
+		return false
+	case command == "store gc":
+		return true
+	case strings.HasPrefix(command, "store "):
+		// other store commands open the stores themselves
+		return false
+	}
+	return true
+}
+
+// loadSecureStore opens the configured SecureStore
+func loadSecureStore(ctx *RunContext) {
+	var err error
+
+	ctx.Store, err = openSecureStore(ctx.Settings, ctx.Settings.SecureStore)
+	if err != nil {
+		log.WithError(err).Fatalf("Unable to open SecureStore %s", ctx.Settings.SecureStore)
+	}
+}
+
+// openSecureStore opens the named SecureStore backend using the file names
+// and options in our settings
+func openSecureStore(s *sso.Settings, name string) (storage.SecureStorage, error) {
+	switch name {
 	case "json":
 		sfile := utils.GetHomePath(JSON_STORE_FILE)
-		if runCtx.Settings.JsonStore != "" {
-			sfile = utils.GetHomePath(runCtx.Settings.JsonStore)
+		if s.JsonStore != "" {
+			sfile = utils.GetHomePath(s.JsonStore)
 		}
-		runCtx.Store, err = storage.OpenJsonStore(sfile)
+		store, err := storage.OpenJsonStore(sfile)
 		if err != nil {
-			log.WithError(err).Fatalf("Unable to open JsonStore %s", sfile)
+			return nil, fmt.Errorf("Unable to open JsonStore %s: %s", sfile, err.Error())
 		}
 		log.Warnf("Using insecure json file for SecureStore: %s", sfile)
-	default:
-		cfg, err := storage.NewKeyringConfig(runCtx.Settings.SecureStore, CONFIG_DIR)
-		if err != nil {
-			log.WithError(err).Fatalf("Unable to create SecureStore")
+		return store, nil
+	case "encrypted-json":
+		sfile := utils.GetHomePath(ENCRYPTED_JSON_FILE)
+		if s.EncryptedJsonStore != "" {
+			sfile = utils.GetHomePath(s.EncryptedJsonStore)
+		}
+		cfg := storage.EncryptedJsonConfig{
+			Kdf: s.EncryptedJsonKdf,
+		}
+		if s.EncryptedJsonKeyFile != "" {
+			cfg.KeyFile = utils.GetHomePath(s.EncryptedJsonKeyFile)
 		}
-		runCtx.Store, err = storage.OpenKeyring(cfg)
+		return storage.OpenEncryptedJsonStore(sfile, cfg)
+	default:
+		cfg, err := storage.NewKeyringConfig(name, CONFIG_DIR)
 		if err != nil {
-			log.WithError(err).Fatalf("Unable to open SecureStore %s", runCtx.Settings.SecureStore)
+			return nil, err
 		}
+		return storage.OpenKeyring(cfg)
 	}
+}
 
-	err = ctx.Run(&runCtx)
-	if err != nil {
-		log.Fatalf("Error running command: %s", err.Error())
+// usesAgent returns true if the command can get its credentials from the agent
+func usesAgent(command string) bool {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
 	}
+	return utils.StrListContains(fields[0], []string{"eval", "exec", "process"})
 }
 
 // parseArgs parses our CLI arguments
 func parseArgs(cli *CLI) (*kong.Context, sso.OverrideSettings) {
 	// need to pass in the variables for defaults
 	vars := kong.Vars{
+		"AGENT_SOCKET":    AGENT_SOCKET,
 		"CONFIG_DIR":      CONFIG_DIR,
 		"CONFIG_FILE":     CONFIG_FILE,
 		"DEFAULT_STORE":   DEFAULT_STORE,
@@ -289,20 +363,59 @@ func (cc *VersionCmd) Run(ctx *RunContext) error {
 	return nil
 }
 
-// Get our RoleCredentials from the secure store or from AWS SSO
+// Get our RoleCredentials from the agent, secure store or from AWS SSO
 func GetRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string) *storage.RoleCredentials {
+	arn := utils.MakeRoleARN(accountid, role)
+	log.Debugf("Getting role credentials for %s", arn)
+
+	if ctx.Agent != nil {
+		ssoName, _ := ctx.Settings.GetSelectedSSOName(ctx.Cli.SSO)
+		creds, source, err := ctx.Agent.GetRoleCredentials(ssoName, arn, ctx.Cli.STSRefresh)
+		if err == nil {
+			log.Debugf("Retrieved role credentials from the aws-sso agent")
+			// the agent doesn't audit, so we record where it got them from
+			auditCreds(ctx, ssoName, arn, source)
+			if err := ctx.Settings.Cache.SetRoleExpires(arn, creds.ExpireEpoch()); err != nil {
+				log.WithError(err).Warnf("Unable to update cache")
+			}
+			return creds
+		}
+		log.WithError(err).Warnf("Unable to use aws-sso agent")
+		ctx.Agent = nil
+	}
+
+	if awssso == nil {
+		awssso = doAuth(ctx)
+	}
+
+	creds, source, err := getRoleCredentials(ctx, awssso, accountid, role, ctx.Cli.STSRefresh)
+	if err != nil {
+		log.WithError(err).Fatalf("Unable to get role credentials for %s", arn)
+	}
+	auditCreds(ctx, awssso.StoreKey(), arn, source)
+
+	// Update the cache
+	if err := ctx.Settings.Cache.SetRoleExpires(arn, creds.ExpireEpoch()); err != nil {
+		log.WithError(err).Warnf("Unable to update cache")
+	}
+	return creds
+}
+
+// getRoleCredentials returns our RoleCredentials from the secure store or
+// from AWS SSO, in which case they are saved in the secure store.  Also returns
+// the audit.SOURCE_* of the credentials so the caller can record them.
+func getRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, role string, refresh bool) (*storage.RoleCredentials, string, error) {
 	creds := storage.RoleCredentials{}
 
 	// First look for our creds in the secure store, if we're not forcing a refresh
 	arn := utils.MakeRoleARN(accountid, role)
-	log.Debugf("Getting role credentials for %s", arn)
-	if !ctx.Cli.STSRefresh {
+	if !refresh {
 		if roleFlat, err := ctx.Settings.Cache.GetRole(arn); err == nil {
 			if !roleFlat.IsExpired() {
 				if err := ctx.Store.GetRoleCredentials(arn, &creds); err == nil {
 					if !creds.Expired() {
 						log.Debugf("Retrieved role credentials from the SecureStore")
-						return &creds
+						return &creds, audit.SOURCE_STORE, nil
 					}
 				}
 			}
@@ -317,7 +430,7 @@ func GetRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, ro
 	var err error
 	creds, err = awssso.GetRoleCredentials(accountid, role)
 	if err != nil {
-		log.WithError(err).Fatalf("Unable to get role credentials for %s", arn)
+		return nil, "", err
 	}
 
 	log.Debugf("Retrieved role credentials from AWS SSO")
@@ -325,13 +438,10 @@ func GetRoleCredentials(ctx *RunContext, awssso *sso.AWSSSO, accountid int64, ro
 	// Cache our creds
 	if err := ctx.Store.SaveRoleCredentials(arn, creds); err != nil {
 		log.WithError(err).Warnf("Unable to cache role credentials in secure store")
+	} else {
+		autoGarbageCollect(ctx)
 	}
-
-	// Update the cache
-	if err := ctx.Settings.Cache.SetRoleExpires(arn, creds.ExpireEpoch()); err != nil {
-		log.WithError(err).Warnf("Unable to update cache")
-	}
-	return &creds
+	return &creds, audit.SOURCE_AWS, nil
 }
 
 func logLevelValidate(level string) error {
@@ -67,7 +67,7 @@ func (cc *ProcessCmd) Run(ctx *RunContext) error {
 		return fmt.Errorf("Please specify --arn or --account and --role")
 	}
 
-	awssso := doAuth(ctx)
+	awssso := doAuthUnlessAgent(ctx)
 	return credentialProcess(ctx, awssso, account, role)
 }
 
@@ -31,7 +31,6 @@ import (
 	// "github.com/davecgh/go-spew/spew"
 	//	"github.com/manifoldco/promptui"
 	"github.com/manifoldco/promptui"
-	"github.com/synfinatic/aws-sso-cli/internal/awscreds"
 	"github.com/synfinatic/aws-sso-cli/internal/storage"
 	"github.com/synfinatic/aws-sso-cli/internal/utils"
 	"github.com/synfinatic/aws-sso-cli/sso"
@@ -51,6 +50,7 @@ var currentProfiles *sso.ProfileMap
 type StaticAddCmd struct {
 	Profile     string `kong:"short='p',help='Name of the AWS Profile to create'"`
 	AccessKeyId string `kong:"short='a',help='AWS AccessKeyId for the profile'"`
+	MfaSerial   string `kong:"help='Serial number or ARN of the MFA device required by the credentials'"`
 }
 
 // validateAwsProfile validates our AWS_PROFILE value
@@ -84,7 +84,12 @@ func validateAwsSecretKey(input string) error {
 func (cc *StaticAddCmd) Run(ctx *RunContext) error {
 	var err error
 
-	log.Warnf("aws-sso does not currently support MFA for static credentials.")
+	mfaSerial := ctx.Cli.Static.Add.MfaSerial
+	if mfaSerial != "" {
+		if err = sso.ValidateMfaSerial(mfaSerial); err != nil {
+			return err
+		}
+	}
 
 	// load current profiles
 	currentProfiles, err = ctx.Settings.GetAllProfiles("")
@@ -128,31 +133,36 @@ func (cc *StaticAddCmd) Run(ctx *RunContext) error {
 		return err
 	}
 
+	awssso, err := staticAWSSSO(ctx)
+	if err != nil {
+		return err
+	}
+
 	// check if key is valid
-	p := awscreds.Profile{
-		FromConfig:      false,
-		Name:            profile,
+	static := storage.StaticCredentials{
+		Profile:         profile,
 		AccessKeyId:     accessKey,
 		SecretAccessKey: secretKey,
-		MfaSerial:       "",
+		Tags:            map[string]string{},
+		MfaSerial:       mfaSerial,
 	}
-
-	arn, err := p.GetArn()
+	arn, err := awssso.StaticUserArn(&static)
 	if err != nil {
 		return fmt.Errorf("Unable to validate credentials: %s", err.Error())
 	}
+	static.AccountId, static.UserName, _ = utils.ParseUserARN(arn)
 
-	accountId, userName, _ := utils.ParseUserARN(arn)
+	if mfaSerial != "" {
+		// verify the MFA device by calling sts:GetSessionToken and cache the
+		// session credentials
+		session, err := awssso.GetSessionToken(&static)
+		if err != nil {
+			return fmt.Errorf("Unable to validate MFA: %s", err.Error())
+		}
+		static.Session = &session
+	}
 
-	err = ctx.Store.SaveStaticCredentials(arn, storage.StaticCredentials{
-		Profile:         profile,
-		UserName:        userName,
-		AccountId:       accountId,
-		AccessKeyId:     accessKey,
-		SecretAccessKey: secretKey,
-		Tags:            map[string]string{},
-	})
-	if err != nil {
+	if err = ctx.Store.SaveStaticCredentials(arn, static); err != nil {
 		return err
 	}
 
@@ -161,6 +171,23 @@ func (cc *StaticAddCmd) Run(ctx *RunContext) error {
 	return nil
 }
 
+// staticAWSSSO returns the AWSSSO of the selected SSO instance for validating
+// static credentials with its STS settings
+func staticAWSSSO(ctx *RunContext) (*sso.AWSSSO, error) {
+	s, err := ctx.Settings.GetSelectedSSO(ctx.Cli.SSO)
+	if err != nil {
+		return nil, err
+	}
+	awssso, err := sso.NewAWSSSO(s, &ctx.Store)
+	if err != nil {
+		return nil, err
+	}
+	if f := mfaTokenFunc(ctx); f != nil {
+		awssso.SetMfaTokenFunc(f)
+	}
+	return awssso, nil
+}
+
 // StaticDelCmd deletes static credentials from the SecureStore
 type StaticDelCmd struct {
 	Profile string `kong:"short='p',required,help='Name of the AWS Profile to delete'"`
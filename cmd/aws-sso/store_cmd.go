@@ -0,0 +1,220 @@
+package main
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+type StoreCmd struct {
+	Migrate StoreMigrateCmd `kong:"cmd,help='Copy all records from one SecureStore backend to another'"`
+	Passwd  StorePasswdCmd  `kong:"cmd,help='Change the password of the file SecureStore'"`
+	Unlock  StoreUnlockCmd  `kong:"cmd,help='Unlock the file SecureStore for this shell'"`
+	Lock    StoreLockCmd    `kong:"cmd,help='Lock the file SecureStore'"`
+	Gc      StoreGcCmd      `kong:"cmd,help='Remove expired and orphaned records from the SecureStore'"`
+}
+
+// how often we automatically garbage collect the SecureStore
+const STORE_GC_INTERVAL = 24 * time.Hour
+
+type StoreMigrateCmd struct {
+	From string `kong:"required,help='SecureStore to copy from [json|encrypted-json|file|keychain|kwallet|pass|secret-service|wincred]'"`
+	To   string `kong:"required,help='SecureStore to copy to [json|encrypted-json|file|keychain|kwallet|pass|secret-service|wincred]'"`
+	Wipe bool   `kong:"help='Delete all records from the source SecureStore after a successful migration'"`
+}
+
+func (cc *StoreMigrateCmd) Run(ctx *RunContext) error {
+	from, to := ctx.Cli.Store.Migrate.From, ctx.Cli.Store.Migrate.To
+	if from == to {
+		return fmt.Errorf("--from and --to must be different SecureStores")
+	}
+
+	src, err := openSecureStore(ctx.Settings, from)
+	if err != nil {
+		return fmt.Errorf("Unable to open %s SecureStore: %s", from, err.Error())
+	}
+	dst, err := openSecureStore(ctx.Settings, to)
+	if err != nil {
+		return fmt.Errorf("Unable to open %s SecureStore: %s", to, err.Error())
+	}
+
+	summary, err := storage.Migrate(src, dst)
+	if err != nil {
+		return fmt.Errorf("Migration from %s to %s failed: %s", from, to, err.Error())
+	}
+	if err = storage.VerifyMigration(src, dst); err != nil {
+		return fmt.Errorf("Unable to verify migration from %s to %s: %s", from, to, err.Error())
+	}
+	fmt.Printf("Copied %s from %s to %s\n", summary.String(), from, to)
+
+	if ctx.Cli.Store.Migrate.Wipe {
+		if err = storage.Wipe(src); err != nil {
+			return fmt.Errorf("Unable to wipe %s SecureStore: %s", from, err.Error())
+		}
+		fmt.Printf("Deleted all records from %s\n", from)
+	}
+
+	if ctx.Settings.SecureStore != to {
+		log.Warnf("Remember to set `SecureStore: %s` in %s", to, ctx.Cli.ConfigFile)
+	}
+	return nil
+}
+
+// fileKeyringConfig returns the keyring config for the file SecureStore
+func fileKeyringConfig(ctx *RunContext) (*keyring.Config, error) {
+	if ctx.Settings.SecureStore != "file" {
+		return nil, fmt.Errorf("Command requires `SecureStore: file`, not %s", ctx.Settings.SecureStore)
+	}
+	return storage.NewKeyringConfig(ctx.Settings.SecureStore, CONFIG_DIR)
+}
+
+type StorePasswdCmd struct{}
+
+func (cc *StorePasswdCmd) Run(ctx *RunContext) error {
+	cfg, err := fileKeyringConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	count, err := storage.ChangeFileKeyringPassword(cfg)
+	if err != nil {
+		return fmt.Errorf("Unable to change password: %s", err.Error())
+	}
+	fmt.Printf("Re-encrypted %d records with the new password\n", count)
+
+	if os.Getenv(storage.ENV_SSO_FILE_PASSWORD) != "" {
+		log.Warnf("Remember to update $%s", storage.ENV_SSO_FILE_PASSWORD)
+	}
+	log.Warnf("Restart any running aws-sso agent or ECS Server so they use the new password")
+	return nil
+}
+
+type StoreUnlockCmd struct {
+	IdleTimeout time.Duration `kong:"help='Lock the SecureStore after it has not been used for this long',default='60m'"`
+}
+
+func (cc *StoreUnlockCmd) Run(ctx *RunContext) error {
+	cfg, err := fileKeyringConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	timeout := ctx.Cli.Store.Unlock.IdleTimeout
+	if timeout <= 0 {
+		timeout = storage.DEFAULT_SESSION_IDLE_TIMEOUT
+	}
+	session, err := storage.UnlockFileKeyring(cfg, timeout)
+	if err != nil {
+		return fmt.Errorf("Unable to unlock SecureStore: %s", err.Error())
+	}
+
+	// meant to be used via: eval $(aws-sso store unlock)
+	fmt.Printf("export %s=%s\n", storage.ENV_SSO_FILE_SESSION, session)
+	log.Infof("SecureStore unlocked until it is unused for %s", timeout.String())
+	return nil
+}
+
+type StoreLockCmd struct{}
+
+func (cc *StoreLockCmd) Run(ctx *RunContext) error {
+	cfg, err := fileKeyringConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = storage.LockFileKeyring(cfg); err != nil {
+		return fmt.Errorf("Unable to lock SecureStore: %s", err.Error())
+	}
+	log.Infof("SecureStore locked")
+	return nil
+}
+
+type StoreGcCmd struct {
+	DryRun bool `kong:"help='Report the records which would be removed without removing them'"`
+}
+
+func (cc *StoreGcCmd) Run(ctx *RunContext) error {
+	dryRun := ctx.Cli.Store.Gc.DryRun
+	report, err := storage.GarbageCollect(ctx.Store, configuredSSONames(ctx), dryRun)
+	if err != nil {
+		return err
+	}
+
+	action := "Removed"
+	if dryRun {
+		action = "Would remove"
+	}
+	for _, arn := range report.RoleCredentials {
+		fmt.Printf("%s expired RoleCredentials: %s\n", action, arn)
+	}
+	for _, key := range report.CreateTokenResponse {
+		fmt.Printf("%s orphaned CreateTokenResponse: %s\n", action, key)
+	}
+	for _, key := range report.RegisterClientData {
+		fmt.Printf("%s expired RegisterClientData: %s\n", action, key)
+	}
+	fmt.Printf("%s %s\n", action, report.String())
+
+	if !dryRun {
+		ctx.Settings.Cache.LastGC = time.Now().Unix()
+		if err = ctx.Settings.Cache.Save(false); err != nil {
+			log.WithError(err).Warnf("Unable to update cache")
+		}
+	}
+	return nil
+}
+
+// configuredSSONames returns the names of the SSO instances in our config
+func configuredSSONames(ctx *RunContext) []string {
+	names := []string{}
+	for name := range ctx.Settings.SSO {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// autoGarbageCollect removes expired & orphaned records from the SecureStore
+// if we haven't done so in the last STORE_GC_INTERVAL
+func autoGarbageCollect(ctx *RunContext) {
+	cache := ctx.Settings.Cache
+	if time.Since(time.Unix(cache.LastGC, 0)) < STORE_GC_INTERVAL {
+		return
+	}
+
+	report, err := storage.GarbageCollect(ctx.Store, configuredSSONames(ctx), false)
+	if err != nil {
+		log.WithError(err).Warnf("Unable to garbage collect SecureStore")
+		return
+	}
+	if report.Count() > 0 {
+		log.Debugf("Garbage collected SecureStore: %s", report.String())
+	}
+
+	cache.LastGC = time.Now().Unix()
+	if err = cache.Save(false); err != nil {
+		log.WithError(err).Warnf("Unable to update cache")
+	}
+}
@@ -36,8 +36,11 @@ func (cc *TagsCmd) Run(ctx *RunContext) error {
 	cache := ctx.Settings.Cache.GetSSO()
 	if ctx.Cli.Tags.ForceUpdate {
 		s := set.SSO[ctx.Cli.SSO]
-		awssso := sso.NewAWSSSO(s, &ctx.Store)
-		err := awssso.Authenticate(ctx.Settings.UrlAction, ctx.Settings.Browser)
+		awssso, err := sso.NewAWSSSO(s, &ctx.Store)
+		if err != nil {
+			log.Fatalf("%s", err.Error())
+		}
+		err = awssso.Authenticate(ctx.Settings.UrlAction, ctx.Settings.Browser)
 		if err != nil {
 			log.WithError(err).Fatalf("Unable to authenticate")
 		}
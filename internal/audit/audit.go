@@ -0,0 +1,206 @@
+package audit
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+const (
+	SOURCE_STORE        = "store"      // credentials from the SecureStore
+	SOURCE_AWS          = "aws"        // credentials from AWS SSO
+	SOURCE_AGENT        = "agent"      // credentials from the aws-sso agent
+	SOURCE_ECS_SERVER   = "ecs-server" // credentials loaded in the ECS Server
+	DEFAULT_MAX_SIZE    = 10           // MB
+	DEFAULT_MAX_BACKUPS = 5
+	MAX_RECORD_BYTES    = 64 * 1024
+)
+
+// Record is a single line in the audit log describing when aws-sso handed
+// out credentials
+type Record struct {
+	Time    time.Time `json:"Time"`
+	SSO     string    `json:"SSO,omitempty"`
+	RoleArn string    `json:"RoleArn"`
+	Command string    `json:"Command"`          // aws-sso sub-command
+	Parent  string    `json:"Parent,omitempty"` // name of our parent process
+	Client  string    `json:"Client,omitempty"` // remote address of the ECS Server client
+	Source  string    `json:"Source"`           // SOURCE_*
+}
+
+// Logger appends Records to a JSON lines file which is rotated once it
+// exceeds maxSize bytes, keeping maxBackups old files as <file>.1 to <file>.N
+type Logger struct {
+	file       string
+	maxSize    int64
+	maxBackups int
+}
+
+// NewLogger returns a Logger for the given file.  maxSize is in MB.
+func NewLogger(file string, maxSize, maxBackups int) *Logger {
+	if maxSize <= 0 {
+		maxSize = DEFAULT_MAX_SIZE
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+	return &Logger{
+		file:       utils.GetHomePath(file),
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+}
+
+// File returns the path of our current log file
+func (l *Logger) File() string {
+	return l.file
+}
+
+// Log appends the Record to the audit log.  Log is a no-op on a nil Logger
+// so callers don't need to check if auditing is enabled.
+func (l *Logger) Log(r Record) error {
+	if l == nil {
+		return nil
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	// other aws-sso processes may be logging at the same time
+	return utils.WithFileLock(l.file, true, func() error {
+		if info, err := os.Stat(l.file); err == nil && info.Size()+int64(len(line)) > l.maxSize {
+			if err = l.rotate(); err != nil {
+				return fmt.Errorf("Unable to rotate %s: %s", l.file, err.Error())
+			}
+		}
+
+		f, err := os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(line); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// rotate renames <file> to <file>.1, <file>.1 to <file>.2, etc. removing the
+// oldest.  Caller must hold the lock.
+func (l *Logger) rotate() error {
+	if l.maxBackups == 0 {
+		return os.Remove(l.file)
+	}
+
+	oldest := l.backup(l.maxBackups)
+	if err := os.Remove(oldest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := l.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.file, l.backup(1))
+}
+
+// backup returns the file name of the given rotated log
+func (l *Logger) backup(i int) string {
+	return fmt.Sprintf("%s.%d", l.file, i)
+}
+
+// Files returns all of our log files from oldest to newest
+func (l *Logger) Files() []string {
+	files := []string{}
+	for i := l.maxBackups; i > 0; i-- {
+		files = append(files, l.backup(i))
+	}
+	return append(files, l.file)
+}
+
+// Filter selects which Records are returned by Query
+type Filter struct {
+	Role  string    // role ARN or role name
+	Since time.Time // inclusive
+	Until time.Time // inclusive
+}
+
+// Match returns true if the Record matches our Filter
+func (f Filter) Match(r Record) bool {
+	if f.Role != "" && r.RoleArn != f.Role && !strings.HasSuffix(r.RoleArn, "/"+f.Role) {
+		return false
+	}
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the Records matching the Filter from oldest to newest
+func (l *Logger) Query(f Filter) ([]Record, error) {
+	records := []Record{}
+
+	err := utils.WithFileLock(l.file, false, func() error {
+		for _, file := range l.Files() {
+			fh, err := os.Open(file)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			scanner := bufio.NewScanner(fh)
+			scanner.Buffer(make([]byte, 4096), MAX_RECORD_BYTES)
+			for line := 1; scanner.Scan(); line++ {
+				r := Record{}
+				if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+					log.WithError(err).Warnf("Skipping invalid record at %s:%d", file, line)
+					continue
+				}
+				if f.Match(r) {
+					records = append(records, r)
+				}
+			}
+			err = scanner.Err()
+			fh.Close()
+			if err != nil {
+				return fmt.Errorf("Unable to read %s: %s", file, err.Error())
+			}
+		}
+		return nil
+	})
+	return records, err
+}
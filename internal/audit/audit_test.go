@@ -0,0 +1,142 @@
+package audit
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogQuery(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLogger(filepath.Join(dir, "audit.log"), 1, 2)
+	assert.Equal(t, filepath.Join(dir, "audit.log"), l.File())
+
+	now := time.Now()
+	assert.NoError(t, l.Log(Record{
+		Time:    now.Add(-2 * time.Hour),
+		SSO:     "Default",
+		RoleArn: "arn:aws:iam::123456789012:role/Admin",
+		Command: "exec",
+		Source:  SOURCE_AWS,
+	}))
+	assert.NoError(t, l.Log(Record{
+		SSO:     "Default",
+		RoleArn: "arn:aws:iam::123456789012:role/ReadOnly",
+		Command: "eval",
+		Source:  SOURCE_STORE,
+	}))
+
+	info, err := os.Stat(l.File())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	records, err := l.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Admin", records[0].RoleArn)
+	assert.False(t, records[1].Time.IsZero())
+
+	records, err = l.Query(Filter{Role: "ReadOnly"})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, SOURCE_STORE, records[0].Source)
+
+	records, err = l.Query(Filter{Role: "Read"})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	records, err = l.Query(Filter{Role: "arn:aws:iam::123456789012:role/Admin"})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	records, err = l.Query(Filter{Since: now.Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "eval", records[0].Command)
+
+	records, err = l.Query(Filter{Until: now.Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "exec", records[0].Command)
+
+	// invalid lines are skipped
+	f, err := os.OpenFile(l.File(), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString("not json\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	records, err = l.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLogger(filepath.Join(dir, "audit.log"), 1, 2)
+	l.maxSize = 1024 // bytes, so we rotate quickly
+
+	arn := "arn:aws:iam::123456789012:role/" + strings.Repeat("x", 100)
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, l.Log(Record{
+			RoleArn: arn,
+			Command: fmt.Sprintf("%d", i),
+			Source:  SOURCE_AWS,
+		}))
+	}
+
+	for _, file := range l.Files() {
+		info, err := os.Stat(file)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), l.maxSize)
+	}
+	_, err := os.Stat(l.backup(3))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// the oldest records were removed and the rest are in order
+	records, err := l.Query(Filter{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, records)
+	assert.Less(t, len(records), 40)
+	assert.Equal(t, "39", records[len(records)-1].Command)
+	for i := 1; i < len(records); i++ {
+		assert.False(t, records[i].Time.Before(records[i-1].Time))
+	}
+
+	// no backups
+	l = NewLogger(filepath.Join(dir, "nobackup.log"), 1, -1)
+	l.maxSize = 1024
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, l.Log(Record{RoleArn: arn, Source: SOURCE_AWS}))
+	}
+	assert.Equal(t, []string{l.File()}, l.Files())
+	_, err = os.Stat(l.backup(1))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	assert.NoError(t, l.Log(Record{RoleArn: "arn:aws:iam::123456789012:role/Admin"}))
+}
@@ -103,26 +103,28 @@ func (a *AwsConfig) StaticProfiles() ([]Profile, error) {
 	return profiles, nil
 }
 
+// UserArnFunc validates the static credentials and returns the ARN of their
+// IAM user, ie: AWSSSO.StaticUserArn
+type UserArnFunc func(*storage.StaticCredentials) (string, error)
+
 // UpdateSecureStore writes any new role ARN credentials to the provided SecureStorage
-func (a *AwsConfig) UpdateSecureStore(store storage.SecureStorage) error {
+func (a *AwsConfig) UpdateSecureStore(store storage.SecureStorage, userArn UserArnFunc) error {
 	profiles, err := a.StaticProfiles()
 	if err != nil {
 		return err
 	}
 
 	for _, p := range profiles {
-		arn, err := p.GetArn()
-		if err != nil {
-			return err
-		}
-		accountid, username, _ := utils.ParseUserARN(arn)
-
 		creds := storage.StaticCredentials{
-			UserName:        username,
-			AccountId:       accountid,
 			AccessKeyId:     p.AccessKeyId,
 			SecretAccessKey: p.SecretAccessKey,
 		}
+		arn, err := userArn(&creds)
+		if err != nil {
+			return err
+		}
+		creds.AccountId, creds.UserName, _ = utils.ParseUserARN(arn)
+
 		if err = store.SaveStaticCredentials(arn, creds); err != nil {
 			return err
 		}
@@ -18,16 +18,6 @@ package awscreds
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-import (
-	"context"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/iam"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
-)
-
 type Profile struct {
 	// Required for import of static creds
 	FromConfig      bool
@@ -63,52 +53,3 @@ type Profile struct {
 		TcpKeepalive         bool   `ini:"tcp_keepalive"`
 	*/
 }
-
-func (p *Profile) config() (aws.Config, error) {
-	creds := credentials.NewStaticCredentialsProvider(
-		p.AccessKeyId,
-		p.SecretAccessKey,
-		"", // sessionToken
-	)
-	return config.LoadDefaultConfig(
-		context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithCredentialsProvider(creds),
-	)
-}
-
-// GetArn uses the credentials to call sts:GetCallerIdentity to retrieve the ARN
-func (p *Profile) GetArn() (string, error) {
-	cfg, err := p.config()
-	if err != nil {
-		return "", err
-	}
-
-	s := sts.NewFromConfig(cfg)
-	out, err := s.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
-	if err != nil {
-		return "", err
-	}
-
-	return aws.ToString(out.Arn), nil
-}
-
-// GetAccountAlias calls iam:ListAccountAliases to retrieve the AWS Account
-// Alias.  Returns an empty string if no alias has been set.
-func (p *Profile) GetAccountAlias() string {
-	cfg, err := p.config()
-	if err != nil {
-		return ""
-	}
-
-	i := iam.NewFromConfig(cfg)
-	out, err := i.ListAccountAliases(context.TODO(), &iam.ListAccountAliasesInput{})
-	if err != nil {
-		return ""
-	}
-
-	if len(out.AccountAliases) > 0 {
-		return out.AccountAliases[0]
-	}
-	return ""
-}
@@ -0,0 +1,427 @@
+package fakeaws
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The API operations served by the fake.  Use them with Fail(), Throttle(),
+// Calls() and Requests().
+const (
+	OP_REGISTER_CLIENT             = "RegisterClient"
+	OP_START_DEVICE_AUTHORIZATION  = "StartDeviceAuthorization"
+	OP_CREATE_TOKEN                = "CreateToken"
+	OP_AUTHORIZE                   = "Authorize"
+	OP_LIST_ACCOUNTS               = "ListAccounts"
+	OP_LIST_ACCOUNT_ROLES          = "ListAccountRoles"
+	OP_GET_ROLE_CREDENTIALS        = "GetRoleCredentials"
+	OP_LOGOUT                      = "Logout"
+	OP_ASSUME_ROLE                 = "AssumeRole"
+	OP_GET_CALLER_IDENTITY         = "GetCallerIdentity"
+	OP_GET_FEDERATION_TOKEN        = "GetFederationToken"
+	OP_GET_SESSION_TOKEN           = "GetSessionToken"
+	OP_GET_SIGNIN_TOKEN            = "GetSigninToken"
+	DEFAULT_CREDENTIAL_DURATION    = time.Hour
+	DEFAULT_TOKEN_DURATION         = 8 * time.Hour
+	DEFAULT_CLIENT_SECRET_DURATION = 90 * 24 * time.Hour
+)
+
+// Account is an AWS account which the SSO user has access to
+type Account struct {
+	Id    string
+	Name  string
+	Email string
+	Roles []string
+}
+
+// Request is a copy of an API call made to the fake
+type Request struct {
+	Op     string
+	Header http.Header
+	Query  url.Values
+	Form   url.Values // STS parameters
+	Body   []byte
+}
+
+// failure is a scripted error returned by the next calls to an operation
+type failure struct {
+	status  int
+	code    string
+	message string
+	times   int
+}
+
+// identity is who owns an access key issued by, or added to, the fake
+type identity struct {
+	Arn       string
+	UserId    string
+	AccountId string
+	Secret    string
+	Expires   time.Time
+	Mfa       bool // session was issued using an MFA token
+}
+
+// Server is a fake AWS SSO portal, SSO OIDC, STS & console sign-in endpoint.
+// Point aws-sso at URL via $AWS_SSO_ENDPOINT_URL.
+type Server struct {
+	*httptest.Server
+	lock               sync.Mutex
+	accounts           []Account
+	pageSize           int
+	pendingPolls       int
+	denyDeviceAuth     bool
+	credentialDuration time.Duration
+	tokenDuration      time.Duration
+	failures           map[string][]*failure
+	requests           map[string][]Request
+	clients            map[string]string    // clientId => clientSecret
+	deviceCodes        map[string]int       // deviceCode => remaining pending polls
+	authCodes          map[string]string    // code => PKCE challenge
+	accessTokens       map[string]time.Time // => expires
+	refreshTokens      map[string]bool
+	keys               map[string]identity // AccessKeyId => owner
+	mfaDevices         map[string]string   // SerialNumber => TokenCode
+	mfaRoles           map[string]bool     // RoleArn => requires MFA
+	counter            int
+}
+
+// New starts a new fake AWS which must be closed via Close()
+func New() *Server {
+	s := newServer()
+	s.Start()
+	return s
+}
+
+// NewTLS starts a new fake AWS using HTTPS with a self-signed certificate
+// which must be closed via Close()
+func NewTLS() *Server {
+	s := newServer()
+	s.StartTLS()
+	return s
+}
+
+// newServer returns our fake AWS which has not been started
+func newServer() *Server {
+	s := &Server{
+		credentialDuration: DEFAULT_CREDENTIAL_DURATION,
+		tokenDuration:      DEFAULT_TOKEN_DURATION,
+		failures:           map[string][]*failure{},
+		requests:           map[string][]Request{},
+		clients:            map[string]string{},
+		deviceCodes:        map[string]int{},
+		authCodes:          map[string]string{},
+		accessTokens:       map[string]time.Time{},
+		refreshTokens:      map[string]bool{},
+		keys:               map[string]identity{},
+		mfaDevices:         map[string]string{},
+		mfaRoles:           map[string]bool{},
+	}
+
+	mux := http.NewServeMux()
+	// SSO OIDC
+	mux.HandleFunc("/client/register", s.handle(OP_REGISTER_CLIENT, s.registerClient))
+	mux.HandleFunc("/device_authorization", s.handle(OP_START_DEVICE_AUTHORIZATION, s.startDeviceAuthorization))
+	mux.HandleFunc("/token", s.handle(OP_CREATE_TOKEN, s.createToken))
+	mux.HandleFunc("/authorize", s.handle(OP_AUTHORIZE, s.authorize))
+	// SSO portal
+	mux.HandleFunc("/assignment/accounts", s.handle(OP_LIST_ACCOUNTS, s.listAccounts))
+	mux.HandleFunc("/assignment/roles", s.handle(OP_LIST_ACCOUNT_ROLES, s.listAccountRoles))
+	mux.HandleFunc("/federation/credentials", s.handle(OP_GET_ROLE_CREDENTIALS, s.getRoleCredentials))
+	mux.HandleFunc("/logout", s.handle(OP_LOGOUT, s.logout))
+	// console sign-in
+	mux.HandleFunc("/federation", s.handle(OP_GET_SIGNIN_TOKEN, s.getSigninToken))
+	// STS uses the query protocol, so the operation is in the form
+	mux.HandleFunc("/", s.sts)
+
+	s.Server = httptest.NewUnstartedServer(mux)
+	return s
+}
+
+// AddAccount adds an AWS account and the roles the SSO user can access in it
+func (s *Server) AddAccount(id, name, email string, roles ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accounts = append(s.accounts, Account{
+		Id:    id,
+		Name:  name,
+		Email: email,
+		Roles: roles,
+	})
+}
+
+// AddUser adds static IAM user credentials which can be used with STS
+func (s *Server) AddUser(accountId, userName, accessKeyId, secretAccessKey string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys[accessKeyId] = identity{
+		Arn:       fmt.Sprintf("arn:aws:iam::%s:user/%s", accountId, userName),
+		UserId:    "AIDA" + accessKeyId,
+		AccountId: accountId,
+		Secret:    secretAccessKey,
+	}
+}
+
+// AddMfaDevice adds an MFA device which accepts the given token code
+func (s *Server) AddMfaDevice(serial, code string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mfaDevices[serial] = code
+}
+
+// RequireMfa makes AssumeRole of the role fail unless the caller provides a
+// valid MFA token or is using a session which was issued with one
+func (s *Server) RequireMfa(roleArn string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mfaRoles[roleArn] = true
+}
+
+// SetPageSize limits how many accounts or roles are returned per page
+func (s *Server) SetPageSize(size int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pageSize = size
+}
+
+// SetPendingPolls sets how many times CreateToken returns
+// AuthorizationPendingException before the user "approves" the device code
+func (s *Server) SetPendingPolls(polls int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pendingPolls = polls
+}
+
+// DenyDeviceAuth makes the user reject every device code
+func (s *Server) DenyDeviceAuth(deny bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.denyDeviceAuth = deny
+}
+
+// SetCredentialDuration sets how long role credentials are valid for
+func (s *Server) SetCredentialDuration(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.credentialDuration = d
+}
+
+// SetTokenDuration sets how long SSO access tokens are valid for
+func (s *Server) SetTokenDuration(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokenDuration = d
+}
+
+// ExpireTokens invalidates all of the SSO access tokens, but not the refresh tokens
+func (s *Server) ExpireTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accessTokens = map[string]time.Time{}
+}
+
+// RevokeRefreshTokens invalidates all of the SSO refresh tokens
+func (s *Server) RevokeRefreshTokens() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.refreshTokens = map[string]bool{}
+}
+
+// Fail makes the next times calls to op return the given HTTP status and
+// AWS error code
+func (s *Server) Fail(op string, status int, code, message string, times int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failures[op] = append(s.failures[op], &failure{
+		status:  status,
+		code:    code,
+		message: message,
+		times:   times,
+	})
+}
+
+// Throttle makes the next times calls to op fail with a throttling error
+// which the AWS SDK retries
+func (s *Server) Throttle(op string, times int) {
+	switch op {
+	case OP_ASSUME_ROLE, OP_GET_CALLER_IDENTITY, OP_GET_FEDERATION_TOKEN, OP_GET_SESSION_TOKEN:
+		s.Fail(op, http.StatusBadRequest, "Throttling", "Rate exceeded", times)
+	default:
+		s.Fail(op, http.StatusTooManyRequests, "TooManyRequestsException", "Rate exceeded", times)
+	}
+}
+
+// Calls returns how many times op was called, including failed calls
+func (s *Server) Calls(op string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.requests[op])
+}
+
+// Requests returns all of the calls to op
+func (s *Server) Requests(op string) []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Request{}, s.requests[op]...)
+}
+
+// apiError is returned by a handler to generate an AWS error response
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.code, e.message)
+}
+
+func newError(status int, code, message string) *apiError {
+	return &apiError{
+		status:  status,
+		code:    code,
+		message: message,
+	}
+}
+
+// handler implements an operation using a restJson protocol.  It is called
+// with our lock held and returns the response to be JSON encoded.
+type handler func(r *http.Request, body []byte) (interface{}, error)
+
+// handle wraps a handler with request recording, scripted failures & encoding
+func (s *Server) handle(op string, h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.lock.Lock()
+		ret, err := s.record(op, r, body, nil)
+		if err == nil {
+			ret, err = h(r, body)
+		}
+		s.lock.Unlock()
+
+		if redirect, ok := ret.(*url.URL); ok && err == nil {
+			http.Redirect(w, r, redirect.String(), http.StatusFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if e, ok := err.(*apiError); ok {
+			w.Header().Set("X-Amzn-ErrorType", e.code)
+			w.WriteHeader(e.status)
+			ret = map[string]string{
+				"error":   e.code,
+				"message": e.message,
+			}
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			ret = map[string]string{"message": err.Error()}
+		}
+		_ = json.NewEncoder(w).Encode(ret)
+	}
+}
+
+// record saves the request and returns any scripted failure for it.
+// Caller must hold our lock.
+func (s *Server) record(op string, r *http.Request, body []byte, form url.Values) (interface{}, error) {
+	s.requests[op] = append(s.requests[op], Request{
+		Op:     op,
+		Header: r.Header.Clone(),
+		Query:  r.URL.Query(),
+		Form:   form,
+		Body:   body,
+	})
+
+	for len(s.failures[op]) > 0 {
+		f := s.failures[op][0]
+		if f.times <= 0 {
+			s.failures[op] = s.failures[op][1:]
+			continue
+		}
+		f.times--
+		return nil, newError(f.status, f.code, f.message)
+	}
+	return nil, nil
+}
+
+// nextId returns a unique string with the given prefix.  Caller must hold our lock.
+func (s *Server) nextId(prefix string) string {
+	s.counter++
+	return fmt.Sprintf("%s%016d", prefix, s.counter)
+}
+
+// page returns the start & end index of the page of n items and the next token
+func (s *Server) page(r *http.Request, n int) (int, int, *string, error) {
+	start := 0
+	if token := r.URL.Query().Get("next_token"); token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > n {
+			return 0, 0, nil, newError(http.StatusBadRequest, "InvalidRequestException", "Invalid next_token")
+		}
+	}
+
+	size := n
+	if max, err := strconv.Atoi(r.URL.Query().Get("max_result")); err == nil && max > 0 {
+		size = max
+	}
+	if s.pageSize > 0 && s.pageSize < size {
+		size = s.pageSize
+	}
+
+	end := start + size
+	if end >= n {
+		return start, n, nil, nil
+	}
+	next := strconv.Itoa(end)
+	return start, end, &next, nil
+}
+
+// account returns the account with the given id.  Caller must hold our lock.
+func (s *Server) account(id string) (Account, bool) {
+	for _, a := range s.accounts {
+		if a.Id == id {
+			return a, true
+		}
+	}
+	return Account{}, false
+}
+
+// issueCredentials returns new role credentials.  Caller must hold our lock.
+func (s *Server) issueCredentials(arn, userId, accountId string, duration time.Duration, mfa bool) (string, string, string, time.Time) {
+	accessKeyId := s.nextId("ASIA")
+	secret := s.nextId("secret")
+	expires := time.Now().Add(duration).Truncate(time.Second)
+	s.keys[accessKeyId] = identity{
+		Arn:       arn,
+		UserId:    userId,
+		AccountId: accountId,
+		Secret:    secret,
+		Expires:   expires,
+		Mfa:       mfa,
+	}
+	return accessKeyId, secret, s.nextId("token"), expires
+}
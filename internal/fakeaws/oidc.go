@@ -0,0 +1,206 @@
+package fakeaws
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	GRANT_DEVICE_CODE        = "urn:ietf:params:oauth:grant-type:device_code"
+	GRANT_AUTHORIZATION_CODE = "authorization_code"
+	GRANT_REFRESH_TOKEN      = "refresh_token"
+)
+
+// oidcRequest contains all of the fields of the OIDC requests we support
+type oidcRequest struct {
+	ClientName   string   `json:"clientName"`
+	ClientType   string   `json:"clientType"`
+	Scopes       []string `json:"scopes"`
+	ClientId     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	StartUrl     string   `json:"startUrl"`
+	GrantType    string   `json:"grantType"`
+	DeviceCode   string   `json:"deviceCode"`
+	Code         string   `json:"code"`
+	CodeVerifier string   `json:"codeVerifier"`
+	RedirectUri  string   `json:"redirectUri"`
+	RefreshToken string   `json:"refreshToken"`
+}
+
+// parseOidc decodes the request & validates the client.  Caller must hold our lock.
+func (s *Server) parseOidc(body []byte, checkClient bool) (oidcRequest, error) {
+	req := oidcRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, newError(http.StatusBadRequest, "InvalidRequestException", err.Error())
+	}
+	if checkClient {
+		if secret, ok := s.clients[req.ClientId]; !ok || secret != req.ClientSecret {
+			return req, newError(http.StatusUnauthorized, "InvalidClientException", "Invalid client")
+		}
+	}
+	return req, nil
+}
+
+func (s *Server) registerClient(r *http.Request, body []byte) (interface{}, error) {
+	req, err := s.parseOidc(body, false)
+	if err != nil {
+		return nil, err
+	}
+	if req.ClientName == "" || req.ClientType == "" {
+		return nil, newError(http.StatusBadRequest, "InvalidRequestException", "Missing clientName or clientType")
+	}
+
+	clientId := s.nextId("client")
+	s.clients[clientId] = s.nextId("secret")
+	now := time.Now()
+	return struct {
+		ClientId              string `json:"clientId"`
+		ClientSecret          string `json:"clientSecret"`
+		ClientIdIssuedAt      int64  `json:"clientIdIssuedAt"`
+		ClientSecretExpiresAt int64  `json:"clientSecretExpiresAt"`
+		AuthorizationEndpoint string `json:"authorizationEndpoint"`
+		TokenEndpoint         string `json:"tokenEndpoint"`
+	}{
+		ClientId:              clientId,
+		ClientSecret:          s.clients[clientId],
+		ClientIdIssuedAt:      now.Unix(),
+		ClientSecretExpiresAt: now.Add(DEFAULT_CLIENT_SECRET_DURATION).Unix(),
+		AuthorizationEndpoint: s.URL + "/authorize",
+		TokenEndpoint:         s.URL + "/token",
+	}, nil
+}
+
+func (s *Server) startDeviceAuthorization(r *http.Request, body []byte) (interface{}, error) {
+	req, err := s.parseOidc(body, true)
+	if err != nil {
+		return nil, err
+	}
+	if req.StartUrl == "" {
+		return nil, newError(http.StatusBadRequest, "InvalidRequestException", "Missing startUrl")
+	}
+
+	deviceCode := s.nextId("device")
+	s.deviceCodes[deviceCode] = s.pendingPolls
+	userCode := s.nextId("")[12:]
+	return struct {
+		DeviceCode              string `json:"deviceCode"`
+		UserCode                string `json:"userCode"`
+		VerificationUri         string `json:"verificationUri"`
+		VerificationUriComplete string `json:"verificationUriComplete"`
+		ExpiresIn               int32  `json:"expiresIn"`
+		Interval                int32  `json:"interval"`
+	}{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationUri:         s.URL + "/device",
+		VerificationUriComplete: s.URL + "/device?user_code=" + userCode,
+		ExpiresIn:               600,
+		Interval:                1,
+	}, nil
+}
+
+// authorize is the browser side of the authorization code flow.  The user
+// approves immediately and is redirected back to aws-sso.
+func (s *Server) authorize(r *http.Request, body []byte) (interface{}, error) {
+	query := r.URL.Query()
+	if _, ok := s.clients[query.Get("client_id")]; !ok {
+		return nil, newError(http.StatusUnauthorized, "InvalidClientException", "Invalid client")
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" {
+		return nil, newError(http.StatusBadRequest, "InvalidRequestException", "Invalid redirect_uri")
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return nil, newError(http.StatusBadRequest, "InvalidRequestException", "PKCE is required")
+	}
+
+	code := s.nextId("code")
+	s.authCodes[code] = query.Get("code_challenge")
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	return redirect, nil
+}
+
+func (s *Server) createToken(r *http.Request, body []byte) (interface{}, error) {
+	req, err := s.parseOidc(body, true)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case GRANT_DEVICE_CODE:
+		polls, ok := s.deviceCodes[req.DeviceCode]
+		switch {
+		case !ok:
+			return nil, newError(http.StatusBadRequest, "InvalidGrantException", "Invalid device code")
+		case s.denyDeviceAuth:
+			delete(s.deviceCodes, req.DeviceCode)
+			return nil, newError(http.StatusBadRequest, "AccessDeniedException", "User denied access")
+		case polls > 0:
+			s.deviceCodes[req.DeviceCode] = polls - 1
+			return nil, newError(http.StatusBadRequest, "AuthorizationPendingException", "Authorization pending")
+		}
+		delete(s.deviceCodes, req.DeviceCode)
+
+	case GRANT_AUTHORIZATION_CODE:
+		challenge, ok := s.authCodes[req.Code]
+		if !ok {
+			return nil, newError(http.StatusBadRequest, "InvalidGrantException", "Invalid code")
+		}
+		delete(s.authCodes, req.Code)
+		hash := sha256.Sum256([]byte(req.CodeVerifier))
+		if base64.RawURLEncoding.EncodeToString(hash[:]) != challenge {
+			return nil, newError(http.StatusBadRequest, "InvalidGrantException", "Invalid code verifier")
+		}
+
+	case GRANT_REFRESH_TOKEN:
+		if !s.refreshTokens[req.RefreshToken] {
+			return nil, newError(http.StatusBadRequest, "InvalidGrantException", "Invalid refresh token")
+		}
+		delete(s.refreshTokens, req.RefreshToken)
+
+	default:
+		return nil, newError(http.StatusBadRequest, "UnsupportedGrantTypeException", "Unsupported grant type")
+	}
+
+	accessToken := s.nextId("access")
+	refreshToken := s.nextId("refresh")
+	s.accessTokens[accessToken] = time.Now().Add(s.tokenDuration)
+	s.refreshTokens[refreshToken] = true
+	return struct {
+		AccessToken  string `json:"accessToken"`
+		TokenType    string `json:"tokenType"`
+		ExpiresIn    int32  `json:"expiresIn"`
+		RefreshToken string `json:"refreshToken"`
+	}{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int32(s.tokenDuration.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package fakeaws
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// bearerToken validates the SSO access token of the request.  Caller must
+// hold our lock.
+func (s *Server) bearerToken(r *http.Request) (string, error) {
+	token := r.Header.Get("x-amz-sso_bearer_token")
+	expires, ok := s.accessTokens[token]
+	if !ok || time.Now().After(expires) {
+		return "", newError(http.StatusUnauthorized, "UnauthorizedException", "Session token not found or invalid")
+	}
+	return token, nil
+}
+
+type accountInfo struct {
+	AccountId    string `json:"accountId"`
+	AccountName  string `json:"accountName"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+func (s *Server) listAccounts(r *http.Request, body []byte) (interface{}, error) {
+	if _, err := s.bearerToken(r); err != nil {
+		return nil, err
+	}
+
+	start, end, next, err := s.page(r, len(s.accounts))
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []accountInfo{}
+	for _, a := range s.accounts[start:end] {
+		accounts = append(accounts, accountInfo{
+			AccountId:    a.Id,
+			AccountName:  a.Name,
+			EmailAddress: a.Email,
+		})
+	}
+	return struct {
+		AccountList []accountInfo `json:"accountList"`
+		NextToken   *string       `json:"nextToken,omitempty"`
+	}{accounts, next}, nil
+}
+
+type roleInfo struct {
+	AccountId string `json:"accountId"`
+	RoleName  string `json:"roleName"`
+}
+
+func (s *Server) listAccountRoles(r *http.Request, body []byte) (interface{}, error) {
+	if _, err := s.bearerToken(r); err != nil {
+		return nil, err
+	}
+
+	account, ok := s.account(r.URL.Query().Get("account_id"))
+	if !ok {
+		return nil, newError(http.StatusNotFound, "ResourceNotFoundException", "Account not found")
+	}
+
+	start, end, next, err := s.page(r, len(account.Roles))
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []roleInfo{}
+	for _, role := range account.Roles[start:end] {
+		roles = append(roles, roleInfo{
+			AccountId: account.Id,
+			RoleName:  role,
+		})
+	}
+	return struct {
+		RoleList  []roleInfo `json:"roleList"`
+		NextToken *string    `json:"nextToken,omitempty"`
+	}{roles, next}, nil
+}
+
+type roleCredentials struct {
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+	Expiration      int64  `json:"expiration"` // msec
+}
+
+func (s *Server) getRoleCredentials(r *http.Request, body []byte) (interface{}, error) {
+	if _, err := s.bearerToken(r); err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	account, ok := s.account(query.Get("account_id"))
+	if !ok {
+		return nil, newError(http.StatusForbidden, "ForbiddenException", "No access")
+	}
+	role := query.Get("role_name")
+	found := false
+	for _, r := range account.Roles {
+		found = found || r == role
+	}
+	if !found {
+		return nil, newError(http.StatusForbidden, "ForbiddenException", "No access")
+	}
+
+	session := "aws-sso-user"
+	arn := fmt.Sprintf("arn:aws:sts::%s:assumed-role/AWSReservedSSO_%s/%s", account.Id, role, session)
+	accessKeyId, secret, token, expires := s.issueCredentials(arn, "AROA"+role+":"+session, account.Id, s.credentialDuration, false)
+	return struct {
+		RoleCredentials roleCredentials `json:"roleCredentials"`
+	}{roleCredentials{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secret,
+		SessionToken:    token,
+		Expiration:      expires.UnixMilli(),
+	}}, nil
+}
+
+func (s *Server) logout(r *http.Request, body []byte) (interface{}, error) {
+	token, err := s.bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.accessTokens, token)
+	return struct{}{}, nil
+}
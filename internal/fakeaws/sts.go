@@ -0,0 +1,350 @@
+package fakeaws
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const STS_XMLNS = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+// the AccessKeyId in the AWS SigV4 Authorization header
+var sigV4Credential = regexp.MustCompile(`Credential=([^/]+)/`)
+
+type stsCredentials struct {
+	AccessKeyId     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+type assumeRoleResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		AssumedRoleUser struct {
+			Arn           string `xml:"Arn"`
+			AssumedRoleId string `xml:"AssumedRoleId"`
+		} `xml:"AssumedRoleUser"`
+		Credentials    stsCredentials `xml:"Credentials"`
+		SourceIdentity string         `xml:"SourceIdentity,omitempty"`
+	} `xml:"AssumeRoleResult"`
+	RequestId string `xml:"ResponseMetadata>RequestId"`
+}
+
+type getCallerIdentityResponse struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Arn     string `xml:"Arn"`
+		UserId  string `xml:"UserId"`
+		Account string `xml:"Account"`
+	} `xml:"GetCallerIdentityResult"`
+	RequestId string `xml:"ResponseMetadata>RequestId"`
+}
+
+type getFederationTokenResponse struct {
+	XMLName xml.Name `xml:"GetFederationTokenResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Credentials   stsCredentials `xml:"Credentials"`
+		FederatedUser struct {
+			Arn             string `xml:"Arn"`
+			FederatedUserId string `xml:"FederatedUserId"`
+		} `xml:"FederatedUser"`
+	} `xml:"GetFederationTokenResult"`
+	RequestId string `xml:"ResponseMetadata>RequestId"`
+}
+
+type getSessionTokenResponse struct {
+	XMLName xml.Name `xml:"GetSessionTokenResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Result  struct {
+		Credentials stsCredentials `xml:"Credentials"`
+	} `xml:"GetSessionTokenResult"`
+	RequestId string `xml:"ResponseMetadata>RequestId"`
+}
+
+type stsErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Error   struct {
+		Type    string `xml:"Type"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+	RequestId string `xml:"RequestId"`
+}
+
+// sts handles the STS query protocol
+func (s *Server) sts(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	form, err := url.ParseQuery(string(body))
+	if r.Method != http.MethodPost || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.lock.Lock()
+	op := form.Get("Action")
+	var ret interface{}
+	if _, err = s.record(op, r, body, form); err == nil {
+		switch op {
+		case OP_ASSUME_ROLE:
+			ret, err = s.assumeRole(r, form)
+		case OP_GET_CALLER_IDENTITY:
+			ret, err = s.getCallerIdentity(r, form)
+		case OP_GET_FEDERATION_TOKEN:
+			ret, err = s.getFederationToken(r, form)
+		case OP_GET_SESSION_TOKEN:
+			ret, err = s.getSessionToken(r, form)
+		default:
+			err = newError(http.StatusBadRequest, "InvalidAction", "Unsupported action: "+op)
+		}
+	}
+	requestId := s.nextId("request")
+	s.lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	if err != nil {
+		e, ok := err.(*apiError)
+		if !ok {
+			e = newError(http.StatusInternalServerError, "InternalFailure", err.Error())
+		}
+		resp := stsErrorResponse{Xmlns: STS_XMLNS, RequestId: requestId}
+		resp.Error.Type = "Sender"
+		resp.Error.Code = e.code
+		resp.Error.Message = e.message
+		w.WriteHeader(e.status)
+		ret = resp
+	} else {
+		switch resp := ret.(type) {
+		case *assumeRoleResponse:
+			resp.Xmlns, resp.RequestId = STS_XMLNS, requestId
+		case *getCallerIdentityResponse:
+			resp.Xmlns, resp.RequestId = STS_XMLNS, requestId
+		case *getFederationTokenResponse:
+			resp.Xmlns, resp.RequestId = STS_XMLNS, requestId
+		case *getSessionTokenResponse:
+			resp.Xmlns, resp.RequestId = STS_XMLNS, requestId
+		}
+	}
+	_ = xml.NewEncoder(w).Encode(ret)
+}
+
+// caller returns the owner of the AccessKeyId which signed the request.
+// Caller must hold our lock.
+func (s *Server) caller(r *http.Request) (identity, error) {
+	match := sigV4Credential.FindStringSubmatch(r.Header.Get("Authorization"))
+	if match == nil {
+		return identity{}, newError(http.StatusForbidden, "MissingAuthenticationToken", "Request is missing Authentication Token")
+	}
+	id, ok := s.keys[match[1]]
+	if !ok {
+		return identity{}, newError(http.StatusForbidden, "InvalidClientTokenId", "The security token included in the request is invalid")
+	}
+	if !id.Expires.IsZero() && time.Now().After(id.Expires) {
+		return identity{}, newError(http.StatusBadRequest, "ExpiredToken", "The security token included in the request is expired")
+	}
+	return id, nil
+}
+
+// mfa returns true if the request has a valid SerialNumber & TokenCode.
+// Caller must hold our lock.
+func (s *Server) mfa(form url.Values) (bool, error) {
+	serial := form.Get("SerialNumber")
+	if serial == "" {
+		return false, nil
+	}
+	code, ok := s.mfaDevices[serial]
+	if !ok || code != form.Get("TokenCode") {
+		return false, newError(http.StatusForbidden, "AccessDenied",
+			"MultiFactorAuthentication failed with invalid MFA one time pass code.")
+	}
+	return true, nil
+}
+
+// duration returns the DurationSeconds parameter or our default
+func (s *Server) duration(form url.Values) (time.Duration, error) {
+	value := form.Get("DurationSeconds")
+	if value == "" {
+		return s.credentialDuration, nil
+	}
+	secs, err := strconv.Atoi(value)
+	if err != nil || secs < 900 || secs > 43200 {
+		return 0, newError(http.StatusBadRequest, "ValidationError", "Invalid DurationSeconds")
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+func (s *Server) assumeRole(r *http.Request, form url.Values) (interface{}, error) {
+	id, err := s.caller(r)
+	if err != nil {
+		return nil, err
+	}
+
+	roleArn := form.Get("RoleArn")
+	parts := strings.SplitN(roleArn, ":", 6)
+	if len(parts) != 6 || !strings.HasPrefix(parts[5], "role/") {
+		return nil, newError(http.StatusBadRequest, "ValidationError", "Invalid RoleArn: "+roleArn)
+	}
+	session := form.Get("RoleSessionName")
+	if session == "" {
+		return nil, newError(http.StatusBadRequest, "ValidationError", "Missing RoleSessionName")
+	}
+	duration, err := s.duration(form)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfa(form)
+	if err != nil {
+		return nil, err
+	}
+	mfa = mfa || id.Mfa
+	if s.mfaRoles[roleArn] && !mfa {
+		return nil, newError(http.StatusForbidden, "AccessDenied",
+			fmt.Sprintf("User: %s is not authorized to perform: sts:AssumeRole on resource: %s", id.Arn, roleArn))
+	}
+
+	accountId := parts[4]
+	roleName := parts[5][strings.LastIndex(parts[5], "/")+1:]
+	arn := fmt.Sprintf("arn:aws:sts::%s:assumed-role/%s/%s", accountId, roleName, session)
+	roleId := "AROA" + roleName + ":" + session
+	accessKeyId, secret, token, expires := s.issueCredentials(arn, roleId, accountId, duration, mfa)
+
+	resp := &assumeRoleResponse{}
+	resp.Result.AssumedRoleUser.Arn = arn
+	resp.Result.AssumedRoleUser.AssumedRoleId = roleId
+	resp.Result.Credentials = stsCredentials{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secret,
+		SessionToken:    token,
+		Expiration:      expires.UTC().Format(time.RFC3339),
+	}
+	resp.Result.SourceIdentity = form.Get("SourceIdentity")
+	return resp, nil
+}
+
+func (s *Server) getCallerIdentity(r *http.Request, form url.Values) (interface{}, error) {
+	id, err := s.caller(r)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &getCallerIdentityResponse{}
+	resp.Result.Arn = id.Arn
+	resp.Result.UserId = id.UserId
+	resp.Result.Account = id.AccountId
+	return resp, nil
+}
+
+func (s *Server) getFederationToken(r *http.Request, form url.Values) (interface{}, error) {
+	id, err := s.caller(r)
+	if err != nil {
+		return nil, err
+	}
+	name := form.Get("Name")
+	if name == "" {
+		return nil, newError(http.StatusBadRequest, "ValidationError", "Missing Name")
+	}
+	duration, err := s.duration(form)
+	if err != nil {
+		return nil, err
+	}
+
+	arn := fmt.Sprintf("arn:aws:sts::%s:federated-user/%s", id.AccountId, name)
+	userId := id.AccountId + ":" + name
+	accessKeyId, secret, token, expires := s.issueCredentials(arn, userId, id.AccountId, duration, id.Mfa)
+
+	resp := &getFederationTokenResponse{}
+	resp.Result.Credentials = stsCredentials{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secret,
+		SessionToken:    token,
+		Expiration:      expires.UTC().Format(time.RFC3339),
+	}
+	resp.Result.FederatedUser.Arn = arn
+	resp.Result.FederatedUser.FederatedUserId = userId
+	return resp, nil
+}
+
+func (s *Server) getSessionToken(r *http.Request, form url.Values) (interface{}, error) {
+	id, err := s.caller(r)
+	if err != nil {
+		return nil, err
+	}
+	if !id.Expires.IsZero() {
+		return nil, newError(http.StatusForbidden, "AccessDenied", "Cannot call GetSessionToken with session credentials")
+	}
+	mfa, err := s.mfa(form)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := s.duration(form)
+	if err != nil {
+		return nil, err
+	}
+
+	// session credentials have the same identity as the IAM user
+	accessKeyId, secret, token, expires := s.issueCredentials(id.Arn, id.UserId, id.AccountId, duration, mfa)
+
+	resp := &getSessionTokenResponse{}
+	resp.Result.Credentials = stsCredentials{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secret,
+		SessionToken:    token,
+		Expiration:      expires.UTC().Format(time.RFC3339),
+	}
+	return resp, nil
+}
+
+// getSigninToken is the AWS console federation endpoint
+func (s *Server) getSigninToken(r *http.Request, body []byte) (interface{}, error) {
+	query := r.URL.Query()
+	if query.Get("Action") != "getSigninToken" {
+		return nil, newError(http.StatusBadRequest, "InvalidAction", "Unsupported action")
+	}
+
+	session := struct {
+		AccessKeyId     string `json:"sessionId"`
+		SecretAccessKey string `json:"sessionKey"`
+		SessionToken    string `json:"sessionToken"`
+	}{}
+	if err := json.Unmarshal([]byte(query.Get("Session")), &session); err != nil {
+		return nil, newError(http.StatusBadRequest, "InvalidRequest", "Invalid Session")
+	}
+	id, ok := s.keys[session.AccessKeyId]
+	if !ok || id.Secret != session.SecretAccessKey {
+		return nil, newError(http.StatusForbidden, "AccessDenied", "Invalid credentials")
+	}
+
+	return struct {
+		SigninToken string `json:"SigninToken"`
+	}{s.nextId("signin")}, nil
+}
@@ -0,0 +1,123 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/internal/audit"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+const (
+	AGENT_CLIENT_TIMEOUT = 5 * time.Minute // agent may need the user to authenticate
+	agentBaseUrl         = "http://aws-sso-agent"
+)
+
+// AgentClient talks to the AgentServer over its Unix socket
+type AgentClient struct {
+	socket string
+	client *http.Client
+}
+
+// AgentAvailable returns true if the given path is a Unix socket
+func AgentAvailable(socket string) bool {
+	info, err := os.Stat(socket)
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeSocket != 0
+}
+
+func NewAgentClient(socket string) *AgentClient {
+	return &AgentClient{
+		socket: socket,
+		client: &http.Client{
+			Timeout: AGENT_CLIENT_TIMEOUT,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// GetRoleCredentials returns the RoleCredentials for the role ARN in the given
+// AWS SSO instance and the audit.SOURCE_* the agent got them from
+func (c *AgentClient) GetRoleCredentials(ssoName, arn string, refresh bool) (*storage.RoleCredentials, string, error) {
+	query := url.Values{}
+	query.Set("sso", ssoName)
+	query.Set("arn", arn)
+	if refresh {
+		query.Set("refresh", "1")
+	}
+
+	creds := &storage.RoleCredentials{}
+	header, err := c.get(fmt.Sprintf("%s?%s", AGENT_CREDS_ROUTE, query.Encode()), creds)
+	if err != nil {
+		return creds, "", err
+	}
+
+	source := header.Get(AGENT_SOURCE_HEADER)
+	if source == "" {
+		source = audit.SOURCE_AGENT
+	}
+	return creds, source, nil
+}
+
+// Status returns the status of the agent
+func (c *AgentClient) Status() (AgentStatus, error) {
+	status := AgentStatus{}
+	_, err := c.get(AGENT_STATUS_ROUTE, &status)
+	return status, err
+}
+
+// get makes a GET request to the agent and decodes the JSON response into v.
+// Returns the response headers.
+func (c *AgentClient) get(path string, v interface{}) (http.Header, error) {
+	resp, err := c.client.Get(agentBaseUrl + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		m := Message{}
+		if err = json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("agent returned %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("agent returned %d: %s", resp.StatusCode, m.Message)
+	}
+	return resp.Header, json.Unmarshal(body, v)
+}
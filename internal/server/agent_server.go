@@ -0,0 +1,281 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/internal/audit"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+const (
+	AGENT_CREDS_ROUTE      = "/creds"  // get
+	AGENT_STATUS_ROUTE     = "/status" // get
+	AGENT_REFRESH_INTERVAL = time.Minute
+	AGENT_SOURCE_HEADER    = "X-Aws-Sso-Source" // audit.SOURCE_* of the credentials
+)
+
+// AgentCredentialsFunc returns the RoleCredentials for the given role ARN and
+// the audit.SOURCE_* they came from.  refresh forces fetching new credentials
+// from AWS.
+type AgentCredentialsFunc func(arn string, refresh bool) (*storage.RoleCredentials, string, error)
+
+// AgentServer is a long running daemon which serves RoleCredentials for a
+// single AWS SSO instance over a Unix domain socket
+type AgentServer struct {
+	listener      net.Listener
+	server        http.Server
+	socket        string
+	ssoName       string
+	getCreds      AgentCredentialsFunc
+	refreshWindow time.Duration
+	started       time.Time
+	fetchLock     sync.Mutex // serializes calls to getCreds
+	credsLock     sync.Mutex // protects credentials
+	credentials   map[string]*storage.RoleCredentials
+}
+
+type AgentStatus struct {
+	SSO     string `json:"SSO"`
+	Pid     int    `json:"Pid"`
+	Started int64  `json:"Started"`
+	Roles   int    `json:"Roles"`
+}
+
+// NewAgentServer creates a new AgentServer listening on the given Unix socket
+func NewAgentServer(socket, ssoName string, refreshWindow time.Duration, getCreds AgentCredentialsFunc) (*AgentServer, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+
+	// Remove any stale socket left behind by an agent which didn't exit cleanly
+	if AgentAvailable(socket) {
+		if _, err := NewAgentClient(socket).Status(); err == nil {
+			return nil, fmt.Errorf("aws-sso agent is already running on %s", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	// only our user may talk to the agent
+	if err = os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	a := &AgentServer{
+		listener:      listener,
+		socket:        socket,
+		ssoName:       ssoName,
+		getCreds:      getCreds,
+		refreshWindow: refreshWindow,
+		started:       time.Now(),
+		credentials:   map[string]*storage.RoleCredentials{},
+	}
+
+	router := http.NewServeMux()
+	router.HandleFunc(AGENT_CREDS_ROUTE, a.CredsRoute)
+	router.HandleFunc(AGENT_STATUS_ROUTE, a.StatusRoute)
+	a.server.Handler = withLogging(router)
+	a.server.ReadHeaderTimeout = 10 * time.Second
+
+	return a, nil
+}
+
+// Serve starts the agent and blocks until ctx is canceled
+func (a *AgentServer) Serve(ctx context.Context) error {
+	go a.refreshLoop(ctx)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.server.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Errorf("Unable to shutdown agent")
+		}
+	}()
+
+	err := a.server.Serve(a.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Socket returns the path of our Unix socket
+func (a *AgentServer) Socket() string {
+	return a.socket
+}
+
+// CredsRoute returns the RoleCredentials for the requested role ARN
+func (a *AgentServer) CredsRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMessage(w, "Invalid request", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	arn := query.Get("arn")
+	if arn == "" {
+		writeMessage(w, "Missing arn", http.StatusBadRequest)
+		return
+	}
+
+	if sso := query.Get("sso"); sso != a.ssoName {
+		writeMessage(w, fmt.Sprintf("Agent is serving %s, not %s", a.ssoName, sso), http.StatusNotFound)
+		return
+	}
+
+	creds, source, err := a.roleCredentials(arn, query.Get("refresh") != "")
+	if err != nil {
+		writeMessage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the client records the audit log entry, so tell it where they came from
+	w.Header().Set(AGENT_SOURCE_HEADER, source)
+	w.Header().Set("Content-Type", CHARSET_JSON)
+	if err = json.NewEncoder(w).Encode(creds); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// StatusRoute returns the AgentStatus
+func (a *AgentServer) StatusRoute(w http.ResponseWriter, r *http.Request) {
+	a.credsLock.Lock()
+	status := AgentStatus{
+		SSO:     a.ssoName,
+		Pid:     os.Getpid(),
+		Started: a.started.Unix(),
+		Roles:   len(a.credentials),
+	}
+	a.credsLock.Unlock()
+
+	w.Header().Set("Content-Type", CHARSET_JSON)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// roleCredentials returns our in-memory RoleCredentials if still valid,
+// otherwise it fetches new ones.  Also returns the audit.SOURCE_* of the
+// credentials.
+func (a *AgentServer) roleCredentials(arn string, refresh bool) (*storage.RoleCredentials, string, error) {
+	if creds := a.cachedCredentials(arn); creds != nil && !refresh {
+		return creds, audit.SOURCE_AGENT, nil
+	}
+
+	// getCreds may need to talk to AWS or re-authenticate, so don't block
+	// requests for other roles while it runs
+	a.fetchLock.Lock()
+	defer a.fetchLock.Unlock()
+
+	// another request may have fetched them while we waited
+	if creds := a.cachedCredentials(arn); creds != nil && !refresh {
+		return creds, audit.SOURCE_AGENT, nil
+	}
+
+	creds, source, err := a.getCreds(arn, refresh)
+	if err != nil {
+		return nil, "", err
+	}
+
+	a.credsLock.Lock()
+	a.credentials[arn] = creds
+	a.credsLock.Unlock()
+	return creds, source, nil
+}
+
+// cachedCredentials returns our in-memory RoleCredentials if they are still
+// valid or nil
+func (a *AgentServer) cachedCredentials(arn string) *storage.RoleCredentials {
+	a.credsLock.Lock()
+	defer a.credsLock.Unlock()
+
+	if creds, ok := a.credentials[arn]; ok && !creds.Expired() {
+		return creds
+	}
+	return nil
+}
+
+// refreshLoop proactively refreshes any credentials which are about to expire
+func (a *AgentServer) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(AGENT_REFRESH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.refreshExpiring()
+		}
+	}
+}
+
+// refreshExpiring fetches new credentials for every role which expires within
+// our refreshWindow
+func (a *AgentServer) refreshExpiring() {
+	deadline := time.Now().Add(a.refreshWindow).UnixMilli() // yes, millisec
+	expiring := map[string]*storage.RoleCredentials{}
+	a.credsLock.Lock()
+	for arn, creds := range a.credentials {
+		if creds.Expiration <= deadline {
+			expiring[arn] = creds
+		}
+	}
+	a.credsLock.Unlock()
+
+	for arn, creds := range expiring {
+		log.Debugf("Refreshing credentials for %s", arn)
+		a.fetchLock.Lock()
+		newCreds, _, err := a.getCreds(arn, true)
+		a.fetchLock.Unlock()
+		if err != nil {
+			log.WithError(err).Warnf("Unable to refresh credentials for %s", arn)
+		}
+
+		a.credsLock.Lock()
+		// a client may have fetched new credentials in the meantime
+		if a.credentials[arn] == creds {
+			if err == nil {
+				a.credentials[arn] = newCreds
+			} else if creds.Expired() {
+				delete(a.credentials, arn)
+			}
+		}
+		a.credsLock.Unlock()
+	}
+}
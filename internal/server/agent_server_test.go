@@ -0,0 +1,172 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/audit"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+const TEST_AGENT_ARN = "arn:aws:iam::123456789012:role/Foo"
+
+func TestAgentServer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "agent")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	calls := 0
+	getCreds := func(arn string, refresh bool) (*storage.RoleCredentials, string, error) {
+		if arn != TEST_AGENT_ARN {
+			return nil, "", fmt.Errorf("Unknown role %s", arn)
+		}
+		calls++
+		return &storage.RoleCredentials{
+			RoleName:        "Foo",
+			AccountId:       123456789012,
+			AccessKeyId:     fmt.Sprintf("AKIA%d", calls),
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+			Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+		}, audit.SOURCE_AWS, nil
+	}
+
+	assert.False(t, AgentAvailable(socket))
+	a, err := NewAgentServer(socket, "Default", 10*time.Minute, getCreds)
+	assert.NoError(t, err)
+	assert.Equal(t, socket, a.Socket())
+
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Serve(ctx) }()
+
+	assert.True(t, AgentAvailable(socket))
+	c := NewAgentClient(socket)
+
+	// only one agent per socket
+	_, err = NewAgentServer(socket, "Default", 10*time.Minute, getCreds)
+	assert.Error(t, err)
+
+	creds, source, err := c.GetRoleCredentials("Default", TEST_AGENT_ARN, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA1", creds.AccessKeyId)
+	assert.Equal(t, audit.SOURCE_AWS, source)
+
+	// cached
+	creds, source, err = c.GetRoleCredentials("Default", TEST_AGENT_ARN, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA1", creds.AccessKeyId)
+	assert.Equal(t, audit.SOURCE_AGENT, source)
+
+	// forced refresh
+	creds, source, err = c.GetRoleCredentials("Default", TEST_AGENT_ARN, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA2", creds.AccessKeyId)
+	assert.Equal(t, audit.SOURCE_AWS, source)
+
+	_, _, err = c.GetRoleCredentials("Other", TEST_AGENT_ARN, false)
+	assert.ErrorContains(t, err, "Agent is serving Default, not Other")
+
+	_, _, err = c.GetRoleCredentials("Default", "arn:aws:iam::123456789012:role/Bar", false)
+	assert.ErrorContains(t, err, "Unknown role")
+
+	status, err := c.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "Default", status.SSO)
+	assert.Equal(t, os.Getpid(), status.Pid)
+	assert.Equal(t, 1, status.Roles)
+
+	// credentials inside the refresh window get refreshed
+	a.refreshWindow = 2 * time.Hour
+	a.refreshExpiring()
+	creds, source, err = c.GetRoleCredentials("Default", TEST_AGENT_ARN, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA3", creds.AccessKeyId)
+	assert.Equal(t, audit.SOURCE_AGENT, source)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.False(t, AgentAvailable(socket))
+}
+
+func TestAgentServerRefreshUnlocked(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	block := make(chan struct{})
+	fetching := make(chan struct{}, 1)
+	getCreds := func(arn string, refresh bool) (*storage.RoleCredentials, string, error) {
+		if refresh {
+			fetching <- struct{}{}
+			<-block // eg: waiting for the user to authenticate
+		}
+		return &storage.RoleCredentials{
+			RoleName:    "Foo",
+			AccountId:   123456789012,
+			AccessKeyId: fmt.Sprintf("AKIA-%v", refresh),
+			Expiration:  time.Now().Add(time.Hour).UnixMilli(),
+		}, audit.SOURCE_AWS, nil
+	}
+
+	a, err := NewAgentServer(socket, "Default", 2*time.Hour, getCreds)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Serve(ctx) }()
+	c := NewAgentClient(socket)
+
+	creds, _, err := c.GetRoleCredentials("Default", TEST_AGENT_ARN, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA-false", creds.AccessKeyId)
+
+	refreshed := make(chan struct{})
+	go func() {
+		a.refreshExpiring()
+		close(refreshed)
+	}()
+	<-fetching
+
+	// cached credentials & status are still served during the refresh
+	creds, _, err = c.GetRoleCredentials("Default", TEST_AGENT_ARN, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA-false", creds.AccessKeyId)
+	status, err := c.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status.Roles)
+
+	close(block)
+	<-refreshed
+	creds, _, err = c.GetRoleCredentials("Default", TEST_AGENT_ARN, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA-true", creds.AccessKeyId)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,70 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+const (
+	AUTH_TOKEN_BYTES = 32
+)
+
+// NewAuthToken generates a new random bearer token for the ECS Server
+func NewAuthToken() (string, error) {
+	b := make([]byte, AUTH_TOKEN_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// LoadOrNewAuthToken returns the bearer token stored in the given file so
+// clients keep working across restarts or a new random token if there is none
+func LoadOrNewAuthToken(fileName string) (string, error) {
+	if authToken, err := ReadAuthToken(fileName); err == nil {
+		return authToken, nil
+	}
+	return NewAuthToken()
+}
+
+// WriteAuthToken atomically saves the bearer token in a file only readable by
+// the current user, replacing any existing file
+func WriteAuthToken(fileName, authToken string) error {
+	return utils.AtomicWriteFile(fileName, []byte(authToken+"\n"), 0600)
+}
+
+// ReadAuthToken returns the bearer token stored in the given file
+func ReadAuthToken(fileName string) (string, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read ECS Server auth token: %s", err.Error())
+	}
+	authToken := strings.TrimSpace(string(b))
+	if authToken == "" {
+		return "", fmt.Errorf("ECS Server auth token file %s is empty", fileName)
+	}
+	return authToken, nil
+}
@@ -0,0 +1,100 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthToken(t *testing.T) {
+	dir, err := os.MkdirTemp("", "token")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "sub", "ecs-server.token")
+
+	token, err := NewAuthToken()
+	assert.NoError(t, err)
+	assert.Len(t, token, AUTH_TOKEN_BYTES*2)
+
+	token2, err := NewAuthToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, token2)
+
+	_, err = ReadAuthToken(fileName)
+	assert.Error(t, err)
+
+	assert.NoError(t, WriteAuthToken(fileName, token))
+	info, err := os.Stat(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	read, err := ReadAuthToken(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, token, read)
+
+	// tighten permissions on an existing file
+	assert.NoError(t, os.Chmod(fileName, 0644))
+	assert.NoError(t, WriteAuthToken(fileName, token2))
+	info, err = os.Stat(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// existing tokens are reused
+	read, err = LoadOrNewAuthToken(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, token2, read)
+
+	assert.NoError(t, os.WriteFile(fileName, []byte("\n"), 0600))
+	_, err = ReadAuthToken(fileName)
+	assert.ErrorContains(t, err, "is empty")
+
+	read, err = LoadOrNewAuthToken(fileName)
+	assert.NoError(t, err)
+	assert.Len(t, read, AUTH_TOKEN_BYTES*2)
+}
+
+func TestWithAuthorizationCheck(t *testing.T) {
+	_, err := NewEcsServer(context.TODO(), "", "127.0.0.1", 0)
+	assert.Error(t, err)
+
+	handler := withAuthorizationCheck("secret", func(w http.ResponseWriter, r *http.Request) {
+		writeMessage(w, "OK", http.StatusOK)
+	})
+
+	for header, code := range map[string]int{
+		"":        http.StatusForbidden,
+		"invalid": http.StatusForbidden,
+		"secret":  http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodGet, CREDS_ROUTE, nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, code, w.Code, header)
+	}
+}
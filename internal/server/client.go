@@ -20,9 +20,12 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
@@ -32,25 +35,89 @@ import (
 	"github.com/synfinatic/gotable"
 )
 
+const (
+	unixBaseUrl = "http://aws-sso-ecs" // host is ignored for Unix sockets
+)
+
 type Client struct {
-	port int
+	baseUrl   string
+	authToken string
+	client    *http.Client
 }
 
-func NewClient(port int) *Client {
+// NewClient returns a Client for the ECS Server on the given port using the
+// bearer token stored in authTokenFile
+func NewClient(port int, authTokenFile string) (*Client, error) {
+	authToken, err := ReadAuthToken(authTokenFile)
+	if err != nil {
+		return nil, err
+	}
 	return &Client{
-		port: port,
+		baseUrl:   fmt.Sprintf("http://localhost:%d", port),
+		authToken: authToken,
+		client:    &http.Client{},
+	}, nil
+}
+
+// NewTLSClient returns a Client for the ECS Server on the given port using
+// HTTPS which trusts the server certificate in certFile
+func NewTLSClient(port int, certFile, authTokenFile string) (*Client, error) {
+	authToken, err := ReadAuthToken(authTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := certPool(certFile)
+	if err != nil {
+		return nil, err
 	}
+	return &Client{
+		baseUrl:   fmt.Sprintf("https://localhost:%d", port),
+		authToken: authToken,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:    pool,
+					MinVersion: tls.VersionTLS12,
+				},
+			},
+		},
+	}, nil
+}
+
+// NewUnixClient returns a Client for the ECS Server on the given Unix socket
+func NewUnixClient(socket, authTokenFile string) (*Client, error) {
+	authToken, err := ReadAuthToken(authTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		baseUrl:   unixBaseUrl,
+		authToken: authToken,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}, nil
+}
+
+// AuthToken returns the bearer token used to talk to the ECS Server
+func (c *Client) AuthToken() string {
+	return c.authToken
 }
 
 func (c *Client) LoadUrl(profile string) string {
 	if profile == "" {
-		return fmt.Sprintf("http://localhost:%d%s", c.port, CREDS_ROUTE)
+		return fmt.Sprintf("%s%s", c.baseUrl, CREDS_ROUTE)
 	}
-	return fmt.Sprintf("http://localhost:%d%s?profile=%s", c.port, CREDS_ROUTE, url.QueryEscape(profile))
+	return fmt.Sprintf("%s%s?profile=%s", c.baseUrl, CREDS_ROUTE, url.QueryEscape(profile))
 }
 
 func (c *Client) ProfileUrl() string {
-	return fmt.Sprintf("http://localhost:%d%s", c.port, PROFILE_ROUTE)
+	return fmt.Sprintf("%s%s", c.baseUrl, PROFILE_ROUTE)
 }
 
 type ClientRequest struct {
@@ -77,9 +144,13 @@ func (c *Client) SubmitCreds(creds *storage.RoleCredentials, profile string, slo
 		return err
 	}
 	req.Header.Set("Content-Type", CHARSET_JSON)
-	client := &http.Client{}
-	_, err = client.Do(req)
-	return err
+	req.Header.Set("Authorization", c.authToken)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
 }
 
 func (c *Client) GetProfile() (string, error) {
@@ -88,16 +159,19 @@ func (c *Client) GetProfile() (string, error) {
 		return "", err
 	}
 
-	client := &http.Client{}
 	req.Header.Set("Content-Type", CHARSET_JSON)
+	req.Header.Set("Authorization", c.authToken)
 	if err != nil {
 		return "", err
 	}
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if err = checkResponse(resp); err != nil {
+		return "", err
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -122,6 +196,16 @@ type ListProfilesResponse struct {
 	Expires      string `json:"Expires" header:"Expires"`
 }
 
+// EcsStatus is returned by the ECS Server STATUS_ROUTE
+type EcsStatus struct {
+	Pid      int                    `json:"Pid"`
+	Started  int64                  `json:"Started"`
+	OnDemand bool                   `json:"OnDemand"`
+	Persist  bool                   `json:"Persist"`
+	Default  *ListProfilesResponse  `json:"Default,omitempty"`
+	Slots    []ListProfilesResponse `json:"Slots"`
+}
+
 // GetHeader is required for GenerateTable()
 func (lpr ListProfilesResponse) GetHeader(fieldName string) (string, error) {
 	v := reflect.ValueOf(lpr)
@@ -136,16 +220,19 @@ func (c *Client) ListProfiles() ([]ListProfilesResponse, error) {
 		return lpr, err
 	}
 
-	client := &http.Client{}
 	req.Header.Set("Content-Type", CHARSET_JSON)
+	req.Header.Set("Authorization", c.authToken)
 	if err != nil {
 		return lpr, err
 	}
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return lpr, err
 	}
 	defer resp.Body.Close()
+	if err = checkResponse(resp); err != nil {
+		return lpr, err
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -167,11 +254,49 @@ func (c *Client) Delete(profile string) error {
 		return err
 	}
 
-	client := &http.Client{}
 	req.Header.Set("Content-Type", CHARSET_JSON)
+	req.Header.Set("Authorization", c.authToken)
 	if err != nil {
 		return err
 	}
-	_, err = client.Do(req)
-	return err
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// checkResponse returns the error Message from the ECS Server if the request failed
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	m := Message{}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil || m.Message == "" {
+		return fmt.Errorf("ECS Server returned %d", resp.StatusCode)
+	}
+	return fmt.Errorf("ECS Server returned %d: %s", resp.StatusCode, m.Message)
+}
+
+// Status returns the EcsStatus of the ECS Server
+func (c *Client) Status() (EcsStatus, error) {
+	status := EcsStatus{}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s%s", c.baseUrl, STATUS_ROUTE), nil)
+	if err != nil {
+		return status, err
+	}
+	req.Header.Set("Authorization", c.authToken)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if err = checkResponse(resp); err != nil {
+		return status, err
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
 }
@@ -0,0 +1,114 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"sync"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+	"github.com/synfinatic/aws-sso-cli/sso"
+)
+
+const (
+	// AWS SDKs start refreshing credentials ~15min before they expire, so
+	// hand out fresh credentials before then to avoid constant refreshing
+	ECS_REFRESH_WINDOW = 15 * time.Minute
+)
+
+// onDemand fetches and refreshes role credentials for the ECS Server
+type onDemand struct {
+	settings *sso.Settings
+	awssso   *sso.AWSSSO
+	store    storage.SecureStorage
+	lock     sync.Mutex // only one fetch at a time
+}
+
+// EnableOnDemand allows the ECS Server to fetch credentials for the requested
+// profile or slot on the fly and refresh them before they expire
+func (e *EcsServer) EnableOnDemand(settings *sso.Settings, awssso *sso.AWSSSO, store storage.SecureStorage) {
+	e.onDemand = &onDemand{
+		settings: settings,
+		awssso:   awssso,
+		store:    store,
+	}
+}
+
+// expiring returns true if the credentials need to be refreshed
+func expiring(creds *storage.RoleCredentials) bool {
+	return creds.Expiration <= time.Now().Add(ECS_REFRESH_WINDOW).UnixMilli() // yes, millisec
+}
+
+// profileRequest returns a ClientRequest for the given profile with fresh credentials
+func (o *onDemand) profileRequest(profile string) (*ClientRequest, error) {
+	rFlat, err := o.settings.Cache.GetSSO().Roles.GetRoleByProfile(profile, o.settings)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := o.roleCredentials(rFlat.Arn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientRequest{
+		Creds:       creds,
+		ProfileName: profile,
+	}, nil
+}
+
+// roleCredentials returns valid credentials for the given role ARN from our
+// SecureStore or from AWS SSO
+func (o *onDemand) roleCredentials(arn string) (*storage.RoleCredentials, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	creds := storage.RoleCredentials{}
+	if err := o.store.GetRoleCredentials(arn, &creds); err == nil && !expiring(&creds) {
+		log.Debugf("Retrieved role credentials for %s from the SecureStore", arn)
+		return &creds, nil
+	}
+
+	accountId, role, err := utils.ParseRoleARN(arn)
+	if err != nil {
+		return nil, err
+	}
+
+	// our SSO token may have expired while we've been running
+	if o.awssso.Token.Expired() {
+		if err = o.awssso.Authenticate(o.settings.UrlAction, o.settings.Browser); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Debugf("Fetching STS token for %s from AWS SSO", arn)
+	creds, err = o.awssso.GetRoleCredentials(accountId, role)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = o.store.SaveRoleCredentials(arn, creds); err != nil {
+		log.WithError(err).Warnf("Unable to cache role credentials in secure store")
+	}
+	if err = o.settings.Cache.SetRoleExpires(arn, creds.ExpireEpoch()); err != nil {
+		log.WithError(err).Warnf("Unable to update cache")
+	}
+	return &creds, nil
+}
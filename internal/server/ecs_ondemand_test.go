@@ -0,0 +1,147 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+func TestExpiring(t *testing.T) {
+	creds := &storage.RoleCredentials{
+		Expiration: time.Now().Add(time.Hour).UnixMilli(),
+	}
+	assert.False(t, expiring(creds))
+
+	creds.Expiration = time.Now().Add(ECS_REFRESH_WINDOW - time.Minute).UnixMilli()
+	assert.True(t, expiring(creds))
+}
+
+func TestEcsOnDemandRefresh(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ecs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, _ := storage.OpenJsonStore(filepath.Join(dir, "store.json"))
+
+	fresh := storage.RoleCredentials{
+		RoleName:        "Foo",
+		AccountId:       123456789012,
+		AccessKeyId:     "AKIAFRESH",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+	}
+	assert.NoError(t, store.SaveRoleCredentials(fresh.RoleArn(), fresh))
+
+	stale := fresh
+	stale.AccessKeyId = "AKIASTALE"
+	stale.Expiration = time.Now().Add(5 * time.Minute).UnixMilli()
+
+	e := &EcsServer{
+		defaultCreds: &ClientRequest{Creds: &stale, ProfileName: "Foo"},
+		credentials:  map[string]*ClientRequest{},
+	}
+
+	// without on-demand we hand out what we have
+	w := httptest.NewRecorder()
+	e.getCreds(w, httptest.NewRequest(http.MethodGet, CREDS_ROUTE, nil), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "AKIASTALE")
+
+	// unknown slots are unavailable
+	w = httptest.NewRecorder()
+	e.getCreds(w, httptest.NewRequest(http.MethodGet, CREDS_ROUTE, nil), "Bar")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	e.EnableOnDemand(nil, nil, store)
+	w = httptest.NewRecorder()
+	e.getCreds(w, httptest.NewRequest(http.MethodGet, CREDS_ROUTE, nil), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp := map[string]string{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "AKIAFRESH", resp["AccessKeyId"])
+	assert.Equal(t, "AKIAFRESH", e.defaultCreds.Creds.AccessKeyId)
+}
+
+func TestEcsOnDemandSingleFlight(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ecs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, _ := storage.OpenJsonStore(filepath.Join(dir, "store.json"))
+
+	fresh := storage.RoleCredentials{
+		RoleName:        "Foo",
+		AccountId:       123456789012,
+		AccessKeyId:     "AKIAFRESH",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+	}
+	assert.NoError(t, store.SaveRoleCredentials(fresh.RoleArn(), fresh))
+
+	stale := fresh
+	stale.AccessKeyId = "AKIASTALE"
+	stale.Expiration = time.Now().Add(5 * time.Minute).UnixMilli()
+
+	e := &EcsServer{
+		defaultCreds: &ClientRequest{Creds: &stale, ProfileName: "Foo"},
+		credentials:  map[string]*ClientRequest{},
+	}
+	e.EnableOnDemand(nil, nil, store)
+
+	// block the fetch so we can check our slots remain available meanwhile
+	e.onDemand.lock.Lock()
+	results := make(chan *storage.RoleCredentials, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			creds, err := e.slotCredentials("")
+			assert.NoError(t, err)
+			results <- creds
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		e.lock.RLock()
+		defer e.lock.RUnlock()
+		return e.fetching[""] != nil
+	}, time.Second, time.Millisecond)
+
+	w := httptest.NewRecorder()
+	e.listCreds(w, httptest.NewRequest(http.MethodGet, PROFILE_ROUTE, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	e.onDemand.lock.Unlock()
+	for i := 0; i < 5; i++ {
+		creds := <-results
+		assert.Equal(t, "AKIAFRESH", creds.AccessKeyId)
+	}
+	assert.Empty(t, e.fetching)
+	assert.Equal(t, "AKIAFRESH", e.defaultCreds.Creds.AccessKeyId)
+}
@@ -45,37 +45,106 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/synfinatic/aws-sso-cli/internal/audit"
 	"github.com/synfinatic/aws-sso-cli/internal/storage"
 	"github.com/synfinatic/aws-sso-cli/internal/utils"
 )
 
+var (
+	errCredsUnavailable = errors.New("Credentials unavailable")
+	errCredsExpired     = errors.New("Credentials expired")
+)
+
 type EcsServer struct {
 	listener     net.Listener
 	authToken    string
+	certFile     string // TLS is enabled if set
+	keyFile      string
 	server       http.Server
+	api          http.HandlerFunc // our routes, which require the authToken
+	started      time.Time
+	lock         sync.RWMutex // protects defaultCreds, credentials & fetching
 	defaultCreds *ClientRequest
 	credentials  map[string]*ClientRequest
+	fetching     map[string]*slotFetch // in-progress on-demand fetches by slot
+	onDemand     *onDemand             // nil unless EnableOnDemand() was called
+	store        storage.SecureStorage // nil unless EnablePersistence() was called
+	storeKey     string
+	audit        *audit.Logger // nil unless EnableAudit() was called
+	auditSSO     string
 }
 
 const (
 	CREDS_ROUTE   = "/creds"   // put/get/delete
 	PROFILE_ROUTE = "/profile" // get
+	STATUS_ROUTE  = "/status"  // get
 	DEFAULT_ROUTE = "/"        // get: default route
 	CHARSET_JSON  = "application/json; charset=utf-8"
 )
 
-// NewEcsServer creates a new ECS Server
-func NewEcsServer(ctx context.Context, authToken string, port int) (*EcsServer, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+// NewEcsServer creates a new ECS Server listening on the given IP & TCP port
+// which requires clients to provide authToken
+func NewEcsServer(ctx context.Context, authToken, bindIP string, port int) (*EcsServer, error) {
+	if authToken == "" {
+		return nil, fmt.Errorf("ECS Server requires an auth token")
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(bindIP, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	return newEcsServer(listener, authToken), nil
+}
+
+// NewUnixEcsServer creates a new ECS Server listening on the given Unix socket
+// which requires clients to provide authToken
+func NewUnixEcsServer(ctx context.Context, authToken, socket string) (*EcsServer, error) {
+	if authToken == "" {
+		return nil, fmt.Errorf("ECS Server requires an auth token")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+
+	// Remove any stale socket left behind by a server which didn't exit cleanly
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("ECS Server is already running on %s", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socket)
 	if err != nil {
 		return nil, err
 	}
 
+	// access to the socket is controlled via filesystem permissions
+	if err = os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return newEcsServer(listener, authToken), nil
+}
+
+func newEcsServer(listener net.Listener, authToken string) *EcsServer {
 	e := &EcsServer{
 		listener:  listener,
 		authToken: authToken,
@@ -83,20 +152,126 @@ func NewEcsServer(ctx context.Context, authToken string, port int) (*EcsServer,
 			Creds: &storage.RoleCredentials{},
 		},
 		credentials: map[string]*ClientRequest{},
+		started:     time.Now(),
 	}
 
 	router := http.NewServeMux()
 	router.HandleFunc(DEFAULT_ROUTE, e.DefaultRoute)
 	router.HandleFunc(CREDS_ROUTE, e.CredsRoute)
 	router.HandleFunc(PROFILE_ROUTE, e.ProfileRoute)
-	e.server.Handler = withLogging(withAuthorizationCheck(e.authToken, router.ServeHTTP))
+	router.HandleFunc(STATUS_ROUTE, e.StatusRoute)
+	e.api = withAuthorizationCheck(e.authToken, router.ServeHTTP)
+	e.server.Handler = withLogging(e.api)
+
+	return e
+}
+
+// EnableTLS serves HTTPS using the given certificate & key instead of HTTP
+func (e *EcsServer) EnableTLS(certFile, keyFile string) {
+	e.certFile = certFile
+	e.keyFile = keyFile
+}
+
+// EnableAudit records every time we return credentials in the audit log
+func (e *EcsServer) EnableAudit(l *audit.Logger, ssoName string) {
+	e.audit = l
+	e.auditSSO = ssoName
+}
 
-	return e, nil
+// auditCreds records that we returned the credentials to the client
+func (e *EcsServer) auditCreds(r *http.Request, creds *storage.RoleCredentials, command string) {
+	err := e.audit.Log(audit.Record{
+		SSO:     e.auditSSO,
+		RoleArn: creds.RoleArn(),
+		Command: command,
+		Client:  r.RemoteAddr,
+		Source:  audit.SOURCE_ECS_SERVER,
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Unable to write audit log")
+	}
 }
 
-// Serve starts the sever and blocks
-func (e *EcsServer) Serve() error {
-	return e.server.Serve(e.listener)
+// EnablePersistence saves the loaded slots in the SecureStore using key and
+// restores any previously saved slots
+func (e *EcsServer) EnablePersistence(store storage.SecureStorage, key string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.store = store
+	e.storeKey = key
+
+	slots := storage.EcsSlots{}
+	if err := store.GetEcsSlots(key, &slots); err != nil {
+		log.Debugf("No saved ECS Server slots: %s", err.Error())
+		return
+	}
+
+	if slots.Default != nil {
+		creds := slots.Default.Creds
+		e.defaultCreds = &ClientRequest{
+			Creds:       &creds,
+			ProfileName: slots.Default.ProfileName,
+		}
+	}
+	for profile, slot := range slots.Slots {
+		creds := slot.Creds
+		e.credentials[profile] = &ClientRequest{
+			Creds:       &creds,
+			ProfileName: slot.ProfileName,
+		}
+	}
+	log.Infof("Restored %d ECS Server slots", len(slots.Slots))
+}
+
+// save persists our slots in the SecureStore.  Caller must hold the lock.
+func (e *EcsServer) save() {
+	if e.store == nil {
+		return
+	}
+
+	slots := storage.EcsSlots{
+		Slots: map[string]storage.EcsSlot{},
+	}
+	if e.defaultCreds.ProfileName != "" && e.defaultCreds.Creds != nil {
+		slots.Default = &storage.EcsSlot{
+			ProfileName: e.defaultCreds.ProfileName,
+			Creds:       *e.defaultCreds.Creds,
+		}
+	}
+	for profile, cr := range e.credentials {
+		slots.Slots[profile] = storage.EcsSlot{
+			ProfileName: cr.ProfileName,
+			Creds:       *cr.Creds,
+		}
+	}
+
+	if err := e.store.SaveEcsSlots(e.storeKey, slots); err != nil {
+		log.WithError(err).Errorf("Unable to save ECS Server slots")
+	}
+}
+
+// Serve starts the sever and blocks until ctx is canceled
+func (e *EcsServer) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := e.server.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Errorf("Unable to shutdown ECS Server")
+		}
+	}()
+
+	var err error
+	if e.certFile != "" {
+		err = e.server.ServeTLS(e.listener, e.certFile, e.keyFile)
+	} else {
+		err = e.server.Serve(e.listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
 func (e *EcsServer) DefaultRoute(w http.ResponseWriter, r *http.Request) {
@@ -128,37 +303,126 @@ func (e *EcsServer) CredsRoute(w http.ResponseWriter, r *http.Request) {
 
 // deleteCreds removes our credentials from the cache
 func (e *EcsServer) deleteCreds(w http.ResponseWriter, r *http.Request, profile string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
 	if profile == "" {
 		e.defaultCreds = &ClientRequest{
+			Creds:       &storage.RoleCredentials{},
 			ProfileName: "",
 		}
 	} else {
 		delete(e.credentials, profile)
 	}
+	e.save()
 	e.OK(w)
 }
 
 // getCreds fetches the credentials from the cache
 func (e *EcsServer) getCreds(w http.ResponseWriter, r *http.Request, profile string) {
-	var c *ClientRequest
-	var ok bool
-	if profile == "" {
-		log.Debugf("fetching default creds")
-		c = e.defaultCreds
-	} else {
-		log.Debugf("fetching creds for profile: %s", profile)
+	creds, err := e.slotCredentials(profile)
+	switch {
+	case errors.Is(err, errCredsUnavailable):
+		e.Unavailable(w)
+	case errors.Is(err, errCredsExpired):
+		e.Expired(w)
+	default:
+		writeCredsToResponse(creds, w)
+		e.auditCreds(r, creds, "ecs")
+	}
+}
+
+// slotCredentials returns a copy of the credentials in the given slot or
+// the default slot if profile is empty
+func (e *EcsServer) slotCredentials(profile string) (*storage.RoleCredentials, error) {
+	e.lock.RLock()
+	c, ok := e.defaultCreds, true
+	if profile != "" {
 		c, ok = e.credentials[profile]
-		if !ok {
-			e.Unavailable(w)
-			return
+	}
+	onDemand := e.onDemand
+	e.lock.RUnlock()
+
+	switch {
+	case !ok && onDemand == nil:
+		return nil, errCredsUnavailable
+
+	case !ok:
+		log.Debugf("fetching creds for profile: %s", profile)
+		cr, err := e.fetchSlot(profile, nil, func() (*ClientRequest, error) {
+			return onDemand.profileRequest(profile)
+		})
+		if err != nil {
+			log.WithError(err).Errorf("Unable to fetch credentials for %s", profile)
+			return nil, errCredsUnavailable
+		}
+		c = cr
+
+	// unloaded slots have no role to refresh
+	case onDemand != nil && c.Creds != nil && c.Creds.AccountId != 0 && expiring(c.Creds):
+		arn := c.Creds.RoleArn()
+		cr, err := e.fetchSlot(profile, c, func() (*ClientRequest, error) {
+			creds, err := onDemand.roleCredentials(arn)
+			if err != nil {
+				return nil, err
+			}
+			return &ClientRequest{Creds: creds, ProfileName: c.ProfileName}, nil
+		})
+		if err != nil {
+			log.WithError(err).Errorf("Unable to refresh credentials for %s", arn)
+		} else {
+			c = cr
 		}
 	}
 
 	if c.Creds.Expired() {
-		e.Expired(w)
-		return
+		return nil, errCredsExpired
 	}
-	writeCredsToResponse(c.Creds, w)
+	creds := *c.Creds
+	return &creds, nil
+}
+
+// slotFetch is an in-progress on-demand fetch of the credentials for a slot
+type slotFetch struct {
+	done chan struct{}
+	cr   *ClientRequest
+	err  error
+}
+
+// fetchSlot calls fetch without holding our lock since it may talk to AWS or
+// require the user to authenticate.  Concurrent requests for the same slot
+// wait for and share the result of the first one.  The result replaces the
+// slot only if it still contains old, which is nil for a missing slot.
+func (e *EcsServer) fetchSlot(slot string, old *ClientRequest, fetch func() (*ClientRequest, error)) (*ClientRequest, error) {
+	e.lock.Lock()
+	if f, ok := e.fetching[slot]; ok {
+		e.lock.Unlock()
+		<-f.done
+		return f.cr, f.err
+	}
+	if e.fetching == nil {
+		e.fetching = map[string]*slotFetch{}
+	}
+	f := &slotFetch{done: make(chan struct{})}
+	e.fetching[slot] = f
+	e.lock.Unlock()
+
+	f.cr, f.err = fetch()
+
+	e.lock.Lock()
+	delete(e.fetching, slot)
+	if f.err == nil {
+		if slot == "" && e.defaultCreds == old {
+			e.defaultCreds = f.cr
+			e.save()
+		} else if current := e.credentials[slot]; slot != "" && current == old {
+			e.credentials[slot] = f.cr
+			e.save()
+		}
+	}
+	e.lock.Unlock()
+	close(f.done)
+	return f.cr, f.err
 }
 
 func (e *EcsServer) getClientRequest(r *http.Request) (*ClientRequest, error) {
@@ -181,40 +445,94 @@ func (e *EcsServer) putCreds(w http.ResponseWriter, r *http.Request, profile str
 		writeMessage(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if creds.Creds.Expired() {
+	if creds.Creds == nil || creds.Creds.Expired() {
 		e.Expired(w)
 		return
 	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
 	if profile == "" {
 		e.defaultCreds = creds
 	} else {
 		e.credentials[creds.ProfileName] = creds
 	}
+	e.save()
 	e.OK(w)
 }
 
 // listCreds returns the list of roles in our slots
 func (e *EcsServer) listCreds(w http.ResponseWriter, r *http.Request) {
+	e.lock.RLock()
+	resp := e.listProfiles()
+	e.lock.RUnlock()
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// listProfiles returns the roles in our slots.  Caller must hold the lock.
+func (e *EcsServer) listProfiles() []ListProfilesResponse {
 	resp := []ListProfilesResponse{}
 
 	for _, cr := range e.credentials {
-		exp, _ := utils.TimeRemain(cr.Creds.Expiration/1000, true)
-		resp = append(resp, ListProfilesResponse{
-			ProfileName:  cr.ProfileName,
-			AccountIdPad: cr.Creds.AccountIdStr(),
-			RoleName:     cr.Creds.RoleName,
-			Expiration:   cr.Creds.Expiration / 1000,
-			Expires:      exp,
-		})
+		resp = append(resp, newListProfilesResponse(cr))
 	}
+	return resp
+}
 
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
+func newListProfilesResponse(cr *ClientRequest) ListProfilesResponse {
+	exp, _ := utils.TimeRemain(cr.Creds.Expiration/1000, true)
+	return ListProfilesResponse{
+		ProfileName:  cr.ProfileName,
+		AccountIdPad: cr.Creds.AccountIdStr(),
+		RoleName:     cr.Creds.RoleName,
+		Expiration:   cr.Creds.Expiration / 1000,
+		Expires:      exp,
+	}
+}
+
+// StatusRoute returns the EcsStatus of the server
+func (e *EcsServer) StatusRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.Invalid(w)
+		return
+	}
+
+	e.lock.RLock()
+	status := EcsStatus{
+		Pid:      os.Getpid(),
+		Started:  e.started.Unix(),
+		OnDemand: e.onDemand != nil,
+		Persist:  e.store != nil,
+		Slots:    e.listProfiles(),
+	}
+	if e.defaultCreds.ProfileName != "" {
+		d := newListProfilesResponse(e.defaultCreds)
+		status.Default = &d
+	}
+	e.lock.RUnlock()
+
+	w.Header().Set("Content-Type", CHARSET_JSON)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
 		log.Error(err.Error())
 	}
 }
 
+// defaultProfile returns the ProfileName of the default slot
+func (e *EcsServer) defaultProfile() string {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.defaultCreds.ProfileName
+}
+
 // RoleRoute returns the current ProfileName in the defaultCreds
 func (e *EcsServer) ProfileRoute(w http.ResponseWriter, r *http.Request) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
 	if e.defaultCreds.ProfileName == "" {
 		e.Unavailable(w)
 		return
@@ -234,6 +552,12 @@ func (e *EcsServer) ProfileRoute(w http.ResponseWriter, r *http.Request) {
 }
 
 func (e *EcsServer) BaseURL() string {
+	if e.listener.Addr().Network() == "unix" {
+		return fmt.Sprintf("unix://%s", e.listener.Addr().String())
+	}
+	if e.certFile != "" {
+		return fmt.Sprintf("https://%s", e.listener.Addr().String())
+	}
 	return fmt.Sprintf("http://%s", e.listener.Addr().String())
 }
 
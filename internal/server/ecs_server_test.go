@@ -0,0 +1,213 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+// testEcsServerRoundTrip loads, lists and deletes credentials via the Client
+func testEcsServerRoundTrip(t *testing.T, c *Client) {
+	creds := &storage.RoleCredentials{
+		RoleName:        "Foo",
+		AccountId:       123456789012,
+		AccessKeyId:     "AKIA",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+	}
+	assert.NoError(t, c.SubmitCreds(creds, "Foo", true))
+
+	profiles, err := c.ListProfiles()
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+	assert.Equal(t, "Foo", profiles[0].ProfileName)
+
+	assert.NoError(t, c.Delete("Foo"))
+	profiles, err = c.ListProfiles()
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 0)
+}
+
+func TestEcsServerUnix(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ecs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "ecs.sock")
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, WriteAuthToken(tokenFile, "secret"))
+
+	e, err := NewUnixEcsServer(context.TODO(), "secret", socket)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = e.Serve(ctx) }()
+	assert.True(t, strings.HasPrefix(e.BaseURL(), "unix://"))
+
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// can't run two servers on the same socket
+	_, err = NewUnixEcsServer(context.TODO(), "secret", socket)
+	assert.Error(t, err)
+
+	c, err := NewUnixClient(socket, tokenFile)
+	assert.NoError(t, err)
+	testEcsServerRoundTrip(t, c)
+
+	// invalid token is rejected
+	assert.NoError(t, WriteAuthToken(tokenFile, "invalid"))
+	c, err = NewUnixClient(socket, tokenFile)
+	assert.NoError(t, err)
+	_, err = c.ListProfiles()
+	assert.ErrorContains(t, err, "Invalid authorization token")
+}
+
+func TestEcsServerTLS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ecs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "ecs-server.crt")
+	keyFile := filepath.Join(dir, "ecs-server.key")
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, WriteAuthToken(tokenFile, "secret"))
+
+	hosts := []string{"localhost", "127.0.0.1"}
+	assert.NoError(t, EnsureCertificate(certFile, keyFile, hosts))
+	assert.True(t, certificateValid(certFile, keyFile, hosts))
+	assert.False(t, certificateValid(certFile, keyFile, []string{"example.com"}))
+
+	// must not be able to sign certificates for other hosts
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	assert.NoError(t, err)
+	assert.False(t, leaf.IsCA)
+	assert.Zero(t, leaf.KeyUsage&x509.KeyUsageCertSign)
+
+	info, err := os.Stat(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// existing cert is reused
+	cert, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	assert.NoError(t, EnsureCertificate(certFile, keyFile, hosts))
+	cert2, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	assert.Equal(t, cert, cert2)
+
+	e, err := NewEcsServer(context.TODO(), "secret", "127.0.0.1", 0)
+	assert.NoError(t, err)
+	e.EnableTLS(certFile, keyFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = e.Serve(ctx) }()
+	assert.True(t, strings.HasPrefix(e.BaseURL(), "https://"))
+
+	port := e.listener.Addr().(*net.TCPAddr).Port
+	c, err := NewTLSClient(port, certFile, tokenFile)
+	assert.NoError(t, err)
+	testEcsServerRoundTrip(t, c)
+
+	// plain HTTP doesn't work
+	c, err = NewClient(port, tokenFile)
+	assert.NoError(t, err)
+	_, err = c.ListProfiles()
+	assert.Error(t, err)
+}
+
+func TestEcsServerPersistence(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ecs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "ecs.sock")
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, WriteAuthToken(tokenFile, "secret"))
+	store, _ := storage.OpenJsonStore(filepath.Join(dir, "store.json"))
+
+	creds := &storage.RoleCredentials{
+		RoleName:        "Foo",
+		AccountId:       123456789012,
+		AccessKeyId:     "AKIA",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+	}
+
+	e, err := NewUnixEcsServer(context.TODO(), "secret", socket)
+	assert.NoError(t, err)
+	e.EnablePersistence(store, "unix:"+socket)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Serve(ctx) }()
+
+	c, err := NewUnixClient(socket, tokenFile)
+	assert.NoError(t, err)
+	assert.NoError(t, c.SubmitCreds(creds, "Foo", false))
+	assert.NoError(t, c.SubmitCreds(creds, "Bar", true))
+
+	status, err := c.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), status.Pid)
+	assert.True(t, status.Persist)
+	assert.False(t, status.OnDemand)
+	assert.Equal(t, "Foo", status.Default.ProfileName)
+	assert.Len(t, status.Slots, 1)
+
+	// graceful shutdown
+	cancel()
+	assert.NoError(t, <-done)
+
+	// slots are restored after a restart
+	e, err = NewUnixEcsServer(context.TODO(), "secret", socket)
+	assert.NoError(t, err)
+	e.EnablePersistence(store, "unix:"+socket)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = e.Serve(ctx) }()
+
+	profile, err := c.GetProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, "Foo", profile)
+
+	profiles, err := c.ListProfiles()
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+	assert.Equal(t, "Bar", profiles[0].ProfileName)
+
+	// unloading is persisted too
+	assert.NoError(t, c.Delete(""))
+	slots := storage.EcsSlots{}
+	assert.NoError(t, store.GetEcsSlots("unix:"+socket, &slots))
+	assert.Nil(t, slots.Default)
+	assert.Len(t, slots.Slots, 1)
+}
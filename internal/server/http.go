@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -27,10 +28,10 @@ func writeMessage(w http.ResponseWriter, msg string, statusCode int) {
 	}
 }
 
-// withAuthorizationCheck checks our authToken (if set) and returns 404 on error
+// withAuthorizationCheck checks our authToken and returns 403 on error
 func withAuthorizationCheck(authToken string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") != authToken {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(authToken)) != 1 {
 			writeMessage(w, "Invalid authorization token", http.StatusForbidden)
 			return
 		}
@@ -0,0 +1,217 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	IMDS_TOKEN_ROUTE      = "/latest/api/token"                           // put
+	IMDS_CREDS_ROUTE      = "/latest/meta-data/iam/security-credentials/" // get
+	IMDS_TOKEN_HEADER     = "X-aws-ec2-metadata-token"
+	IMDS_TOKEN_TTL_HEADER = "X-aws-ec2-metadata-token-ttl-seconds"
+	IMDS_MAX_TOKEN_TTL    = 21600 // seconds
+	CHARSET_TEXT          = "text/plain; charset=utf-8"
+)
+
+// ImdsServer implements the IMDSv2 credentials endpoints of the EC2 Instance
+// Metadata Service using the same slots & management API as the EcsServer
+type ImdsServer struct {
+	*EcsServer
+	tokenLock sync.Mutex
+	tokens    map[string]time.Time // IMDSv2 session token => expires
+}
+
+// imdsCredentials is the IMDS security-credentials response
+type imdsCredentials struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// NewImdsServer creates a new IMDS Server listening on localhost.  The
+// management API requires clients to provide authToken
+func NewImdsServer(ctx context.Context, authToken string, port int) (*ImdsServer, error) {
+	e, err := NewEcsServer(ctx, authToken, "127.0.0.1", port)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &ImdsServer{
+		EcsServer: e,
+		tokens:    map[string]time.Time{},
+	}
+
+	// AWS SDKs don't send our authToken to IMDS, so those routes use
+	// IMDSv2 session tokens instead
+	router := http.NewServeMux()
+	router.HandleFunc(IMDS_TOKEN_ROUTE, i.withLocalRequest(i.TokenRoute))
+	router.HandleFunc(IMDS_CREDS_ROUTE, i.withLocalRequest(i.withSessionToken(i.CredentialsRoute)))
+	router.HandleFunc(DEFAULT_ROUTE, e.api)
+	e.server.Handler = withLogging(router)
+
+	return i, nil
+}
+
+// withLocalRequest rejects requests which don't come from localhost or are
+// not addressed to us.  The latter stops web pages using DNS rebinding from
+// getting a session token since the browser sends their hostname.
+func (i *ImdsServer) withLocalRequest(next http.HandlerFunc) http.HandlerFunc {
+	port := strconv.Itoa(i.listener.Addr().(*net.TCPAddr).Port)
+	hosts := map[string]bool{
+		net.JoinHostPort("127.0.0.1", port): true,
+		net.JoinHostPort("localhost", port): true,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		remote, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(remote)
+		if err != nil || ip == nil || !ip.IsLoopback() || !hosts[strings.ToLower(r.Host)] {
+			log.Warnf("Rejecting IMDS request for %s from %s", r.Host, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// TokenRoute issues a new IMDSv2 session token
+func (i *ImdsServer) TokenRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Like EC2, refuse requests which have gone through a proxy
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ttl, err := strconv.Atoi(r.Header.Get(IMDS_TOKEN_TTL_HEADER))
+	if err != nil || ttl < 1 || ttl > IMDS_MAX_TOKEN_TTL {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	token, err := NewAuthToken()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	i.tokenLock.Lock()
+	for t, expires := range i.tokens {
+		if now.After(expires) {
+			delete(i.tokens, t)
+		}
+	}
+	i.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	i.tokenLock.Unlock()
+
+	w.Header().Set("Content-Type", CHARSET_TEXT)
+	w.Header().Set(IMDS_TOKEN_TTL_HEADER, strconv.Itoa(ttl))
+	fmt.Fprint(w, token)
+}
+
+// withSessionToken requires a valid IMDSv2 session token
+func (i *ImdsServer) withSessionToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i.tokenLock.Lock()
+		expires, ok := i.tokens[r.Header.Get(IMDS_TOKEN_HEADER)]
+		i.tokenLock.Unlock()
+
+		if !ok || time.Now().After(expires) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// CredentialsRoute lists our role or returns the credentials for the role.
+// The role name is the ProfileName of the default slot or a named slot.
+func (i *ImdsServer) CredentialsRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defaultProfile := i.defaultProfile()
+	role, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), IMDS_CREDS_ROUTE))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if role == "" {
+		if defaultProfile == "" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", CHARSET_TEXT)
+		fmt.Fprint(w, defaultProfile)
+		return
+	}
+
+	slot := role
+	if role == defaultProfile {
+		slot = ""
+	}
+	creds, err := i.slotCredentials(slot)
+	if err != nil {
+		if !errors.Is(err, errCredsUnavailable) {
+			log.WithError(err).Warnf("Unable to return credentials for %s", role)
+		}
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", CHARSET_JSON)
+	err = json.NewEncoder(w).Encode(imdsCredentials{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyId:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      time.UnixMilli(creds.Expiration).UTC().Format(time.RFC3339), // yes, millisec
+	})
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	i.auditCreds(r, creds, "imds")
+}
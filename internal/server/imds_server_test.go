@@ -0,0 +1,163 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+func TestImdsServer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "imds")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, WriteAuthToken(tokenFile, "secret"))
+
+	i, err := NewImdsServer(context.TODO(), "secret", 0)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = i.Serve(ctx) }()
+
+	port := i.listener.Addr().(*net.TCPAddr).Port
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	// nothing loaded yet
+	token := imdsToken(t, endpoint, "60")
+	assert.NotEmpty(t, token)
+	code, _ := imdsGet(t, endpoint+IMDS_CREDS_ROUTE, token)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// the management API still requires our authToken
+	resp, err := http.Get(endpoint + CREDS_ROUTE)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	c, err := NewClient(port, tokenFile)
+	assert.NoError(t, err)
+	creds := &storage.RoleCredentials{
+		RoleName:        "Foo",
+		AccountId:       123456789012,
+		AccessKeyId:     "AKIAFOO",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).UnixMilli(),
+	}
+	assert.NoError(t, c.SubmitCreds(creds, "Foo", false))
+
+	// the same requests the AWS SDK ec2rolecreds provider makes
+	code, body := imdsGet(t, endpoint+IMDS_CREDS_ROUTE, token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Foo", body)
+
+	code, body = imdsGet(t, endpoint+IMDS_CREDS_ROUTE+"Foo", token)
+	assert.Equal(t, http.StatusOK, code)
+	imdsCreds := imdsCredentials{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &imdsCreds))
+	assert.Equal(t, "Success", imdsCreds.Code)
+	assert.Equal(t, "AKIAFOO", imdsCreds.AccessKeyId)
+	assert.Equal(t, "secret", imdsCreds.SecretAccessKey)
+	assert.Equal(t, "token", imdsCreds.Token)
+	expires, err := time.Parse(time.RFC3339, imdsCreds.Expiration)
+	assert.NoError(t, err)
+	assert.Equal(t, creds.ExpireEpoch(), expires.Unix())
+
+	// IMDSv1 is not supported
+	resp, err = http.Get(endpoint + IMDS_CREDS_ROUTE)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// named slots are available via their ProfileName
+	creds.AccessKeyId = "AKIABAR"
+	assert.NoError(t, c.SubmitCreds(creds, "Bar", true))
+	code, _ = imdsGet(t, endpoint+IMDS_CREDS_ROUTE+"Bar", token)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = imdsGet(t, endpoint+IMDS_CREDS_ROUTE+"Baz", token)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// invalid tokens
+	code, _ = imdsGet(t, endpoint+IMDS_CREDS_ROUTE, "invalid")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Empty(t, imdsToken(t, endpoint, "0"))
+	assert.Empty(t, imdsToken(t, endpoint, "21601"))
+
+	// localhost is fine, but not other hostnames which resolve to us
+	assert.NotEmpty(t, imdsToken(t, fmt.Sprintf("http://localhost:%d", port), "60"))
+	req, err := http.NewRequest(http.MethodPut, endpoint+IMDS_TOKEN_ROUTE, nil)
+	assert.NoError(t, err)
+	req.Host = fmt.Sprintf("rebind.example.com:%d", port)
+	req.Header.Set(IMDS_TOKEN_TTL_HEADER, "60")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, endpoint+IMDS_CREDS_ROUTE, nil)
+	assert.NoError(t, err)
+	req.Host = fmt.Sprintf("rebind.example.com:%d", port)
+	req.Header.Set(IMDS_TOKEN_HEADER, token)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// imdsToken returns a new IMDSv2 session token or an empty string on error
+func imdsToken(t *testing.T, endpoint, ttl string) string {
+	req, err := http.NewRequest(http.MethodPut, endpoint+IMDS_TOKEN_ROUTE, nil)
+	assert.NoError(t, err)
+	req.Header.Set(IMDS_TOKEN_TTL_HEADER, ttl)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	buf := make([]byte, 128)
+	n, _ := resp.Body.Read(buf)
+	return string(buf[:n])
+}
+
+// imdsGet returns the status code & body of a GET request using the session token
+func imdsGet(t *testing.T, url, token string) (int, string) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.NoError(t, err)
+	req.Header.Set(IMDS_TOKEN_HEADER, token)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, strings.TrimSpace(string(body))
+}
@@ -0,0 +1,151 @@
+package server
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	TLS_CERT_LIFETIME     = 365 * 24 * time.Hour
+	TLS_CERT_RENEW_WINDOW = 30 * 24 * time.Hour
+)
+
+// EnsureCertificate creates a self-signed certificate & key for the ECS Server
+// unless the existing ones are still valid for all of the given hosts
+func EnsureCertificate(certFile, keyFile string, hosts []string) error {
+	if certificateValid(certFile, keyFile, hosts) {
+		return nil
+	}
+	log.Infof("Generating self-signed certificate: %s", certFile)
+	return generateCertificate(certFile, keyFile, hosts)
+}
+
+// certificateValid returns true if the certificate & key can be loaded, are not
+// about to expire, cover all of the hosts and is not a CA
+func certificateValid(certFile, keyFile string, hosts []string) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(TLS_CERT_RENEW_WINDOW).After(cert.NotAfter) {
+		return false
+	}
+	// replace certificates generated by older versions which could sign
+	// certificates for any host
+	if cert.IsCA {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateCertificate writes a new ECDSA self-signed certificate & key valid
+// for the given hostnames and IP addresses.  It is a leaf certificate which
+// clients trust directly, so it can't be used to sign other certificates.
+func generateCertificate(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "aws-sso ECS Server"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(TLS_CERT_LIFETIME),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err = os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		return err
+	}
+	if err = os.Chmod(keyFile, 0600); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return os.WriteFile(certFile, certPem, 0644)
+}
+
+// certPool returns a CertPool which trusts the certificate in certFile
+func certPool(certFile string) (*x509.CertPool, error) {
+	certPem, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certPem) {
+		return nil, fmt.Errorf("No certificates found in %s", certFile)
+	}
+	return pool, nil
+}
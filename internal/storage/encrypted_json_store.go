@@ -0,0 +1,332 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	ENCRYPTED_JSON_VERSION = 1
+	KDF_SCRYPT             = "scrypt"
+	KDF_ARGON2ID           = "argon2id"
+	KDF_KEYFILE            = "keyfile"
+	ENCRYPTION_KEY_BYTES   = 32 // AES-256
+	ENCRYPTION_SALT_BYTES  = 32
+	KEY_FILE_MIN_BYTES     = 32
+)
+
+// EncryptedJsonConfig selects how the EncryptedJsonStore derives its key
+type EncryptedJsonConfig struct {
+	KeyFile string // path to a key file instead of using a password
+	Kdf     string // KDF_SCRYPT (default) or KDF_ARGON2ID for new passwords
+}
+
+// KdfParams are the tuning parameters for the KDF used by the EncryptedJsonStore
+type KdfParams struct {
+	N       int    `json:"N,omitempty"` // scrypt
+	R       int    `json:"R,omitempty"`
+	P       int    `json:"P,omitempty"`
+	Time    uint32 `json:"Time,omitempty"` // argon2id
+	Memory  uint32 `json:"Memory,omitempty"`
+	Threads uint8  `json:"Threads,omitempty"`
+}
+
+var defaultKdfParams = map[string]KdfParams{
+	KDF_SCRYPT: {
+		N: 32768,
+		R: 8,
+		P: 1,
+	},
+	KDF_ARGON2ID: {
+		Time:    1,
+		Memory:  64 * 1024, // KiB
+		Threads: 4,
+	},
+	KDF_KEYFILE: {},
+}
+
+// envelope is the on-disk format of the EncryptedJsonStore.  The JSON
+// store is encrypted with AES-256-GCM using a key derived via the Kdf.
+type envelope struct {
+	Version    int       `json:"Version"`
+	Kdf        string    `json:"Kdf"`
+	KdfParams  KdfParams `json:"KdfParams"`
+	Salt       []byte    `json:"Salt"`
+	Nonce      []byte    `json:"Nonce"`
+	Ciphertext []byte    `json:"Ciphertext"`
+}
+
+// additionalData authenticates our envelope header along with the ciphertext
+func (e *envelope) additionalData() []byte {
+	params, _ := json.Marshal(e.KdfParams)
+	return []byte(fmt.Sprintf("aws-sso-cli:%d:%s:%s:%x", e.Version, e.Kdf, params, e.Salt))
+}
+
+// EncryptedJsonStore implements SecureStorage using a JsonStore which is
+// encrypted at rest using a password or a key file
+type EncryptedJsonStore struct {
+	*JsonStore
+	header envelope // Kdf, KdfParams & Salt used for key
+	key    []byte
+}
+
+// OpenEncryptedJsonStore opens or creates our encrypted JSON storage backend
+func OpenEncryptedJsonStore(fileName string, cfg EncryptedJsonConfig) (*EncryptedJsonStore, error) {
+	store := &EncryptedJsonStore{
+		JsonStore: newJsonStore(fileName),
+	}
+	store.seal = store.encrypt
+
+	env := envelope{}
+	store.stat()
+	err := utils.ReadLockedFile(fileName, func(data []byte) error {
+		return json.Unmarshal(data, &env)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		log.Infof("Creating new encrypted store: %s", fileName)
+		if err = store.newKey(cfg); err != nil {
+			return nil, err
+		}
+		// other aws-sso processes will write the file encrypted with our key
+		store.unseal = store.open
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", fileName, err.Error())
+	}
+	if env.Version != ENCRYPTED_JSON_VERSION {
+		return nil, fmt.Errorf("Unsupported encrypted store version %d in %s", env.Version, fileName)
+	}
+
+	var secret []byte
+	if env.Kdf == KDF_KEYFILE {
+		if secret, err = readKeyFile(cfg.KeyFile); err != nil {
+			return nil, err
+		}
+	} else {
+		password, err := getPasswordFunc("Enter password")
+		if err != nil {
+			return nil, fmt.Errorf("Password error: %s", err.Error())
+		}
+		secret = []byte(password)
+	}
+
+	store.header = envelope{
+		Version:   env.Version,
+		Kdf:       env.Kdf,
+		KdfParams: env.KdfParams,
+		Salt:      env.Salt,
+	}
+	if store.key, err = deriveKey(env.Kdf, env.KdfParams, secret, env.Salt); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := store.decrypt(&env)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt %s: invalid password or key file", fileName)
+	}
+	if err = store.parse(plaintext); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %s", fileName, err.Error())
+	}
+	store.unseal = store.open
+	return store, nil
+}
+
+// open returns the plaintext of the envelope written by another aws-sso process
+func (es *EncryptedJsonStore) open(data []byte) ([]byte, error) {
+	env := envelope{}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if env.Version != ENCRYPTED_JSON_VERSION {
+		return nil, fmt.Errorf("Unsupported encrypted store version %d", env.Version)
+	}
+	plaintext, err := es.decrypt(&env)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt %s: invalid password or key file", es.filename)
+	}
+	return plaintext, nil
+}
+
+// newKey generates a new salt & key for the store using the key file or
+// by prompting the user for a new password
+func (es *EncryptedJsonStore) newKey(cfg EncryptedJsonConfig) error {
+	var secret []byte
+	var err error
+
+	kdf := cfg.Kdf
+	if cfg.KeyFile != "" {
+		kdf = KDF_KEYFILE
+		if secret, err = readKeyFile(cfg.KeyFile); errors.Is(err, os.ErrNotExist) {
+			secret, err = createKeyFile(cfg.KeyFile)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		if kdf == "" {
+			kdf = KDF_SCRYPT
+		}
+		password, err := newPassword()
+		if err != nil {
+			return err
+		}
+		secret = []byte(password)
+	}
+
+	params, ok := defaultKdfParams[kdf]
+	if !ok {
+		return fmt.Errorf("Invalid KDF: %s", kdf)
+	}
+
+	salt := make([]byte, ENCRYPTION_SALT_BYTES)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	es.header = envelope{
+		Version:   ENCRYPTED_JSON_VERSION,
+		Kdf:       kdf,
+		KdfParams: params,
+		Salt:      salt,
+	}
+	es.key, err = deriveKey(kdf, params, secret, salt)
+	return err
+}
+
+// newPassword returns the password from our environment or prompts the
+// user to select a new password
+func newPassword() (string, error) {
+	if password := os.Getenv(ENV_SSO_FILE_PASSWORD); password != "" {
+		return password, nil
+	}
+
+	pass1, err := getPasswordFunc("Select password")
+	if err != nil {
+		return "", fmt.Errorf("Password error: %s", err.Error())
+	}
+	pass2, err := getPasswordFunc("Verify password")
+	if err != nil {
+		return "", fmt.Errorf("Password error: %s", err.Error())
+	}
+	if pass1 != pass2 {
+		return "", fmt.Errorf("Password missmatch")
+	}
+	return pass1, nil
+}
+
+// deriveKey returns our AES-256 key using the given KDF
+func deriveKey(kdf string, params KdfParams, secret, salt []byte) ([]byte, error) {
+	switch kdf {
+	case KDF_SCRYPT:
+		return scrypt.Key(secret, salt, params.N, params.R, params.P, ENCRYPTION_KEY_BYTES)
+
+	case KDF_ARGON2ID:
+		if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+			return nil, fmt.Errorf("Invalid argon2id parameters")
+		}
+		return argon2.IDKey(secret, salt, params.Time, params.Memory, params.Threads, ENCRYPTION_KEY_BYTES), nil
+
+	case KDF_KEYFILE:
+		key := make([]byte, ENCRYPTION_KEY_BYTES)
+		_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(KEYRING_ID)), key)
+		return key, err
+
+	default:
+		return nil, fmt.Errorf("Invalid KDF: %s", kdf)
+	}
+}
+
+// readKeyFile returns the contents of our key file
+func readKeyFile(fileName string) ([]byte, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("Encrypted store requires a key file")
+	}
+	secret, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) < KEY_FILE_MIN_BYTES {
+		return nil, fmt.Errorf("Key file %s must be at least %d bytes", fileName, KEY_FILE_MIN_BYTES)
+	}
+	return secret, nil
+}
+
+// createKeyFile writes a new random key file
+func createKeyFile(fileName string) ([]byte, error) {
+	secret := make([]byte, KEY_FILE_MIN_BYTES)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	log.Infof("Creating new key file: %s", fileName)
+	if err := utils.AtomicWriteFile(fileName, secret, 0600); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// aead returns our AES-256-GCM cipher
+func (es *EncryptedJsonStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(es.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns the envelope containing the encrypted plaintext
+func (es *EncryptedJsonStore) encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := es.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	env := es.header
+	env.Nonce = make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, env.Nonce); err != nil {
+		return nil, err
+	}
+	env.Ciphertext = gcm.Seal(nil, env.Nonce, plaintext, env.additionalData())
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// decrypt returns the plaintext of the envelope
+func (es *EncryptedJsonStore) decrypt(env *envelope) ([]byte, error) {
+	gcm, err := es.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("Invalid nonce")
+	}
+	return gcm.Open(nil, env.Nonce, env.Ciphertext, env.additionalData())
+}
@@ -0,0 +1,190 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedJsonStorePassword(t *testing.T) {
+	dir, err := os.MkdirTemp("", "encrypted")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, kdf := range []string{KDF_SCRYPT, KDF_ARGON2ID} {
+		fileName := filepath.Join(dir, kdf, "store.json.enc")
+		t.Setenv(ENV_SSO_FILE_PASSWORD, "my password")
+
+		es, err := OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{Kdf: kdf})
+		assert.NoError(t, err)
+
+		creds := RoleCredentials{
+			RoleName:        "Foo",
+			AccountId:       123456789012,
+			AccessKeyId:     "AKIAFOO",
+			SecretAccessKey: "not a real secret",
+			SessionToken:    "not a real token",
+			Expiration:      1637723379000,
+		}
+		assert.NoError(t, es.SaveRoleCredentials("arn:aws:iam::123456789012:role/Foo", creds))
+
+		// the file is encrypted and private
+		data, err := os.ReadFile(fileName)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "not a real secret")
+		env := envelope{}
+		assert.NoError(t, json.Unmarshal(data, &env))
+		assert.Equal(t, kdf, env.Kdf)
+		info, err := os.Stat(fileName)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// re-open
+		es, err = OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{})
+		assert.NoError(t, err)
+		read := RoleCredentials{}
+		assert.NoError(t, es.GetRoleCredentials("arn:aws:iam::123456789012:role/Foo", &read))
+		assert.Equal(t, creds, read)
+
+		// wrong password
+		t.Setenv(ENV_SSO_FILE_PASSWORD, "wrong password")
+		_, err = OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{})
+		assert.ErrorContains(t, err, "invalid password")
+	}
+}
+
+func TestEncryptedJsonStoreKeyFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "encrypted")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "store.json.enc")
+	keyFile := filepath.Join(dir, "store.key")
+	cfg := EncryptedJsonConfig{KeyFile: keyFile}
+
+	// key file is created for new stores
+	es, err := OpenEncryptedJsonStore(fileName, cfg)
+	assert.NoError(t, err)
+	info, err := os.Stat(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Equal(t, int64(KEY_FILE_MIN_BYTES), info.Size())
+
+	token := CreateTokenResponse{
+		AccessToken: "not a real token",
+		ExpiresAt:   1637723379,
+	}
+	assert.NoError(t, es.SaveCreateTokenResponse("Default", token))
+
+	es, err = OpenEncryptedJsonStore(fileName, cfg)
+	assert.NoError(t, err)
+	read := CreateTokenResponse{}
+	assert.NoError(t, es.GetCreateTokenResponse("Default", &read))
+	assert.Equal(t, token, read)
+
+	// missing key file
+	_, err = OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{})
+	assert.ErrorContains(t, err, "requires a key file")
+
+	// wrong key file
+	assert.NoError(t, os.WriteFile(keyFile, []byte("this is not the key file we used to encrypt"), 0600))
+	_, err = OpenEncryptedJsonStore(fileName, cfg)
+	assert.ErrorContains(t, err, "invalid password or key file")
+
+	// key file too short
+	assert.NoError(t, os.WriteFile(keyFile, []byte("short"), 0600))
+	_, err = OpenEncryptedJsonStore(fileName, cfg)
+	assert.ErrorContains(t, err, "at least")
+}
+
+func TestEncryptedJsonStoreConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "store.json.enc")
+	cfg := EncryptedJsonConfig{KeyFile: filepath.Join(dir, "store.key")}
+
+	// the first aws-sso process creates the store, the second opens it
+	es1, err := OpenEncryptedJsonStore(fileName, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, es1.SaveRoleCredentials("a", RoleCredentials{RoleName: "a"}))
+	es2, err := OpenEncryptedJsonStore(fileName, cfg)
+	assert.NoError(t, err)
+
+	assert.NoError(t, es2.SaveRoleCredentials("b", RoleCredentials{RoleName: "b"}))
+	assert.NoError(t, es1.SaveRoleCredentials("c", RoleCredentials{RoleName: "c"}))
+	assert.NoError(t, es2.SaveRoleCredentials("d", RoleCredentials{RoleName: "d"}))
+
+	es3, err := OpenEncryptedJsonStore(fileName, cfg)
+	assert.NoError(t, err)
+	for _, es := range []*EncryptedJsonStore{es1, es2, es3} {
+		assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, es.ListRoleCredentials())
+	}
+}
+
+func TestEncryptedJsonStoreTampering(t *testing.T) {
+	dir, err := os.MkdirTemp("", "encrypted")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "store.json.enc")
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "my password")
+
+	es, err := OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{})
+	assert.NoError(t, err)
+	assert.NoError(t, es.SaveEcsSlots("http://127.0.0.1:4144", EcsSlots{}))
+
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+	env := envelope{}
+	assert.NoError(t, json.Unmarshal(data, &env))
+
+	// header is authenticated
+	tampered := env
+	tampered.KdfParams.N = 16384
+	data, err = json.Marshal(tampered)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(fileName, data, 0600))
+	_, err = OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{})
+	assert.ErrorContains(t, err, "Unable to decrypt")
+
+	// and so is the ciphertext
+	tampered = env
+	tampered.Ciphertext = append([]byte{}, env.Ciphertext...)
+	tampered.Ciphertext[0] ^= 0xff
+	data, err = json.Marshal(tampered)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(fileName, data, 0600))
+	_, err = OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{})
+	assert.ErrorContains(t, err, "Unable to decrypt")
+
+	tampered = env
+	tampered.Version = 2
+	data, err = json.Marshal(tampered)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(fileName, data, 0600))
+	_, err = OpenEncryptedJsonStore(fileName, EncryptedJsonConfig{})
+	assert.ErrorContains(t, err, "Unsupported")
+
+	_, err = deriveKey("invalid", KdfParams{}, []byte("secret"), []byte("salt"))
+	assert.Error(t, err)
+	_, err = OpenEncryptedJsonStore(filepath.Join(dir, "new.json.enc"), EncryptedJsonConfig{Kdf: "invalid"})
+	assert.ErrorContains(t, err, "Invalid KDF")
+}
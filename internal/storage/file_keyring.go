@@ -0,0 +1,268 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+// lockedFileKeyring wraps the keyring "file" backend so that writes are
+// atomic and access is serialized with other aws-sso processes via a lock
+// on <FileDir>.lock
+type lockedFileKeyring struct {
+	keyring.Keyring
+	config keyring.Config
+	dir    string
+}
+
+func newLockedFileKeyring(ring keyring.Keyring, cfg keyring.Config) *lockedFileKeyring {
+	return &lockedFileKeyring{
+		Keyring: ring,
+		config:  cfg,
+		dir:     filepath.Clean(utils.GetHomePath(cfg.FileDir)),
+	}
+}
+
+// isFileBackend returns true if the config selects the "file" backend
+func isFileBackend(cfg *keyring.Config) bool {
+	return len(cfg.AllowedBackends) == 1 && cfg.AllowedBackends[0] == keyring.FileBackend
+}
+
+// cachePassword wraps the PromptFunc so the user is only prompted once
+func cachePassword(fn keyring.PromptFunc) keyring.PromptFunc {
+	var password string
+	return func(prompt string) (string, error) {
+		if password == "" {
+			p, err := fn(prompt)
+			if err != nil {
+				return "", err
+			}
+			password = p
+		}
+		return password, nil
+	}
+}
+
+// Get reads the item under a shared lock.  Since older versions did not
+// write atomically, we retry if the item can't be read.
+func (k *lockedFileKeyring) Get(key string) (keyring.Item, error) {
+	var item keyring.Item
+	var err error
+
+	for i := 0; i < utils.READ_RETRIES; i++ {
+		if i > 0 {
+			log.WithError(err).Debugf("Retrying read of %s", key)
+			time.Sleep(utils.READ_RETRY_DELAY)
+		}
+
+		err = utils.WithFileLock(k.dir, false, func() error {
+			var gerr error
+			item, gerr = k.Keyring.Get(key)
+			return gerr
+		})
+		if err == nil || errors.Is(err, keyring.ErrKeyNotFound) {
+			break
+		}
+	}
+	return item, err
+}
+
+// Set writes the item to a temporary directory using the file backend and
+// then renames it into place under an exclusive lock
+func (k *lockedFileKeyring) Set(item keyring.Item) error {
+	return utils.WithFileLock(k.dir, true, func() error {
+		if err := os.MkdirAll(k.dir, 0700); err != nil {
+			return err
+		}
+
+		tmpDir, err := os.MkdirTemp(filepath.Dir(k.dir), "."+filepath.Base(k.dir)+"-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		cfg := k.config
+		cfg.FileDir = tmpDir
+		ring, err := keyring.Open(cfg)
+		if err != nil {
+			return err
+		}
+		if err = ring.Set(item); err != nil {
+			return err
+		}
+
+		files, err := os.ReadDir(tmpDir)
+		if err != nil {
+			return err
+		}
+		if len(files) != 1 {
+			return fmt.Errorf("Unable to write %s: expected 1 file, found %d", item.Key, len(files))
+		}
+		name := files[0].Name()
+		return os.Rename(filepath.Join(tmpDir, name), filepath.Join(k.dir, name))
+	})
+}
+
+// Remove deletes the item under an exclusive lock
+func (k *lockedFileKeyring) Remove(key string) error {
+	return utils.WithFileLock(k.dir, true, func() error {
+		return k.Keyring.Remove(key)
+	})
+}
+
+// fileKeyringConfig returns the config for a file keyring in dir using password
+func fileKeyringConfig(dir, password string) keyring.Config {
+	return keyring.Config{
+		AllowedBackends:  []keyring.BackendType{keyring.FileBackend},
+		FileDir:          dir,
+		FilePasswordFunc: keyring.FixedStringPrompt(password),
+	}
+}
+
+// verifyFilePassword returns an error if password can't decrypt the file
+// keyring in fileDir
+func verifyFilePassword(fileDir, password string) error {
+	dir := filepath.Clean(utils.GetHomePath(fileDir))
+	ring, err := keyring.Open(fileKeyringConfig(dir, password))
+	if err != nil {
+		return err
+	}
+
+	return utils.WithFileLock(dir, false, func() error {
+		for _, key := range []string{INDEX_KEY, RECORD_KEY} {
+			_, err := ring.Get(key)
+			if err == nil {
+				return nil
+			} else if !errors.Is(err, keyring.ErrKeyNotFound) {
+				return fmt.Errorf("Unable to decrypt %s: invalid password", dir)
+			}
+		}
+		// nothing to decrypt
+		return nil
+	})
+}
+
+// ChangeFileKeyringPassword prompts for the current and new password and
+// re-encrypts every record in the file keyring.  Returns the number of
+// records which were re-encrypted.
+func ChangeFileKeyringPassword(cfg *keyring.Config) (int, error) {
+	if !isFileBackend(cfg) {
+		return 0, fmt.Errorf("Only the file SecureStore has a password")
+	}
+	dir := filepath.Clean(utils.GetHomePath(cfg.FileDir))
+	if _, err := os.Stat(dir); err != nil {
+		return 0, fmt.Errorf("Unable to open file SecureStore: %s", err.Error())
+	}
+
+	oldPassword, err := getPasswordFunc("Enter current password")
+	if err != nil {
+		return 0, fmt.Errorf("Password error: %s", err.Error())
+	}
+	if err = verifyFilePassword(dir, oldPassword); err != nil {
+		return 0, err
+	}
+	newPassword, err := promptNewPassword()
+	if err != nil {
+		return 0, err
+	}
+	if newPassword == oldPassword {
+		return 0, fmt.Errorf("New password must be different from the current password")
+	}
+
+	// same lock order as KeyringStore.updateIndex() + Set()
+	var count int
+	err = utils.WithFileLock(dir+"-index", true, func() error {
+		return utils.WithFileLock(dir, true, func() error {
+			count, err = rekeyFileKeyring(dir, oldPassword, newPassword)
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// any unlocked session has the old password
+	if err = LockFileKeyring(cfg); err != nil {
+		log.WithError(err).Warnf("Unable to lock SecureStore")
+	}
+	return count, nil
+}
+
+// rekeyFileKeyring writes every record in dir encrypted with newPassword to
+// a new directory which then replaces dir.  On failure dir is left untouched.
+// Caller must hold the exclusive lock on dir.
+func rekeyFileKeyring(dir, oldPassword, newPassword string) (int, error) {
+	src, err := keyring.Open(fileKeyringConfig(dir, oldPassword))
+	if err != nil {
+		return 0, err
+	}
+	keys, err := src.Keys()
+	if err != nil {
+		return 0, err
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dst, err := keyring.Open(fileKeyringConfig(tmpDir, newPassword))
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		item, err := src.Get(key)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to decrypt %s: %s", key, err.Error())
+		}
+		if err = dst.Set(item); err != nil {
+			return 0, fmt.Errorf("Unable to encrypt %s: %s", key, err.Error())
+		}
+		check, err := dst.Get(key)
+		if err != nil || !bytes.Equal(item.Data, check.Data) {
+			return 0, fmt.Errorf("Unable to verify %s after encrypting", key)
+		}
+	}
+
+	// swap in the new directory, restoring the old one on failure
+	backup := tmpDir + ".old"
+	if err = os.Rename(dir, backup); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(tmpDir, dir); err != nil {
+		if rerr := os.Rename(backup, dir); rerr != nil {
+			return 0, fmt.Errorf("Unable to replace %s: %s.  Your records are in %s",
+				dir, err.Error(), backup)
+		}
+		return 0, fmt.Errorf("Unable to replace %s: %s", dir, err.Error())
+	}
+	if err = os.RemoveAll(backup); err != nil {
+		log.WithError(err).Warnf("Unable to remove %s", backup)
+	}
+	return len(keys), nil
+}
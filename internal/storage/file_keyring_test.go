@@ -0,0 +1,147 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockedFileKeyring(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+	c, err := NewKeyringConfig("file", d)
+	assert.NoError(t, err)
+	store, err := OpenKeyring(c)
+	assert.NoError(t, err)
+	assert.IsType(t, &lockedFileKeyring{}, store.keyring)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// each goroutine acts as a separate aws-sso process
+			s, err := OpenKeyring(c)
+			assert.NoError(t, err)
+			arn := fmt.Sprintf("arn:aws:iam::123456789012:role/Role%d", i)
+			assert.NoError(t, s.SaveRoleCredentials(arn, RoleCredentials{RoleName: fmt.Sprintf("Role%d", i)}))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		rc := RoleCredentials{}
+		arn := fmt.Sprintf("arn:aws:iam::123456789012:role/Role%d", i)
+		assert.NoError(t, store.GetRoleCredentials(arn, &rc))
+		assert.Equal(t, fmt.Sprintf("Role%d", i), rc.RoleName)
+	}
+
+	// no index updates were lost
+	assert.Len(t, store.ListRoleCredentials(), 10)
+
+	// only our secure dir and lock files remain
+	files, err := os.ReadDir(d)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.ElementsMatch(t, []string{"secure", "secure.lock", "secure-index.lock"}, names)
+
+	// every record file is complete
+	files, err = os.ReadDir(filepath.Join(d, "secure"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 11) // 10 records + index
+}
+
+func TestChangeFileKeyringPassword(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "oldpassword")
+	c, err := NewKeyringConfig("file", d)
+	assert.NoError(t, err)
+	store, err := OpenKeyring(c)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		arn := fmt.Sprintf("arn:aws:iam::123456789012:role/Role%d", i)
+		assert.NoError(t, store.SaveRoleCredentials(arn, RoleCredentials{RoleName: fmt.Sprintf("Role%d", i)}))
+	}
+
+	defer func() { promptPasswordFunc = promptPassword }()
+	newPasswords := []string{"newpassword", "newpassword"}
+	promptPasswordFunc = func(string) (string, error) {
+		var p string
+		p, newPasswords = newPasswords[0], newPasswords[1:]
+		return p, nil
+	}
+
+	count, err := ChangeFileKeyringPassword(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count) // 3 records + index
+
+	// old password no longer works
+	_, err = OpenKeyring(c)
+	assert.ErrorContains(t, err, "Unable to read keyring index")
+
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "newpassword")
+	store, err = OpenKeyring(c)
+	assert.NoError(t, err)
+	assert.Len(t, store.ListRoleCredentials(), 3)
+	rc := RoleCredentials{}
+	assert.NoError(t, store.GetRoleCredentials("arn:aws:iam::123456789012:role/Role1", &rc))
+	assert.Equal(t, "Role1", rc.RoleName)
+
+	// no temporary directories are left behind
+	files, err := os.ReadDir(d)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.ElementsMatch(t, []string{"secure", "secure.lock", "secure-index.lock", "secure.session.lock"}, names)
+
+	// wrong current password leaves the keyring untouched
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "wrongpassword")
+	_, err = ChangeFileKeyringPassword(c)
+	assert.ErrorContains(t, err, "invalid password")
+
+	// new password must be different & match
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "newpassword")
+	newPasswords = []string{"newpassword", "newpassword"}
+	_, err = ChangeFileKeyringPassword(c)
+	assert.ErrorContains(t, err, "must be different")
+	newPasswords = []string{"foo", "bar"}
+	_, err = ChangeFileKeyringPassword(c)
+	assert.ErrorContains(t, err, "missmatch")
+
+	store, err = OpenKeyring(c)
+	assert.NoError(t, err)
+	assert.Len(t, store.ListRoleCredentials(), 3)
+}
@@ -0,0 +1,106 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"time"
+)
+
+// GCReport lists the keys of the records removed (or which would be removed
+// during a dry run) by GarbageCollect
+type GCReport struct {
+	RoleCredentials     []string // expired, by ARN
+	CreateTokenResponse []string // for SSO instances no longer in our config
+	RegisterClientData  []string // expired
+}
+
+// Count returns the total number of records in the report
+func (r GCReport) Count() int {
+	return len(r.RoleCredentials) + len(r.CreateTokenResponse) + len(r.RegisterClientData)
+}
+
+func (r GCReport) String() string {
+	return fmt.Sprintf("%d expired RoleCredentials, %d orphaned CreateTokenResponse, %d expired RegisterClientData",
+		len(r.RoleCredentials), len(r.CreateTokenResponse), len(r.RegisterClientData))
+}
+
+// GarbageCollect removes expired RoleCredentials and RegisterClientData and
+// the CreateTokenResponse of any SSO instance not in ssoNames.  If dryRun is
+// true, nothing is removed.
+func GarbageCollect(store SecureStorage, ssoNames []string, dryRun bool) (GCReport, error) {
+	report := GCReport{
+		RoleCredentials:     []string{},
+		CreateTokenResponse: []string{},
+		RegisterClientData:  []string{},
+	}
+	now := time.Now()
+
+	for _, arn := range store.ListRoleCredentials() {
+		creds := RoleCredentials{}
+		if err := store.GetRoleCredentials(arn, &creds); err != nil {
+			log.WithError(err).Warnf("Unable to read RoleCredentials for %s", arn)
+			continue
+		}
+		if creds.Expiration > now.UnixMilli() { // yes, millisec
+			continue
+		}
+		if !dryRun {
+			if err := store.DeleteRoleCredentials(arn); err != nil {
+				return report, fmt.Errorf("Unable to delete RoleCredentials for %s: %s", arn, err.Error())
+			}
+		}
+		report.RoleCredentials = append(report.RoleCredentials, arn)
+	}
+
+	configured := map[string]bool{}
+	for _, name := range ssoNames {
+		configured[name] = true
+	}
+	for _, key := range store.ListCreateTokenResponse() {
+		if configured[key] {
+			continue
+		}
+		if !dryRun {
+			if err := store.DeleteCreateTokenResponse(key); err != nil {
+				return report, fmt.Errorf("Unable to delete CreateTokenResponse for %s: %s", key, err.Error())
+			}
+		}
+		report.CreateTokenResponse = append(report.CreateTokenResponse, key)
+	}
+
+	for _, key := range store.ListRegisterClientData() {
+		client := RegisterClientData{}
+		if err := store.GetRegisterClientData(key, &client); err != nil {
+			log.WithError(err).Warnf("Unable to read RegisterClientData for %s", key)
+			continue
+		}
+		if client.ClientSecretExpiresAt > now.Unix() {
+			continue
+		}
+		if !dryRun {
+			if err := store.DeleteRegisterClientData(key); err != nil {
+				return report, fmt.Errorf("Unable to delete RegisterClientData for %s: %s", key, err.Error())
+			}
+		}
+		report.RegisterClientData = append(report.RegisterClientData, key)
+	}
+
+	return report, nil
+}
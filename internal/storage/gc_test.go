@@ -0,0 +1,81 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	js, err := OpenJsonStore(filepath.Join(dir, "store.json"))
+	assert.NoError(t, err)
+
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	assert.NoError(t, js.SaveRoleCredentials("arn:aws:iam::123456789012:role/Valid", RoleCredentials{
+		Expiration: future.UnixMilli(),
+	}))
+	assert.NoError(t, js.SaveRoleCredentials("arn:aws:iam::123456789012:role/Expired", RoleCredentials{
+		Expiration: past.UnixMilli(),
+	}))
+	assert.NoError(t, js.SaveCreateTokenResponse("Default", CreateTokenResponse{ExpiresAt: past.Unix()}))
+	assert.NoError(t, js.SaveCreateTokenResponse("Removed", CreateTokenResponse{ExpiresAt: future.Unix()}))
+	assert.NoError(t, js.SaveRegisterClientData("Default", RegisterClientData{ClientSecretExpiresAt: future.Unix()}))
+	assert.NoError(t, js.SaveRegisterClientData("Other", RegisterClientData{ClientSecretExpiresAt: past.Unix()}))
+	assert.NoError(t, js.SaveStaticCredentials("arn:aws:iam::123456789012:user/foo", StaticCredentials{}))
+
+	expected := GCReport{
+		RoleCredentials:     []string{"arn:aws:iam::123456789012:role/Expired"},
+		CreateTokenResponse: []string{"Removed"},
+		RegisterClientData:  []string{"Other"},
+	}
+
+	// dry run doesn't remove anything
+	report, err := GarbageCollect(js, []string{"Default"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, report)
+	assert.Equal(t, 3, report.Count())
+	assert.Len(t, js.ListRoleCredentials(), 2)
+	assert.Len(t, js.ListCreateTokenResponse(), 2)
+	assert.Len(t, js.ListRegisterClientData(), 2)
+
+	report, err = GarbageCollect(js, []string{"Default"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, report)
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/Valid"}, js.ListRoleCredentials())
+	// expired tokens for configured SSO instances are refreshed, not removed
+	assert.Equal(t, []string{"Default"}, js.ListCreateTokenResponse())
+	assert.Equal(t, []string{"Default"}, js.ListRegisterClientData())
+	assert.Len(t, js.ListStaticCredentials(), 1)
+
+	report, err = GarbageCollect(js, []string{"Default"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Count())
+	assert.Contains(t, report.String(), "0 expired RoleCredentials")
+}
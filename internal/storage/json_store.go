@@ -20,8 +20,10 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 
 	// "github.com/davecgh/go-spew/spew"
 	"github.com/synfinatic/aws-sso-cli/internal/utils"
@@ -30,35 +32,146 @@ import (
 // JsonStore implements SecureStorage insecurely
 type JsonStore struct {
 	filename            string
+	seal                func([]byte) ([]byte, error)   // optionally encrypts our file
+	unseal              func([]byte) ([]byte, error)   // optionally decrypts our file
+	info                os.FileInfo                    // of our file when we last read/wrote it
+	lock                sync.Mutex                     // protects our records
 	RegisterClient      map[string]RegisterClientData  `json:"RegisterClient,omitempty"`
 	StartDeviceAuth     map[string]StartDeviceAuthData `json:"StartDeviceAuth,omitempty"`
 	CreateTokenResponse map[string]CreateTokenResponse `json:"CreateTokenResponse,omitempty"`
 	RoleCredentials     map[string]RoleCredentials     `json:"RoleCredentials,omitempty"`   // ARN = key
 	StaticCredentials   map[string]StaticCredentials   `json:"StaticCredentials,omitempty"` // ARN = key
+	EcsSlots            map[string]EcsSlots            `json:"EcsSlots,omitempty"`
 }
 
-// OpenJsonStore opens our insecure JSON storage backend
-func OpenJsonStore(fileName string) (*JsonStore, error) {
-	cache := JsonStore{
+// newJsonStore returns an empty JsonStore for the given file
+func newJsonStore(fileName string) *JsonStore {
+	return &JsonStore{
 		filename:            fileName,
 		RegisterClient:      map[string]RegisterClientData{},
 		StartDeviceAuth:     map[string]StartDeviceAuthData{},
 		CreateTokenResponse: map[string]CreateTokenResponse{},
 		RoleCredentials:     map[string]RoleCredentials{},
 		StaticCredentials:   map[string]StaticCredentials{},
+		EcsSlots:            map[string]EcsSlots{},
 	}
+}
 
-	cacheBytes, err := os.ReadFile(fileName)
-	if err != nil {
+// OpenJsonStore opens our insecure JSON storage backend
+func OpenJsonStore(fileName string) (*JsonStore, error) {
+	cache := newJsonStore(fileName)
+
+	err := cache.read()
+	if errors.Is(err, os.ErrNotExist) {
 		log.Infof("Creating new cache file: %s", fileName)
-	} else if len(cacheBytes) > 0 {
-		err = json.Unmarshal(cacheBytes, &cache)
+		err = nil
+	}
+
+	return cache, err
+}
+
+// parse replaces all of our records with the contents of our file
+func (jc *JsonStore) parse(data []byte) error {
+	var err error
+	if jc.unseal != nil {
+		if data, err = jc.unseal(data); err != nil {
+			return err
+		}
+	}
+
+	records := newJsonStore(jc.filename)
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, records); err != nil {
+			return err
+		}
+	}
+
+	jc.RegisterClient = records.RegisterClient
+	jc.StartDeviceAuth = records.StartDeviceAuth
+	jc.CreateTokenResponse = records.CreateTokenResponse
+	jc.RoleCredentials = records.RoleCredentials
+	jc.StaticCredentials = records.StaticCredentials
+	jc.EcsSlots = records.EcsSlots
+	return nil
+}
+
+// stat records the inode, modification time & size of our file.  Must be
+// called before reading the file so we never miss a change.
+func (jc *JsonStore) stat() {
+	if info, err := os.Stat(jc.filename); err == nil {
+		jc.info = info
+	}
+}
+
+// changed returns true if another process modified our file since we last
+// read or wrote it
+func (jc *JsonStore) changed() bool {
+	info, err := os.Stat(jc.filename)
+	if err != nil {
+		return false
 	}
+	if jc.info == nil {
+		return true
+	}
+	// every write replaces the file, so the inode changes too
+	return !os.SameFile(info, jc.info) || !info.ModTime().Equal(jc.info.ModTime()) ||
+		info.Size() != jc.info.Size()
+}
+
+// read loads our file under a shared lock
+func (jc *JsonStore) read() error {
+	jc.stat()
+	return utils.ReadLockedFile(jc.filename, jc.parse)
+}
+
+// reload re-reads our file if another aws-sso process has changed it so we
+// see their tokens & credentials.  Caller must hold jc.lock.
+func (jc *JsonStore) reload() {
+	if !jc.changed() {
+		return
+	}
+	log.Debugf("Reloading %s", jc.filename)
+	if err := jc.read(); err != nil {
+		log.WithError(err).Warnf("Unable to reload %s", jc.filename)
+	}
+}
+
+// update applies fn to our records and writes the JSON store file.  The
+// file is locked so we can merge our change with any made by other
+// aws-sso processes.
+func (jc *JsonStore) update(fn func() error) error {
+	jc.lock.Lock()
+	defer jc.lock.Unlock()
+
+	return utils.WithFileLock(jc.filename, true, func() error {
+		if jc.changed() {
+			jc.stat()
+			data, err := os.ReadFile(jc.filename)
+			if err == nil {
+				err = jc.parse(data)
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to reload %s: %s", jc.filename, err.Error())
+			}
+		}
+
+		if err := fn(); err != nil {
+			return err
+		}
+		return jc.save()
+	})
+}
 
-	return &cache, err
+// get calls fn with the latest version of our records
+func (jc *JsonStore) get(fn func() error) error {
+	jc.lock.Lock()
+	defer jc.lock.Unlock()
+
+	jc.reload()
+	return fn()
 }
 
-// save writes the JSON store file, creating the directory if necessary
+// save atomically writes the JSON store file.  Caller must hold the file lock.
 func (jc *JsonStore) save() error {
 	log.Debugf("Saving JSON Cache")
 	jbytes, err := json.MarshalIndent(jc, "", "  ")
@@ -66,114 +179,210 @@ func (jc *JsonStore) save() error {
 		log.WithError(err).Errorf("Unable to marshal json")
 		return err
 	}
-	err = utils.EnsureDirExists(jc.filename)
-	if err != nil {
-		return err
+	if jc.seal != nil {
+		if jbytes, err = jc.seal(jbytes); err != nil {
+			return err
+		}
 	}
 
-	return os.WriteFile(jc.filename, jbytes, 0600)
+	if err = utils.AtomicWriteFile(jc.filename, jbytes, 0600); err != nil {
+		return err
+	}
+	jc.stat()
+	return nil
 }
 
 // SaveRegisterClientData saves the RegisterClientData in our JSON store
 func (jc *JsonStore) SaveRegisterClientData(key string, client RegisterClientData) error {
-	jc.RegisterClient[key] = client
-	return jc.save()
+	return jc.update(func() error {
+		jc.RegisterClient[key] = client
+		return nil
+	})
 }
 
 // GetRegisterClientData retrieves the RegisterClientData from our JSON store
 func (jc *JsonStore) GetRegisterClientData(key string, client *RegisterClientData) error {
-	var ok bool
-	*client, ok = jc.RegisterClient[key]
-	if !ok {
-		return fmt.Errorf("No RegisterClientData for %s", key)
-	}
-	return nil
+	return jc.get(func() error {
+		var ok bool
+		*client, ok = jc.RegisterClient[key]
+		if !ok {
+			return fmt.Errorf("No RegisterClientData for %s", key)
+		}
+		return nil
+	})
 }
 
 // DeleteRegisterClientData deletes the RegisterClientData from the JSON store
 func (jc *JsonStore) DeleteRegisterClientData(key string) error {
-	delete(jc.RegisterClient, key)
-	return jc.save()
+	return jc.update(func() error {
+		delete(jc.RegisterClient, key)
+		return nil
+	})
+}
+
+// ListRegisterClientData returns the keys of all the RegisterClientData
+func (jc *JsonStore) ListRegisterClientData() []string {
+	var ret []string
+	_ = jc.get(func() error {
+		ret = listKeys(jc.RegisterClient, "")
+		return nil
+	})
+	return ret
 }
 
 // SaveCreateTokenResponse stores the token in the json file
 func (jc *JsonStore) SaveCreateTokenResponse(key string, token CreateTokenResponse) error {
-	jc.CreateTokenResponse[key] = token
-	return jc.save()
+	return jc.update(func() error {
+		jc.CreateTokenResponse[key] = token
+		return nil
+	})
 }
 
 // GetCreateTokenResponse retrieves the CreateTokenResponse from the json file
 func (jc *JsonStore) GetCreateTokenResponse(key string, token *CreateTokenResponse) error {
-	var ok bool
-	*token, ok = jc.CreateTokenResponse[key]
-	if !ok {
-		return fmt.Errorf("No CreateTokenResponse for %s", key)
-	}
-	return nil
+	return jc.get(func() error {
+		var ok bool
+		*token, ok = jc.CreateTokenResponse[key]
+		if !ok {
+			return fmt.Errorf("No CreateTokenResponse for %s", key)
+		}
+		return nil
+	})
 }
 
 // DeleteCreateTokenResponse deletes the token from the json file
 func (jc *JsonStore) DeleteCreateTokenResponse(key string) error {
-	delete(jc.CreateTokenResponse, key)
-	return jc.save()
+	return jc.update(func() error {
+		delete(jc.CreateTokenResponse, key)
+		return nil
+	})
+}
+
+// ListCreateTokenResponse returns the keys of all the CreateTokenResponses
+func (jc *JsonStore) ListCreateTokenResponse() []string {
+	var ret []string
+	_ = jc.get(func() error {
+		ret = listKeys(jc.CreateTokenResponse, "")
+		return nil
+	})
+	return ret
 }
 
 // SaveRoleCredentials stores the token in the json file
 func (jc *JsonStore) SaveRoleCredentials(arn string, token RoleCredentials) error {
-	jc.RoleCredentials[arn] = token
-	return jc.save()
+	return jc.update(func() error {
+		jc.RoleCredentials[arn] = token
+		return nil
+	})
 }
 
 // GetRoleCredentials retrieves the RoleCredentials from the json file
 func (jc *JsonStore) GetRoleCredentials(arn string, token *RoleCredentials) error {
-	var ok bool
-	*token, ok = jc.RoleCredentials[arn]
-	if !ok {
-		return fmt.Errorf("No RoleCredentials for ARN: %s", arn)
-	}
-	return nil
+	return jc.get(func() error {
+		var ok bool
+		*token, ok = jc.RoleCredentials[arn]
+		if !ok {
+			return fmt.Errorf("No RoleCredentials for ARN: %s", arn)
+		}
+		return nil
+	})
 }
 
 // DeleteRoleCredentials deletes the token from the json file
 func (jc *JsonStore) DeleteRoleCredentials(arn string) error {
-	delete(jc.RoleCredentials, arn)
-	return jc.save()
+	return jc.update(func() error {
+		delete(jc.RoleCredentials, arn)
+		return nil
+	})
+}
+
+// ListRoleCredentials returns all the ARN's of role credentials
+func (jc *JsonStore) ListRoleCredentials() []string {
+	var ret []string
+	_ = jc.get(func() error {
+		ret = listKeys(jc.RoleCredentials, "")
+		return nil
+	})
+	return ret
 }
 
 // SaveStaticCredentials stores the token in the json file
 func (jc *JsonStore) SaveStaticCredentials(arn string, creds StaticCredentials) error {
-	jc.StaticCredentials[arn] = creds
-	return jc.save()
+	return jc.update(func() error {
+		jc.StaticCredentials[arn] = creds
+		return nil
+	})
 }
 
 // GetStaticCredentials retrieves the StaticCredentials from the json file
 func (jc *JsonStore) GetStaticCredentials(arn string, creds *StaticCredentials) error {
-	var ok bool
-	*creds, ok = jc.StaticCredentials[arn]
-	if !ok {
-		return fmt.Errorf("No StaticCredentials for ARN: %s", arn)
-	}
-	return nil
+	return jc.get(func() error {
+		var ok bool
+		*creds, ok = jc.StaticCredentials[arn]
+		if !ok {
+			return fmt.Errorf("No StaticCredentials for ARN: %s", arn)
+		}
+		return nil
+	})
 }
 
 // DeleteStaticCredentials deletes the StaticCredentials from the json file
 func (jc *JsonStore) DeleteStaticCredentials(arn string) error {
-	if _, ok := jc.StaticCredentials[arn]; !ok {
-		// return error if key doesn't exist
-		return fmt.Errorf("No StaticCredentials for ARN: %s", arn)
-	}
+	return jc.update(func() error {
+		if _, ok := jc.StaticCredentials[arn]; !ok {
+			// return error if key doesn't exist
+			return fmt.Errorf("No StaticCredentials for ARN: %s", arn)
+		}
 
-	delete(jc.StaticCredentials, arn)
-	return jc.save()
+		delete(jc.StaticCredentials, arn)
+		return nil
+	})
 }
 
 // ListStaticCredentials returns all the ARN's of static credentials
 func (jc *JsonStore) ListStaticCredentials() []string {
-	ret := make([]string, len(jc.StaticCredentials))
-	i := 0
-	for k := range jc.StaticCredentials {
-		ret[i] = k
-		i++
-	}
+	var ret []string
+	_ = jc.get(func() error {
+		ret = listKeys(jc.StaticCredentials, "")
+		return nil
+	})
+	return ret
+}
+
+// SaveEcsSlots stores the ECS Server slots in the json file
+func (jc *JsonStore) SaveEcsSlots(key string, slots EcsSlots) error {
+	return jc.update(func() error {
+		jc.EcsSlots[key] = slots
+		return nil
+	})
+}
+
+// GetEcsSlots retrieves the ECS Server slots from the json file
+func (jc *JsonStore) GetEcsSlots(key string, slots *EcsSlots) error {
+	return jc.get(func() error {
+		var ok bool
+		*slots, ok = jc.EcsSlots[key]
+		if !ok {
+			return fmt.Errorf("No EcsSlots for %s", key)
+		}
+		return nil
+	})
+}
+
+// DeleteEcsSlots deletes the ECS Server slots from the json file
+func (jc *JsonStore) DeleteEcsSlots(key string) error {
+	return jc.update(func() error {
+		delete(jc.EcsSlots, key)
+		return nil
+	})
+}
+
+// ListEcsSlots returns the keys of all the ECS Server slots
+func (jc *JsonStore) ListEcsSlots() []string {
+	var ret []string
+	_ = jc.get(func() error {
+		ret = listKeys(jc.EcsSlots, "")
+		return nil
+	})
 	return ret
 }
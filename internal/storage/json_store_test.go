@@ -184,3 +184,76 @@ func (s *JsonStoreTestSuite) TestStaticCredentials() {
 	assert.NoError(t, s.json.GetStaticCredentials("arn:aws:iam::123456789012:user/foobar", &cr))
 	assert.Equal(t, cr2, cr)
 }
+
+func (s *JsonStoreTestSuite) TestEcsSlots() {
+	t := s.T()
+
+	slots := EcsSlots{}
+	assert.Error(t, s.json.GetEcsSlots("tcp:127.0.0.1:4144", &slots))
+
+	saved := EcsSlots{
+		Default: &EcsSlot{
+			ProfileName: "foo",
+			Creds:       RoleCredentials{RoleName: "foo", AccountId: 123456789012},
+		},
+		Slots: map[string]EcsSlot{
+			"bar": {
+				ProfileName: "bar",
+				Creds:       RoleCredentials{RoleName: "bar", AccountId: 123456789012},
+			},
+		},
+	}
+	assert.NoError(t, s.json.SaveEcsSlots("tcp:127.0.0.1:4144", saved))
+	assert.NoError(t, s.json.GetEcsSlots("tcp:127.0.0.1:4144", &slots))
+	assert.Equal(t, saved, slots)
+
+	// reload from disk
+	js, err := OpenJsonStore(s.jsonFile)
+	assert.NoError(t, err)
+	slots = EcsSlots{}
+	assert.NoError(t, js.GetEcsSlots("tcp:127.0.0.1:4144", &slots))
+	assert.Equal(t, saved, slots)
+
+	assert.NoError(t, s.json.DeleteEcsSlots("tcp:127.0.0.1:4144"))
+	assert.Error(t, s.json.GetEcsSlots("tcp:127.0.0.1:4144", &slots))
+}
+
+func (s *JsonStoreTestSuite) TestMultipleStores() {
+	t := s.T()
+
+	// another aws-sso process using the same file
+	other, err := OpenJsonStore(s.jsonFile)
+	assert.NoError(t, err)
+
+	token := CreateTokenResponse{
+		AccessToken: "from-another-process",
+		ExpiresAt:   1637723379,
+	}
+	assert.NoError(t, other.SaveCreateTokenResponse("other", token))
+
+	// we see their changes
+	read := CreateTokenResponse{}
+	assert.NoError(t, s.json.GetCreateTokenResponse("other", &read))
+	assert.Equal(t, token, read)
+	assert.Contains(t, s.json.ListCreateTokenResponse(), "other")
+
+	// and our writes don't clobber theirs
+	creds := RoleCredentials{RoleName: "Foo", AccountId: 123456789012}
+	assert.NoError(t, s.json.SaveRoleCredentials("arn:aws:iam::123456789012:role/Foo", creds))
+	assert.NoError(t, other.SaveStaticCredentials("arn:aws:iam::123456789012:user/bar", StaticCredentials{
+		UserName:  "bar",
+		AccountId: 123456789012,
+	}))
+
+	js, err := OpenJsonStore(s.jsonFile)
+	assert.NoError(t, err)
+	assert.NoError(t, js.GetCreateTokenResponse("other", &read))
+	readCreds := RoleCredentials{}
+	assert.NoError(t, js.GetRoleCredentials("arn:aws:iam::123456789012:role/Foo", &readCreds))
+	assert.Equal(t, creds, readCreds)
+	assert.Contains(t, js.ListStaticCredentials(), "arn:aws:iam::123456789012:user/bar")
+
+	// deletes are seen too
+	assert.NoError(t, other.DeleteCreateTokenResponse("other"))
+	assert.Error(t, s.json.GetCreateTokenResponse("other", &read))
+}
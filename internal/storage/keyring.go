@@ -21,10 +21,13 @@ package storage
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
+	"sync"
 
 	"github.com/99designs/keyring"
 	// "github.com/davecgh/go-spew/spew"
@@ -34,26 +37,53 @@ import (
 
 const (
 	KEYRING_ID                   = "aws-sso-cli"
-	RECORD_KEY                   = "aws-sso-cli-records"
+	RECORD_KEY                   = "aws-sso-cli-records" // legacy single blob of StorageData
+	INDEX_KEY                    = "aws-sso-cli-index"
+	INDEX_VERSION                = 1
 	KEYRING_NAME                 = "awsssocli"
 	REGISTER_CLIENT_DATA_PREFIX  = "client-data"
 	CREATE_TOKEN_RESPONSE_PREFIX = "token-response"
+	ROLE_CREDENTIALS_PREFIX      = "role-credentials"
+	STATIC_CREDENTIALS_PREFIX    = "static-credentials"
+	ECS_SLOTS_PREFIX             = "ecs-slots"
 	ENV_SSO_FILE_PASSWORD        = "AWS_SSO_FILE_PASSWORD" // #nosec
 	WINCRED_MAX_LENGTH           = 2000
 )
 
-// Implements SecureStorage
+// KeyringStore implements SecureStorage by storing each record under its own
+// key in the keyring so that concurrent aws-sso processes don't overwrite
+// each other's records
 type KeyringStore struct {
-	keyring KeyringAPI
-	config  keyring.Config
-	cache   StorageData
+	keyring   KeyringAPI
+	config    keyring.Config
+	index     keyringIndex
+	indexLock string     // lock file serializing index updates across processes
+	lock      sync.Mutex // protects index
 }
 
+// keyringIndex lists the keys of every record in our KeyringStore since not
+// all keyring backends support listing keys
+type keyringIndex struct {
+	Version int             `json:"Version"`
+	Records map[string]bool `json:"Records"` // keyring key = key
+}
+
+func newKeyringIndex() keyringIndex {
+	return keyringIndex{
+		Version: INDEX_VERSION,
+		Records: map[string]bool{},
+	}
+}
+
+// StorageData is the legacy format of the KeyringStore which stored every
+// record in a single keyring entry.  It is only used for migrating to our
+// per-record format.
 type StorageData struct {
 	RegisterClientData  map[string]RegisterClientData
 	CreateTokenResponse map[string]CreateTokenResponse
 	RoleCredentials     map[string]RoleCredentials
 	StaticCredentials   map[string]StaticCredentials
+	EcsSlots            map[string]EcsSlots
 }
 
 func NewStorageData() StorageData {
@@ -62,6 +92,7 @@ func NewStorageData() StorageData {
 		CreateTokenResponse: map[string]CreateTokenResponse{},
 		RoleCredentials:     map[string]RoleCredentials{},
 		StaticCredentials:   map[string]StaticCredentials{},
+		EcsSlots:            map[string]EcsSlots{},
 	}
 }
 
@@ -98,7 +129,7 @@ func NewKeyringConfig(name, configDir string) (*keyring.Config, error) {
 		// KeychainPasswordFunc: ???,
 		// Other systems below this line
 		FileDir:                 securePath,
-		FilePasswordFunc:        fileKeyringPassword,
+		FilePasswordFunc:        sessionPasswordFunc(securePath, fileKeyringPassword),
 		LibSecretCollectionName: KEYRING_NAME,
 		KWalletAppID:            KEYRING_ID,
 		KWalletFolder:           KEYRING_ID,
@@ -107,9 +138,10 @@ func NewKeyringConfig(name, configDir string) (*keyring.Config, error) {
 	if name != "" {
 		c.AllowedBackends = []keyring.BackendType{keyring.BackendType(name)}
 		rolesFile := utils.GetHomePath(path.Join(securePath, RECORD_KEY))
+		indexFile := utils.GetHomePath(path.Join(securePath, INDEX_KEY))
 
 		if name == "file" {
-			if _, err := os.Stat(rolesFile); os.IsNotExist(err) {
+			if !fileExists(rolesFile) && !fileExists(indexFile) {
 				// new secure store, so we should prompt user twice for password
 				// if ENV var is not set
 				if password := os.Getenv(ENV_SSO_FILE_PASSWORD); password == "" {
@@ -132,6 +164,11 @@ func NewKeyringConfig(name, configDir string) (*keyring.Config, error) {
 	return &c, nil
 }
 
+func fileExists(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return !os.IsNotExist(err)
+}
+
 func fileKeyringPassword(prompt string) (string, error) {
 	if password := os.Getenv(ENV_SSO_FILE_PASSWORD); password != "" {
 		return password, nil
@@ -139,7 +176,13 @@ func fileKeyringPassword(prompt string) (string, error) {
 	if NewPassword != "" {
 		return NewPassword, nil
 	}
+	return promptPasswordFunc(prompt)
+}
 
+var promptPasswordFunc getPassword = promptPassword
+
+// promptPassword always reads the password from the terminal
+func promptPassword(prompt string) (string, error) {
 	fmt.Fprintf(os.Stderr, "%s: ", prompt)
 	b, err := term.ReadPassword(int(os.Stdin.Fd()))
 	if err != nil {
@@ -154,19 +197,51 @@ func fileKeyringPassword(prompt string) (string, error) {
 	return s, nil
 }
 
+// promptNewPassword prompts the user twice for a new password, ignoring
+// $AWS_SSO_FILE_PASSWORD
+func promptNewPassword() (string, error) {
+	pass1, err := promptPasswordFunc("Select new password")
+	if err != nil {
+		return "", fmt.Errorf("Password error: %s", err.Error())
+	}
+	pass2, err := promptPasswordFunc("Verify new password")
+	if err != nil {
+		return "", fmt.Errorf("Password error: %s", err.Error())
+	}
+	if pass1 != pass2 {
+		return "", fmt.Errorf("Password missmatch")
+	}
+	return pass1, nil
+}
+
 func OpenKeyring(cfg *keyring.Config) (*KeyringStore, error) {
-	ring, err := keyring.Open(*cfg)
+	c := *cfg
+	if isFileBackend(&c) {
+		// our lockedFileKeyring opens additional file keyrings
+		c.FilePasswordFunc = cachePassword(c.FilePasswordFunc)
+	}
+
+	ring, err := keyring.Open(c)
 	if err != nil {
 		return nil, err
 	}
 	kr := KeyringStore{
-		keyring: ring,
-		config:  *cfg,
-		cache:   NewStorageData(),
+		keyring:   ring,
+		config:    c,
+		index:     newKeyringIndex(),
+		indexLock: filepath.Clean(utils.GetHomePath(c.FileDir)) + "-index",
+	}
+	if isFileBackend(&c) {
+		kr.keyring = newLockedFileKeyring(ring, c)
 	}
 
-	if err = kr.getStorageData(&kr.cache); err != nil {
-		return nil, err
+	if err = kr.loadIndex(); errors.Is(err, keyring.ErrKeyNotFound) {
+		// new keyring or one created by an older version
+		if err = kr.migrateStorageData(); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read keyring index: %s", err.Error())
 	}
 
 	return &kr, nil
@@ -180,20 +255,13 @@ type Unmarshaler func([]byte, interface{}) error
 
 var storageDataUnmarshal Unmarshaler = json.Unmarshal
 
-// loads the entire StorageData into memory
+// loads the legacy StorageData into memory
 func (kr *KeyringStore) getStorageData(s *StorageData) error {
-	var data []byte
-	var err error
-
-	switch keyringGOOS {
-	case "windows":
-		data, err = kr.joinAndGetKeyringData(RECORD_KEY)
-	default:
-		data, err = kr.getKeyringData(RECORD_KEY)
-	}
-
+	data, err := kr.getRecordData(RECORD_KEY)
 	if err != nil {
-		log.Warn(err)
+		if !errors.Is(err, keyring.ErrKeyNotFound) {
+			log.Warn(err)
+		}
 		// Didn't find anything in our keyring
 		*s = NewStorageData()
 		return nil
@@ -205,6 +273,163 @@ func (kr *KeyringStore) getStorageData(s *StorageData) error {
 	return nil
 }
 
+// migrateStorageData converts the StorageData written by older versions
+// as a single keyring entry into individual records and creates our index
+func (kr *KeyringStore) migrateStorageData() error {
+	s := NewStorageData()
+	if err := kr.getStorageData(&s); err != nil {
+		return err
+	}
+
+	records := map[string]interface{}{}
+	for key, client := range s.RegisterClientData {
+		records[key] = client // already has our prefix
+	}
+	for key, token := range s.CreateTokenResponse {
+		records[key] = token // already has our prefix
+	}
+	for arn, creds := range s.RoleCredentials {
+		records[kr.RoleCredentialsKey(arn)] = creds
+	}
+	for arn, creds := range s.StaticCredentials {
+		records[kr.StaticCredentialsKey(arn)] = creds
+	}
+	for key, slots := range s.EcsSlots {
+		records[key] = slots // already has our prefix
+	}
+
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	err := kr.withIndexLock(func() error {
+		// another aws-sso process may have created the index in the meantime
+		kr.refreshIndex()
+		for key, record := range records {
+			data, _ := json.Marshal(record)
+			if err := kr.setRecordData(key, data); err != nil {
+				return fmt.Errorf("Unable to migrate %s: %s", key, err.Error())
+			}
+			kr.index.Records[key] = true
+		}
+		return kr.saveIndex()
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(records) > 0 {
+		log.Infof("Migrated %d records to the new keyring format", len(records))
+		if err := kr.removeRecordData(RECORD_KEY); err != nil {
+			log.WithError(err).Warnf("Unable to remove %s from keyring", RECORD_KEY)
+		}
+	}
+	return nil
+}
+
+// loadIndex reads our index from the keyring.  Caller must hold kr.lock
+// if the KeyringStore is in use.
+func (kr *KeyringStore) loadIndex() error {
+	data, err := kr.getRecordData(INDEX_KEY)
+	if err != nil {
+		return err
+	}
+
+	index := keyringIndex{}
+	if err = json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+	if index.Records == nil {
+		index.Records = map[string]bool{}
+	}
+	kr.index = index
+	return nil
+}
+
+// saveIndex writes our index to the keyring.  Caller must hold kr.lock
+func (kr *KeyringStore) saveIndex() error {
+	data, _ := json.Marshal(kr.index)
+	return kr.setRecordData(INDEX_KEY, data)
+}
+
+// refreshIndex re-reads our index so we see records added or removed by
+// other aws-sso processes.  Caller must hold kr.lock
+func (kr *KeyringStore) refreshIndex() {
+	if err := kr.loadIndex(); err != nil && !errors.Is(err, keyring.ErrKeyNotFound) {
+		log.WithError(err).Warnf("Unable to refresh keyring index")
+	}
+}
+
+// withIndexLock calls fn while holding an exclusive lock which serializes
+// updates to our index with other aws-sso processes since keyring backends
+// can't atomically update an entry.  Caller must hold kr.lock
+func (kr *KeyringStore) withIndexLock(fn func() error) error {
+	if kr.indexLock == "" {
+		return fn() // not opened via OpenKeyring()
+	}
+	return utils.WithFileLock(kr.indexLock, true, fn)
+}
+
+// updateIndex adds or removes the key from our index, only writing the index
+// if it changed
+func (kr *KeyringStore) updateIndex(key string, add bool) error {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	return kr.withIndexLock(func() error {
+		kr.refreshIndex()
+		if kr.index.Records[key] == add {
+			return nil
+		}
+
+		if add {
+			kr.index.Records[key] = true
+		} else {
+			delete(kr.index.Records, key)
+		}
+		return kr.saveIndex()
+	})
+}
+
+// listRecords returns the keys of all the records with the given prefix,
+// with the prefix removed
+func (kr *KeyringStore) listRecords(prefix string) []string {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	kr.refreshIndex()
+	return listKeys(kr.index.Records, prefix)
+}
+
+// saveRecord writes a single record to the keyring and adds it to our index
+func (kr *KeyringStore) saveRecord(key string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err = kr.setRecordData(key, data); err != nil {
+		return err
+	}
+	return kr.updateIndex(key, true)
+}
+
+// getRecord reads a single record from the keyring
+func (kr *KeyringStore) getRecord(key string, record interface{}) error {
+	data, err := kr.getRecordData(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, record)
+}
+
+// deleteRecord removes a single record from the keyring and our index
+func (kr *KeyringStore) deleteRecord(key string) error {
+	err := kr.removeRecordData(key)
+	if ierr := kr.updateIndex(key, false); err == nil {
+		err = ierr
+	}
+	return err
+}
+
 // reads a single entry of the keyring
 func (kr *KeyringStore) getKeyringData(key string) ([]byte, error) {
 	data, err := kr.keyring.Get(key)
@@ -246,20 +471,6 @@ func (kr *KeyringStore) joinAndGetKeyringData(key string) ([]byte, error) {
 	return data, nil
 }
 
-// saves the entire StorageData into our KeyringStore
-func (kr *KeyringStore) saveStorageData() error {
-	var err error
-	jdata, _ := json.Marshal(kr.cache)
-
-	switch keyringGOOS {
-	case "windows":
-		err = kr.splitAndSetStorageData(jdata, RECORD_KEY, KEYRING_ID)
-	default:
-		err = kr.setStorageData(jdata, RECORD_KEY, KEYRING_ID)
-	}
-	return err
-}
-
 // splitAndSetStorageData writes all the data in WINCRED_MAX_LENGTH length chunks
 func (kr *KeyringStore) splitAndSetStorageData(jdata []byte, key string, label string) error {
 	var i int
@@ -303,34 +514,69 @@ func (kr *KeyringStore) setStorageData(jdata []byte, key string, label string) e
 	return err
 }
 
+// getRecordData reads the data for a key, which is split into multiple
+// chunks on Windows
+func (kr *KeyringStore) getRecordData(key string) ([]byte, error) {
+	if keyringGOOS == "windows" {
+		return kr.joinAndGetKeyringData(key)
+	}
+	return kr.getKeyringData(key)
+}
+
+// setRecordData writes the data for a key, which is split into multiple
+// chunks on Windows
+func (kr *KeyringStore) setRecordData(key string, data []byte) error {
+	if keyringGOOS == "windows" {
+		return kr.splitAndSetStorageData(data, key, KEYRING_ID)
+	}
+	return kr.setStorageData(data, key, KEYRING_ID)
+}
+
+// removeRecordData removes a key, and all of its chunks on Windows
+func (kr *KeyringStore) removeRecordData(key string) error {
+	if keyringGOOS != "windows" {
+		return kr.keyring.Remove(key)
+	}
+
+	if err := kr.keyring.Remove(fmt.Sprintf("%s_%d", key, 0)); err != nil {
+		return err
+	}
+	for i := 1; ; i++ {
+		if err := kr.keyring.Remove(fmt.Sprintf("%s_%d", key, i)); err != nil {
+			break // no more chunks
+		}
+	}
+	return nil
+}
+
+// notFound converts keyring.ErrKeyNotFound into a more helpful error
+func notFound(err error, format string, args ...interface{}) error {
+	if errors.Is(err, keyring.ErrKeyNotFound) || errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf(format, args...)
+	}
+	return err
+}
+
 // Save our RegisterClientData in the key chain
 func (kr *KeyringStore) SaveRegisterClientData(region string, client RegisterClientData) error {
-	key := kr.RegisterClientKey(region)
-	kr.cache.RegisterClientData[key] = client
-
-	return kr.saveStorageData()
+	return kr.saveRecord(kr.RegisterClientKey(region), client)
 }
 
 // Get our RegisterClientData from the key chain
 func (kr *KeyringStore) GetRegisterClientData(region string, client *RegisterClientData) error {
-	var ok bool
-	key := kr.RegisterClientKey(region)
-	if *client, ok = kr.cache.RegisterClientData[key]; !ok {
-		return fmt.Errorf("No RegisterClientData for %s", region)
-	}
-	return nil
+	err := kr.getRecord(kr.RegisterClientKey(region), client)
+	return notFound(err, "No RegisterClientData for %s", region)
 }
 
 // Delete the RegisterClientData from the keychain
 func (kr *KeyringStore) DeleteRegisterClientData(region string) error {
 	key := kr.RegisterClientKey(region)
-	if _, ok := kr.cache.RegisterClientData[key]; !ok {
-		// return error if key doesn't exist
-		return fmt.Errorf("No RegisterClientData for key: %s", key)
-	}
+	return notFound(kr.deleteRecord(key), "No RegisterClientData for key: %s", key)
+}
 
-	delete(kr.cache.RegisterClientData, key)
-	return kr.saveStorageData()
+// ListRegisterClientData returns the keys of all the RegisterClientData
+func (kr *KeyringStore) ListRegisterClientData() []string {
+	return kr.listRecords(kr.RegisterClientKey(""))
 }
 
 func (kr *KeyringStore) CreateTokenResponseKey(key string) string {
@@ -339,92 +585,107 @@ func (kr *KeyringStore) CreateTokenResponseKey(key string) string {
 
 // SaveCreateTokenResponse stores the token in the keyring
 func (kr *KeyringStore) SaveCreateTokenResponse(key string, token CreateTokenResponse) error {
-	k := kr.CreateTokenResponseKey(key)
-	kr.cache.CreateTokenResponse[k] = token
-
-	return kr.saveStorageData()
+	return kr.saveRecord(kr.CreateTokenResponseKey(key), token)
 }
 
 // GetCreateTokenResponse retrieves the CreateTokenResponse from the keyring
 func (kr *KeyringStore) GetCreateTokenResponse(key string, token *CreateTokenResponse) error {
-	var ok bool
 	k := kr.CreateTokenResponseKey(key)
-	if *token, ok = kr.cache.CreateTokenResponse[k]; !ok {
-		return fmt.Errorf("No CreateTokenResponse for %s", k)
-	}
-	return nil
+	return notFound(kr.getRecord(k, token), "No CreateTokenResponse for %s", k)
 }
 
 // DeleteCreateTokenResponse deletes the CreateTokenResponse from the keyring
 func (kr *KeyringStore) DeleteCreateTokenResponse(key string) error {
 	k := kr.CreateTokenResponseKey(key)
-	if _, ok := kr.cache.CreateTokenResponse[k]; !ok {
-		// return error if key doesn't exist
-		return fmt.Errorf("No CreateTokenResponse for key: %s", k)
-	}
+	return notFound(kr.deleteRecord(k), "No CreateTokenResponse for key: %s", k)
+}
 
-	delete(kr.cache.CreateTokenResponse, k)
-	return kr.saveStorageData()
+// ListCreateTokenResponse returns the keys of all the CreateTokenResponses
+func (kr *KeyringStore) ListCreateTokenResponse() []string {
+	return kr.listRecords(kr.CreateTokenResponseKey(""))
+}
+
+func (kr *KeyringStore) RoleCredentialsKey(arn string) string {
+	return fmt.Sprintf("%s:%s", ROLE_CREDENTIALS_PREFIX, arn)
 }
 
 // SaveRoleCredentials stores the token in the arnring
 func (kr *KeyringStore) SaveRoleCredentials(arn string, token RoleCredentials) error {
-	kr.cache.RoleCredentials[arn] = token
-	return kr.saveStorageData()
+	return kr.saveRecord(kr.RoleCredentialsKey(arn), token)
 }
 
 // GetRoleCredentials retrieves the RoleCredentials from the Keyring
 func (kr *KeyringStore) GetRoleCredentials(arn string, token *RoleCredentials) error {
-	var ok bool
-	if *token, ok = kr.cache.RoleCredentials[arn]; !ok {
-		return fmt.Errorf("No RoleCredentials for ARN: %s", arn)
-	}
-	return nil
+	err := kr.getRecord(kr.RoleCredentialsKey(arn), token)
+	return notFound(err, "No RoleCredentials for ARN: %s", arn)
 }
 
 // DeleteRoleCredentials deletes the RoleCredentials from the Keyring
 func (kr *KeyringStore) DeleteRoleCredentials(arn string) error {
-	if _, ok := kr.cache.RoleCredentials[arn]; !ok {
-		// return error if key doesn't exist
-		return fmt.Errorf("No RoleCredentials for ARN: %s", arn)
-	}
+	err := kr.deleteRecord(kr.RoleCredentialsKey(arn))
+	return notFound(err, "No RoleCredentials for ARN: %s", arn)
+}
+
+// ListRoleCredentials returns all the ARN's of role credentials
+func (kr *KeyringStore) ListRoleCredentials() []string {
+	return kr.listRecords(kr.RoleCredentialsKey(""))
+}
 
-	delete(kr.cache.RoleCredentials, arn)
-	return kr.saveStorageData()
+func (kr *KeyringStore) StaticCredentialsKey(arn string) string {
+	return fmt.Sprintf("%s:%s", STATIC_CREDENTIALS_PREFIX, arn)
 }
 
 // SaveStaticCredentials stores the token in the arnring
 func (kr *KeyringStore) SaveStaticCredentials(arn string, creds StaticCredentials) error {
-	kr.cache.StaticCredentials[arn] = creds
-	return kr.saveStorageData()
+	return kr.saveRecord(kr.StaticCredentialsKey(arn), creds)
 }
 
 // GetStaticCredentials retrieves the StaticCredentials from the Keyring
 func (kr *KeyringStore) GetStaticCredentials(arn string, creds *StaticCredentials) error {
-	var ok bool
-	if *creds, ok = kr.cache.StaticCredentials[arn]; !ok {
-		return fmt.Errorf("No StaticCredentials for ARN: %s", arn)
-	}
-	return nil
+	err := kr.getRecord(kr.StaticCredentialsKey(arn), creds)
+	return notFound(err, "No StaticCredentials for ARN: %s", arn)
 }
 
 // DeleteStaticCredentials deletes the StaticCredentials from the Keyring
 func (kr *KeyringStore) DeleteStaticCredentials(arn string) error {
-	if _, ok := kr.cache.StaticCredentials[arn]; !ok {
-		// return error if key doesn't exist
-		return fmt.Errorf("No StaticCredentials for ARN: %s", arn)
-	}
-
-	delete(kr.cache.StaticCredentials, arn)
-	return kr.saveStorageData()
+	err := kr.deleteRecord(kr.StaticCredentialsKey(arn))
+	return notFound(err, "No StaticCredentials for ARN: %s", arn)
 }
 
+// ListStaticCredentials returns all the ARN's of static credentials
 func (kr *KeyringStore) ListStaticCredentials() []string {
-	ret := make([]string, len(kr.cache.StaticCredentials))
-	i := 0
-	for k := range kr.cache.StaticCredentials {
-		ret[i] = k
-		i++
+	return kr.listRecords(kr.StaticCredentialsKey(""))
+}
+
+func (kr *KeyringStore) EcsSlotsKey(key string) string {
+	return fmt.Sprintf("%s:%s", ECS_SLOTS_PREFIX, key)
+}
+
+// SaveEcsSlots stores the ECS Server slots in the keyring
+func (kr *KeyringStore) SaveEcsSlots(key string, slots EcsSlots) error {
+	return kr.saveRecord(kr.EcsSlotsKey(key), slots)
+}
+
+// GetEcsSlots retrieves the ECS Server slots from the keyring
+func (kr *KeyringStore) GetEcsSlots(key string, slots *EcsSlots) error {
+	k := kr.EcsSlotsKey(key)
+	if err := kr.getRecord(k, slots); err != nil {
+		return notFound(err, "No EcsSlots for %s", k)
 	}
-	return ret
+	if slots.Slots == nil {
+		// omitted when empty
+		slots.Slots = map[string]EcsSlot{}
+	}
+	return nil
+}
+
+// DeleteEcsSlots deletes the ECS Server slots from the keyring
+func (kr *KeyringStore) DeleteEcsSlots(key string) error {
+	k := kr.EcsSlotsKey(key)
+	return notFound(kr.deleteRecord(k), "No EcsSlots for key: %s", k)
+}
+
+// ListEcsSlots returns the keys of all the ECS Server slots
+func (kr *KeyringStore) ListEcsSlots() []string {
+	return kr.listRecords(kr.EcsSlotsKey(""))
 }
@@ -32,6 +32,7 @@ import (
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
 )
 
 type KeyringSuite struct {
@@ -225,13 +226,16 @@ func TestKeyringErrors(t *testing.T) {
 	ks := &KeyringStore{
 		keyring: &mockKeyringAPI{},
 		config:  *c,
-		cache:   NewStorageData(),
+		index:   newKeyringIndex(),
 	}
 
 	err = ks.getStorageData(&StorageData{})
 	assert.NoError(t, err)
 
-	err = ks.saveStorageData()
+	err = ks.saveIndex()
+	assert.Error(t, err)
+
+	err = ks.migrateStorageData()
 	assert.Error(t, err)
 
 	// RegisterClientData
@@ -297,11 +301,38 @@ func (suite *KeyringSuite) TestStaticCredentials() {
 	assert.Error(t, suite.store.DeleteStaticCredentials(arn))
 }
 
+func (suite *KeyringSuite) TestEcsSlots() {
+	t := suite.T()
+
+	key := "unix:/tmp/ecs.sock"
+	slots := EcsSlots{
+		Default: &EcsSlot{
+			ProfileName: "foobar",
+			Creds: RoleCredentials{
+				RoleName:  "foobar",
+				AccountId: 123456789012,
+			},
+		},
+		Slots: map[string]EcsSlot{},
+	}
+	assert.Error(t, suite.store.GetEcsSlots(key, &EcsSlots{}))
+
+	assert.NoError(t, suite.store.SaveEcsSlots(key, slots))
+	slots2 := EcsSlots{}
+	assert.NoError(t, suite.store.GetEcsSlots(key, &slots2))
+	assert.Equal(t, slots, slots2)
+
+	assert.NoError(t, suite.store.DeleteEcsSlots(key))
+	assert.Error(t, suite.store.GetEcsSlots(key, &slots2))
+	assert.Error(t, suite.store.DeleteEcsSlots(key))
+}
+
 func TestNewStorageData(t *testing.T) {
 	s := NewStorageData()
 	assert.Empty(t, s.RegisterClientData)
 	assert.Empty(t, s.CreateTokenResponse)
 	assert.Empty(t, s.RoleCredentials)
+	assert.Empty(t, s.EcsSlots)
 }
 
 func TestFileKeyringPassword(t *testing.T) {
@@ -397,7 +428,7 @@ func TestKeyringSuiteFails(t *testing.T) {
 
 	kr := &KeyringStore{
 		keyring: ring,
-		cache:   NewStorageData(),
+		index:   newKeyringIndex(),
 	}
 	storageDataUnmarshal = func(s []byte, i interface{}) error {
 		return fmt.Errorf("unmarshal failure")
@@ -409,7 +440,8 @@ func TestKeyringSuiteFails(t *testing.T) {
 	err = os.WriteFile(path.Join(d, "secure", "aws-sso-cli-records"), in, 0600)
 	assert.NoError(t, err)
 
-	err = kr.getStorageData(&kr.cache)
+	data := NewStorageData()
+	err = kr.getStorageData(&data)
 	assert.Error(t, err)
 	assert.Equal(t, "unmarshal failure", err.Error())
 
@@ -499,15 +531,159 @@ func TestSplitCredentials(t *testing.T) {
 	// Replace a chunk with wrong data
 	err = store.SaveRoleCredentials("bar", largeRC)
 	assert.NoError(t, err)
-	err = store.setStorageData([]byte("hello friend"), fmt.Sprintf("%s_%d", RECORD_KEY, 1), KEYRING_ID)
+	key := store.RoleCredentialsKey("bar")
+	err = store.setStorageData([]byte("hello friend"), fmt.Sprintf("%s_%d", key, 1), KEYRING_ID)
 	assert.NoError(t, err)
-	_, err = store.joinAndGetKeyringData(RECORD_KEY)
-	assert.Error(t, err)
+	err = store.GetRoleCredentials("bar", &rc2)
+	assert.ErrorContains(t, err, "Unable to fetch")
 
-	// but OpenKeyring is fine, just returns a warning
-	_, err = OpenKeyring(c)
+	// all of the chunks are removed
+	assert.NoError(t, store.DeleteRoleCredentials("bar"))
+	for i := 0; i < 4; i++ {
+		_, err = store.getKeyringData(fmt.Sprintf("%s_%d", key, i))
+		assert.Error(t, err)
+	}
+
+	// a corrupt legacy keyring just returns a warning
+	d2, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d2)
+	c2, err := NewKeyringConfig("file", d2)
+	assert.NoError(t, err)
+	ring, err := keyring.Open(*c2)
+	assert.NoError(t, err)
+	legacy := &KeyringStore{keyring: ring}
+	assert.NoError(t, legacy.splitAndSetStorageData([]byte(largeString), RECORD_KEY, KEYRING_ID))
+	assert.NoError(t, legacy.setStorageData([]byte("hello friend"), fmt.Sprintf("%s_%d", RECORD_KEY, 1), KEYRING_ID))
+
+	_, err = OpenKeyring(c2)
 	assert.NoError(t, err)
 	assert.NotNil(t, hook.LastEntry())
 	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
 	assert.Contains(t, hook.LastEntry().Message, "Unable to fetch")
 }
+
+func TestKeyringMigration(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer func() {
+		os.RemoveAll(d)
+		os.Unsetenv(ENV_SSO_FILE_PASSWORD)
+	}()
+
+	os.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+	c, err := NewKeyringConfig("file", d)
+	assert.NoError(t, err)
+	ring, err := keyring.Open(*c)
+	assert.NoError(t, err)
+
+	// write our records in the legacy format
+	legacy := &KeyringStore{keyring: ring}
+	rc := RoleCredentials{
+		RoleName:        "MyRole",
+		AccountId:       234566767,
+		AccessKeyId:     "some not-so-secret-string",
+		SecretAccessKey: "a string we actually want to keep secret",
+		SessionToken:    "Another secret string",
+		Expiration:      time.Now().Unix(),
+	}
+	sc := StaticCredentials{
+		UserName:        "foobar",
+		AccountId:       123456789012,
+		AccessKeyId:     "not a real access key id",
+		SecretAccessKey: "not a real access key",
+	}
+	data := NewStorageData()
+	data.RegisterClientData[legacy.RegisterClientKey("foo")] = RegisterClientData{ClientId: "client"}
+	data.CreateTokenResponse[legacy.CreateTokenResponseKey("foo")] = CreateTokenResponse{AccessToken: "token"}
+	data.RoleCredentials["arn:aws:iam::234566767:role/MyRole"] = rc
+	data.StaticCredentials["arn:aws:iam::123456789012:user/foobar"] = sc
+	data.EcsSlots[legacy.EcsSlotsKey("tcp:127.0.0.1:4144")] = EcsSlots{}
+	jdata, _ := json.Marshal(data)
+	assert.NoError(t, legacy.setStorageData(jdata, RECORD_KEY, KEYRING_ID))
+
+	store, err := OpenKeyring(c)
+	assert.NoError(t, err)
+
+	// legacy records are gone
+	_, err = store.getKeyringData(RECORD_KEY)
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"foo"}, store.ListRegisterClientData())
+	assert.Equal(t, []string{"foo"}, store.ListCreateTokenResponse())
+	assert.Equal(t, []string{"arn:aws:iam::234566767:role/MyRole"}, store.ListRoleCredentials())
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:user/foobar"}, store.ListStaticCredentials())
+	assert.Equal(t, []string{"tcp:127.0.0.1:4144"}, store.ListEcsSlots())
+
+	rc2 := RoleCredentials{}
+	assert.NoError(t, store.GetRoleCredentials("arn:aws:iam::234566767:role/MyRole", &rc2))
+	assert.Equal(t, rc, rc2)
+	sc2 := StaticCredentials{}
+	assert.NoError(t, store.GetStaticCredentials("arn:aws:iam::123456789012:user/foobar", &sc2))
+	assert.Equal(t, sc, sc2)
+
+	// each record is stored under its own key
+	_, err = store.getKeyringData(store.RoleCredentialsKey("arn:aws:iam::234566767:role/MyRole"))
+	assert.NoError(t, err)
+}
+
+func TestKeyringConcurrentStores(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer func() {
+		os.RemoveAll(d)
+		os.Unsetenv(ENV_SSO_FILE_PASSWORD)
+	}()
+
+	os.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+	c, err := NewKeyringConfig("file", d)
+	assert.NoError(t, err)
+
+	// two aws-sso processes with the same keyring open
+	store1, err := OpenKeyring(c)
+	assert.NoError(t, err)
+	store2, err := OpenKeyring(c)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store1.SaveRoleCredentials("foo", RoleCredentials{RoleName: "foo"}))
+	assert.NoError(t, store2.SaveRoleCredentials("bar", RoleCredentials{RoleName: "bar"}))
+
+	// neither clobbered the other
+	rc := RoleCredentials{}
+	assert.NoError(t, store2.GetRoleCredentials("foo", &rc))
+	assert.Equal(t, "foo", rc.RoleName)
+	assert.NoError(t, store1.GetRoleCredentials("bar", &rc))
+	assert.Equal(t, "bar", rc.RoleName)
+	assert.Equal(t, []string{"bar", "foo"}, store1.ListRoleCredentials())
+
+	assert.NoError(t, store1.DeleteRoleCredentials("bar"))
+	assert.Equal(t, []string{"foo"}, store2.ListRoleCredentials())
+}
+
+func TestKeyringIndexLock(t *testing.T) {
+	// a backend without any locking of its own shared by two processes
+	ring := keyring.NewArrayKeyring([]keyring.Item{})
+	indexLock := path.Join(t.TempDir(), "secure-index")
+	store1 := &KeyringStore{keyring: ring, index: newKeyringIndex(), indexLock: indexLock}
+	store2 := &KeyringStore{keyring: ring, index: newKeyringIndex(), indexLock: indexLock}
+
+	lock, err := utils.LockFile(indexLock)
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- store1.SaveRoleCredentials("foo", RoleCredentials{RoleName: "foo"})
+	}()
+
+	select {
+	case err = <-done:
+		assert.Fail(t, "updated the index without holding the lock", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.NoError(t, lock.Unlock())
+	assert.NoError(t, <-done)
+
+	assert.NoError(t, store2.SaveRoleCredentials("bar", RoleCredentials{RoleName: "bar"}))
+	assert.Equal(t, []string{"bar", "foo"}, store1.ListRoleCredentials())
+}
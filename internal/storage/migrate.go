@@ -0,0 +1,195 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MigrateSummary is the number of records of each type copied by Migrate
+type MigrateSummary struct {
+	RegisterClientData  int
+	CreateTokenResponse int
+	RoleCredentials     int
+	StaticCredentials   int
+	EcsSlots            int
+}
+
+func (ms MigrateSummary) String() string {
+	return fmt.Sprintf("%d RegisterClientData, %d CreateTokenResponse, %d RoleCredentials, %d StaticCredentials, %d EcsSlots",
+		ms.RegisterClientData, ms.CreateTokenResponse, ms.RoleCredentials, ms.StaticCredentials, ms.EcsSlots)
+}
+
+// Migrate copies every record in one SecureStorage to another.  Existing
+// records in the destination with the same key are overwritten.
+func Migrate(from, to SecureStorage) (MigrateSummary, error) {
+	summary := MigrateSummary{}
+
+	for _, key := range from.ListRegisterClientData() {
+		data := RegisterClientData{}
+		if err := from.GetRegisterClientData(key, &data); err != nil {
+			return summary, err
+		}
+		if err := to.SaveRegisterClientData(key, data); err != nil {
+			return summary, fmt.Errorf("Unable to save RegisterClientData for %s: %s", key, err.Error())
+		}
+		summary.RegisterClientData++
+	}
+
+	for _, key := range from.ListCreateTokenResponse() {
+		token := CreateTokenResponse{}
+		if err := from.GetCreateTokenResponse(key, &token); err != nil {
+			return summary, err
+		}
+		if err := to.SaveCreateTokenResponse(key, token); err != nil {
+			return summary, fmt.Errorf("Unable to save CreateTokenResponse for %s: %s", key, err.Error())
+		}
+		summary.CreateTokenResponse++
+	}
+
+	for _, arn := range from.ListRoleCredentials() {
+		creds := RoleCredentials{}
+		if err := from.GetRoleCredentials(arn, &creds); err != nil {
+			return summary, err
+		}
+		if err := to.SaveRoleCredentials(arn, creds); err != nil {
+			return summary, fmt.Errorf("Unable to save RoleCredentials for %s: %s", arn, err.Error())
+		}
+		summary.RoleCredentials++
+	}
+
+	for _, arn := range from.ListStaticCredentials() {
+		creds := StaticCredentials{}
+		if err := from.GetStaticCredentials(arn, &creds); err != nil {
+			return summary, err
+		}
+		if err := to.SaveStaticCredentials(arn, creds); err != nil {
+			return summary, fmt.Errorf("Unable to save StaticCredentials for %s: %s", arn, err.Error())
+		}
+		summary.StaticCredentials++
+	}
+
+	for _, key := range from.ListEcsSlots() {
+		slots := EcsSlots{}
+		if err := from.GetEcsSlots(key, &slots); err != nil {
+			return summary, err
+		}
+		if err := to.SaveEcsSlots(key, slots); err != nil {
+			return summary, fmt.Errorf("Unable to save EcsSlots for %s: %s", key, err.Error())
+		}
+		summary.EcsSlots++
+	}
+
+	return summary, nil
+}
+
+// VerifyMigration returns an error if any record in one SecureStorage is
+// missing or different in the other
+func VerifyMigration(from, to SecureStorage) error {
+	for _, key := range from.ListRegisterClientData() {
+		a, b := RegisterClientData{}, RegisterClientData{}
+		if err := verifyRecord("RegisterClientData", key, &a, &b,
+			from.GetRegisterClientData(key, &a), to.GetRegisterClientData(key, &b)); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range from.ListCreateTokenResponse() {
+		a, b := CreateTokenResponse{}, CreateTokenResponse{}
+		if err := verifyRecord("CreateTokenResponse", key, &a, &b,
+			from.GetCreateTokenResponse(key, &a), to.GetCreateTokenResponse(key, &b)); err != nil {
+			return err
+		}
+	}
+
+	for _, arn := range from.ListRoleCredentials() {
+		a, b := RoleCredentials{}, RoleCredentials{}
+		if err := verifyRecord("RoleCredentials", arn, &a, &b,
+			from.GetRoleCredentials(arn, &a), to.GetRoleCredentials(arn, &b)); err != nil {
+			return err
+		}
+	}
+
+	for _, arn := range from.ListStaticCredentials() {
+		a, b := StaticCredentials{}, StaticCredentials{}
+		if err := verifyRecord("StaticCredentials", arn, &a, &b,
+			from.GetStaticCredentials(arn, &a), to.GetStaticCredentials(arn, &b)); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range from.ListEcsSlots() {
+		a, b := EcsSlots{}, EcsSlots{}
+		if err := verifyRecord("EcsSlots", key, &a, &b,
+			from.GetEcsSlots(key, &a), to.GetEcsSlots(key, &b)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyRecord compares a single record read from both SecureStorage
+func verifyRecord(recordType, key string, a, b interface{}, fromErr, toErr error) error {
+	if fromErr != nil {
+		return fmt.Errorf("Unable to read %s for %s: %s", recordType, key, fromErr.Error())
+	}
+	if toErr != nil {
+		return fmt.Errorf("Missing %s for %s: %s", recordType, key, toErr.Error())
+	}
+	// compare what is actually stored, since empty maps are omitted
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	if !bytes.Equal(ja, jb) {
+		return fmt.Errorf("%s for %s does not match", recordType, key)
+	}
+	return nil
+}
+
+// Wipe deletes every record in the SecureStorage
+func Wipe(store SecureStorage) error {
+	for _, key := range store.ListRegisterClientData() {
+		if err := store.DeleteRegisterClientData(key); err != nil {
+			return err
+		}
+	}
+	for _, key := range store.ListCreateTokenResponse() {
+		if err := store.DeleteCreateTokenResponse(key); err != nil {
+			return err
+		}
+	}
+	for _, arn := range store.ListRoleCredentials() {
+		if err := store.DeleteRoleCredentials(arn); err != nil {
+			return err
+		}
+	}
+	for _, arn := range store.ListStaticCredentials() {
+		if err := store.DeleteStaticCredentials(arn); err != nil {
+			return err
+		}
+	}
+	for _, key := range store.ListEcsSlots() {
+		if err := store.DeleteEcsSlots(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	input, err := os.ReadFile(TEST_JSON_STORE_FILE)
+	assert.NoError(t, err)
+	jsonFile := filepath.Join(dir, "store.json")
+	assert.NoError(t, os.WriteFile(jsonFile, input, 0600))
+
+	js, err := OpenJsonStore(jsonFile)
+	assert.NoError(t, err)
+	assert.NoError(t, js.SaveEcsSlots("tcp:127.0.0.1:4144", EcsSlots{
+		Default: &EcsSlot{
+			ProfileName: "foo",
+			Creds:       RoleCredentials{RoleName: "foo", AccountId: 123456789012},
+		},
+	}))
+
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+	c, err := NewKeyringConfig("file", dir)
+	assert.NoError(t, err)
+	kr, err := OpenKeyring(c)
+	assert.NoError(t, err)
+
+	summary, err := Migrate(js, kr)
+	assert.NoError(t, err)
+	assert.Equal(t, MigrateSummary{
+		RegisterClientData:  1,
+		CreateTokenResponse: 1,
+		RoleCredentials:     1,
+		StaticCredentials:   1,
+		EcsSlots:            1,
+	}, summary)
+	assert.NoError(t, VerifyMigration(js, kr))
+
+	// keyring prefixes are not part of the listed keys
+	key := "us-east-1|https://d-xxxxxxx.awsapps.com/start"
+	assert.Equal(t, []string{key}, kr.ListRegisterClientData())
+	assert.Equal(t, []string{key}, kr.ListCreateTokenResponse())
+	assert.Equal(t, []string{"tcp:127.0.0.1:4144"}, kr.ListEcsSlots())
+	assert.Equal(t, []string{"arn:aws:iam::012344553243:role/AWSAdministratorAccess"}, kr.ListRoleCredentials())
+
+	// records are persisted
+	kr, err = OpenKeyring(c)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyMigration(js, kr))
+
+	// differences are detected
+	assert.NoError(t, kr.SaveRoleCredentials("arn:aws:iam::012344553243:role/AWSAdministratorAccess", RoleCredentials{}))
+	assert.ErrorContains(t, VerifyMigration(js, kr), "does not match")
+	assert.NoError(t, kr.DeleteRoleCredentials("arn:aws:iam::012344553243:role/AWSAdministratorAccess"))
+	assert.ErrorContains(t, VerifyMigration(js, kr), "Missing RoleCredentials")
+
+	assert.NoError(t, Wipe(js))
+	js, err = OpenJsonStore(jsonFile)
+	assert.NoError(t, err)
+	assert.Empty(t, js.ListRegisterClientData())
+	assert.Empty(t, js.ListCreateTokenResponse())
+	assert.Empty(t, js.ListRoleCredentials())
+	assert.Empty(t, js.ListStaticCredentials())
+	assert.Empty(t, js.ListEcsSlots())
+}
@@ -18,24 +18,51 @@ package storage
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
+import (
+	"sort"
+	"strings"
+)
+
 // Define the interface for storing our AWS SSO data
 type SecureStorage interface {
 	SaveRegisterClientData(string, RegisterClientData) error
 	GetRegisterClientData(string, *RegisterClientData) error
 	DeleteRegisterClientData(string) error
+	ListRegisterClientData() []string
 
 	SaveCreateTokenResponse(string, CreateTokenResponse) error
 	GetCreateTokenResponse(string, *CreateTokenResponse) error
 	DeleteCreateTokenResponse(string) error
+	ListCreateTokenResponse() []string
 
 	// Temporary STS creds
 	SaveRoleCredentials(string, RoleCredentials) error
 	GetRoleCredentials(string, *RoleCredentials) error
 	DeleteRoleCredentials(string) error
+	ListRoleCredentials() []string
 
 	// Static API creds
 	SaveStaticCredentials(string, StaticCredentials) error
 	GetStaticCredentials(string, *StaticCredentials) error
 	DeleteStaticCredentials(string) error
 	ListStaticCredentials() []string
+
+	// ECS Server slots
+	SaveEcsSlots(string, EcsSlots) error
+	GetEcsSlots(string, *EcsSlots) error
+	DeleteEcsSlots(string) error
+	ListEcsSlots() []string
+}
+
+// listKeys returns the sorted keys of m which start with prefix, with the
+// prefix removed
+func listKeys[T any](m map[string]T, prefix string) []string {
+	ret := []string{}
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			ret = append(ret, strings.TrimPrefix(k, prefix))
+		}
+	}
+	sort.Strings(ret)
+	return ret
 }
@@ -0,0 +1,197 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+const (
+	ENV_SSO_FILE_SESSION         = "AWS_SSO_FILE_SESSION"
+	SESSION_FILE_SUFFIX          = ".session"
+	SESSION_KEY_BYTES            = 32
+	DEFAULT_SESSION_IDLE_TIMEOUT = 60 * time.Minute
+)
+
+var errNoSession = errors.New("No SecureStore session")
+
+// keyringSession is an unlocked file keyring.  The password is encrypted
+// with a random session key which is only stored in $AWS_SSO_FILE_SESSION
+// so neither the session file nor the environment variable reveals the
+// password by itself.
+type keyringSession struct {
+	IdleTimeout int64  `json:"IdleTimeout"` // seconds
+	LastUsed    int64  `json:"LastUsed"`
+	Nonce       []byte `json:"Nonce"`
+	Ciphertext  []byte `json:"Ciphertext"`
+}
+
+// expired returns true if the session has not been used within its IdleTimeout
+func (s *keyringSession) expired() bool {
+	idle := time.Since(time.Unix(s.LastUsed, 0))
+	return idle > time.Duration(s.IdleTimeout)*time.Second
+}
+
+// sessionFileName returns the session file for the file keyring in fileDir
+func sessionFileName(fileDir string) string {
+	return filepath.Clean(utils.GetHomePath(fileDir)) + SESSION_FILE_SUFFIX
+}
+
+// sessionPasswordFunc wraps the PromptFunc so that the password from an
+// unlocked session is used before prompting the user
+func sessionPasswordFunc(fileDir string, fn keyring.PromptFunc) keyring.PromptFunc {
+	return func(prompt string) (string, error) {
+		password, err := sessionPassword(fileDir)
+		if err == nil {
+			return password, nil
+		} else if !errors.Is(err, errNoSession) {
+			log.Warn(err.Error())
+		}
+		return fn(prompt)
+	}
+}
+
+// sessionPassword returns the password for the file keyring if it has been
+// unlocked via UnlockFileKeyring and resets the idle timer
+func sessionPassword(fileDir string) (string, error) {
+	encoded := os.Getenv(ENV_SSO_FILE_SESSION)
+	if encoded == "" {
+		return "", errNoSession
+	}
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(key) != SESSION_KEY_BYTES {
+		return "", fmt.Errorf("Invalid $%s", ENV_SSO_FILE_SESSION)
+	}
+
+	fileName := sessionFileName(fileDir)
+	var password string
+	err = utils.WithFileLock(fileName, true, func() error {
+		data, err := os.ReadFile(fileName)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("SecureStore is locked.  Run `aws-sso store unlock` to unlock it")
+		} else if err != nil {
+			return err
+		}
+
+		session := keyringSession{}
+		if err = json.Unmarshal(data, &session); err != nil {
+			return fmt.Errorf("Unable to parse %s: %s", fileName, err.Error())
+		}
+		if session.expired() {
+			if err = os.Remove(fileName); err != nil {
+				log.WithError(err).Warnf("Unable to remove %s", fileName)
+			}
+			return fmt.Errorf("SecureStore session has expired.  Run `aws-sso store unlock` to unlock it")
+		}
+
+		gcm, err := sessionCipher(key)
+		if err != nil {
+			return err
+		}
+		plaintext, err := gcm.Open(nil, session.Nonce, session.Ciphertext, nil)
+		if err != nil {
+			return fmt.Errorf("$%s does not match the unlocked SecureStore session", ENV_SSO_FILE_SESSION)
+		}
+		password = string(plaintext)
+
+		session.LastUsed = time.Now().Unix()
+		if data, err = json.Marshal(session); err != nil {
+			return err
+		}
+		return utils.AtomicWriteFile(fileName, data, 0600)
+	})
+	return password, err
+}
+
+// UnlockFileKeyring prompts for the password of the file keyring and returns
+// the session key to set as $AWS_SSO_FILE_SESSION.  The session is locked
+// once it has not been used for idleTimeout.
+func UnlockFileKeyring(cfg *keyring.Config, idleTimeout time.Duration) (string, error) {
+	if !isFileBackend(cfg) {
+		return "", fmt.Errorf("Only the file SecureStore can be unlocked")
+	}
+	password, err := getPasswordFunc("Enter password")
+	if err != nil {
+		return "", fmt.Errorf("Password error: %s", err.Error())
+	}
+	if err = verifyFilePassword(cfg.FileDir, password); err != nil {
+		return "", err
+	}
+
+	key := make([]byte, SESSION_KEY_BYTES)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	gcm, err := sessionCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	session := keyringSession{
+		IdleTimeout: int64(idleTimeout.Seconds()),
+		LastUsed:    time.Now().Unix(),
+		Nonce:       make([]byte, gcm.NonceSize()),
+	}
+	if _, err = io.ReadFull(rand.Reader, session.Nonce); err != nil {
+		return "", err
+	}
+	session.Ciphertext = gcm.Seal(nil, session.Nonce, []byte(password), nil)
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	if err = utils.WriteLockedFile(sessionFileName(cfg.FileDir), data, 0600); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// LockFileKeyring ends the session created by UnlockFileKeyring
+func LockFileKeyring(cfg *keyring.Config) error {
+	fileName := sessionFileName(cfg.FileDir)
+	return utils.WithFileLock(fileName, true, func() error {
+		if err := os.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+// sessionCipher returns the AES-256-GCM cipher for our session key
+func sessionCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,117 @@
+package storage
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringSession(t *testing.T) {
+	d, err := os.MkdirTemp("", "test-keyring")
+	assert.NoError(t, err)
+	defer os.RemoveAll(d)
+
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+	c, err := NewKeyringConfig("file", d)
+	assert.NoError(t, err)
+	store, err := OpenKeyring(c)
+	assert.NoError(t, err)
+	arn := "arn:aws:iam::123456789012:role/Foo"
+	assert.NoError(t, store.SaveRoleCredentials(arn, RoleCredentials{RoleName: "Foo"}))
+
+	session, err := UnlockFileKeyring(c, time.Minute)
+	assert.NoError(t, err)
+	info, err := os.Stat(sessionFileName(c.FileDir))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the session is used instead of prompting the user
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "")
+	defer func(p string) {
+		promptPasswordFunc = promptPassword
+		NewPassword = p
+	}(NewPassword)
+	NewPassword = ""
+	promptPasswordFunc = func(string) (string, error) {
+		return "", fmt.Errorf("unexpected prompt")
+	}
+	t.Setenv(ENV_SSO_FILE_SESSION, session)
+	store, err = OpenKeyring(c)
+	assert.NoError(t, err)
+	rc := RoleCredentials{}
+	assert.NoError(t, store.GetRoleCredentials(arn, &rc))
+	assert.Equal(t, "Foo", rc.RoleName)
+
+	// the session file does not contain the password
+	data, err := os.ReadFile(sessionFileName(c.FileDir))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "justapassword")
+
+	// the session key must match
+	t.Setenv(ENV_SSO_FILE_SESSION, base64.RawURLEncoding.EncodeToString(make([]byte, SESSION_KEY_BYTES)))
+	_, err = sessionPassword(c.FileDir)
+	assert.ErrorContains(t, err, "does not match")
+	t.Setenv(ENV_SSO_FILE_SESSION, "invalid")
+	_, err = sessionPassword(c.FileDir)
+	assert.ErrorContains(t, err, "Invalid")
+	_, err = OpenKeyring(c)
+	assert.ErrorContains(t, err, "unexpected prompt")
+
+	// idle sessions expire
+	t.Setenv(ENV_SSO_FILE_SESSION, session)
+	s := keyringSession{}
+	assert.NoError(t, json.Unmarshal(data, &s))
+	s.LastUsed = time.Now().Add(-2 * time.Minute).Unix()
+	data, err = json.Marshal(s)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(sessionFileName(c.FileDir), data, 0600))
+	_, err = sessionPassword(c.FileDir)
+	assert.ErrorContains(t, err, "expired")
+	_, err = os.Stat(sessionFileName(c.FileDir))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// and can be locked
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "justapassword")
+	session, err = UnlockFileKeyring(c, time.Minute)
+	assert.NoError(t, err)
+	t.Setenv(ENV_SSO_FILE_SESSION, session)
+	p, err := sessionPassword(c.FileDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "justapassword", p)
+	assert.NoError(t, LockFileKeyring(c))
+	assert.NoError(t, LockFileKeyring(c))
+	_, err = sessionPassword(c.FileDir)
+	assert.ErrorContains(t, err, "locked")
+
+	// wrong password
+	t.Setenv(ENV_SSO_FILE_PASSWORD, "wrong password")
+	_, err = UnlockFileKeyring(c, time.Minute)
+	assert.ErrorContains(t, err, "invalid password")
+
+	_, err = UnlockFileKeyring(&keyring.Config{}, time.Minute)
+	assert.ErrorContains(t, err, "Only the file SecureStore")
+}
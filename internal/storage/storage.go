@@ -28,12 +28,14 @@ import (
 
 // this struct should be cached for long term if possible
 type RegisterClientData struct {
-	AuthorizationEndpoint string `json:"authorizationEndpoint,omitempty"`
-	ClientId              string `json:"clientId"`
-	ClientIdIssuedAt      int64  `json:"clientIdIssuedAt"`
-	ClientSecret          string `json:"clientSecret"`
-	ClientSecretExpiresAt int64  `json:"clientSecretExpiresAt"`
-	TokenEndpoint         string `json:"tokenEndpoint,omitempty"`
+	AuthorizationEndpoint string   `json:"authorizationEndpoint,omitempty"`
+	ClientId              string   `json:"clientId"`
+	ClientIdIssuedAt      int64    `json:"clientIdIssuedAt"`
+	ClientSecret          string   `json:"clientSecret"`
+	ClientSecretExpiresAt int64    `json:"clientSecretExpiresAt"`
+	TokenEndpoint         string   `json:"tokenEndpoint,omitempty"`
+	GrantTypes            []string `json:"grantTypes,omitempty"`
+	Scopes                []string `json:"scopes,omitempty"`
 }
 
 // Expired returns true if it has expired or will in the next hour
@@ -42,6 +44,37 @@ func (r *RegisterClientData) Expired() bool {
 	return r.ClientSecretExpiresAt <= time.Now().Add(time.Hour).Unix()
 }
 
+// HasGrantType returns true if the client was registered with the given grant type
+func (r *RegisterClientData) HasGrantType(grantType string) bool {
+	for _, g := range r.GrantTypes {
+		if g == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope returns true if the client was registered with the given scope
+func (r *RegisterClientData) HasScope(scope string) bool {
+	for _, s := range r.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// EcsSlots is the persisted state of the credentials loaded in the ECS Server
+type EcsSlots struct {
+	Default *EcsSlot           `json:"Default,omitempty"`
+	Slots   map[string]EcsSlot `json:"Slots,omitempty"` // ProfileName = key
+}
+
+type EcsSlot struct {
+	ProfileName string          `json:"ProfileName"`
+	Creds       RoleCredentials `json:"Creds"`
+}
+
 type StartDeviceAuthData struct {
 	DeviceCode              string `json:"deviceCode"`
 	UserCode                string `json:"userCode"`
@@ -56,7 +89,7 @@ type CreateTokenResponse struct {
 	ExpiresIn    int32  `json:"expiresIn"`   // number of seconds it expires in (from AWS)
 	ExpiresAt    int64  `json:"expiresAt"`   // Unix time when it expires
 	IdToken      string `json:"IdToken"`
-	RefreshToken string `json:"RefreshToken"`
+	RefreshToken string `json:"RefreshToken"` // used to silently get a new AccessToken
 	TokenType    string `json:"tokenType"`
 }
 
@@ -111,12 +144,29 @@ func (r *RoleCredentials) AccountIdStr() string {
 }
 
 type StaticCredentials struct { // Cache and storage
-	Profile         string            `json:"Profile" header:"Profile"`
-	UserName        string            `json:"userName" header:"UserName"`
-	AccountId       int64             `json:"accountId" header:"AccountId"`
-	AccessKeyId     string            `json:"accessKeyId"`
-	SecretAccessKey string            `json:"secretAccessKey"`
-	Tags            map[string]string `json:"Tags" header:"Tags"`
+	Profile         string              `json:"Profile" header:"Profile"`
+	UserName        string              `json:"userName" header:"UserName"`
+	AccountId       int64               `json:"accountId" header:"AccountId"`
+	AccessKeyId     string              `json:"accessKeyId"`
+	SecretAccessKey string              `json:"secretAccessKey"`
+	Tags            map[string]string   `json:"Tags" header:"Tags"`
+	MfaSerial       string              `json:"mfaSerial,omitempty" header:"MfaSerial"`
+	Session         *SessionCredentials `json:"session,omitempty"` // cached sts:GetSessionToken
+}
+
+// SessionCredentials are the temporary credentials returned by
+// sts:GetSessionToken for static credentials
+type SessionCredentials struct {
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+	Expiration      int64  `json:"expiration"` // not in seconds, but millisec
+}
+
+// Expired returns if these session creds have expired or will expire in the next minute
+func (sc *SessionCredentials) Expired() bool {
+	now := time.Now().Add(time.Minute).UnixMilli() // yes, millisec
+	return sc.Expiration <= now
 }
 
 // GetHeader is required for GenerateTable()
@@ -76,6 +76,17 @@ func TestRoleCredentialsExpired(t *testing.T) {
 	assert.False(t, x.Expired())
 }
 
+func TestSessionCredentialsExpired(t *testing.T) {
+	x := SessionCredentials{}
+	assert.True(t, x.Expired())
+
+	x.Expiration = time.Now().UnixMilli() + 30*1000
+	assert.True(t, x.Expired())
+
+	x.Expiration = time.Now().UnixMilli() + 60*1000 + 1000
+	assert.False(t, x.Expired())
+}
+
 func TestRoleArn(t *testing.T) {
 	x := &RoleCredentials{
 		AccountId: 12344553243,
@@ -0,0 +1,142 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	LOCK_FILE_SUFFIX   = ".lock"
+	FILE_LOCK_TIMEOUT  = 30 * time.Second
+	FILE_LOCK_INTERVAL = 10 * time.Millisecond
+	READ_RETRIES       = 5
+	READ_RETRY_DELAY   = 50 * time.Millisecond
+)
+
+// FileLock is an advisory lock used to serialize access to a file across
+// processes.  The lock is held on a separate <file>.lock file since our
+// files are replaced via rename.
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile takes an exclusive lock for writing fileName
+func LockFile(fileName string) (*FileLock, error) {
+	return lockFile(fileName, true, FILE_LOCK_TIMEOUT)
+}
+
+// LockFileTimeout takes an exclusive lock on fileName, waiting up to timeout
+// for another process to release it
+func LockFileTimeout(fileName string, timeout time.Duration) (*FileLock, error) {
+	return lockFile(fileName, true, timeout)
+}
+
+// RLockFile takes a shared lock for reading fileName
+func RLockFile(fileName string) (*FileLock, error) {
+	return lockFile(fileName, false, FILE_LOCK_TIMEOUT)
+}
+
+func lockFile(fileName string, exclusive bool, timeout time.Duration) (*FileLock, error) {
+	if err := EnsureDirExists(fileName); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(fileName+LOCK_FILE_SUFFIX, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Unable to lock %s: %s", fileName, err.Error())
+		}
+		if locked {
+			return &FileLock{file: f}, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("Timed out waiting for lock on %s", fileName)
+		}
+		time.Sleep(FILE_LOCK_INTERVAL)
+	}
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	err := unlock(l.file)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WithFileLock calls fn while holding a shared or exclusive lock on fileName
+func WithFileLock(fileName string, exclusive bool, fn func() error) error {
+	lock, err := lockFile(fileName, exclusive, FILE_LOCK_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return fn()
+}
+
+// WriteLockedFile atomically writes fileName while holding an exclusive lock
+func WriteLockedFile(fileName string, data []byte, perm os.FileMode) error {
+	return WithFileLock(fileName, true, func() error {
+		return AtomicWriteFile(fileName, data, perm)
+	})
+}
+
+// ReadLockedFile reads fileName while holding a shared lock and passes the
+// contents to parse.  Since older versions did not lock or atomically write
+// their files, we retry if parse fails in case the file was being written.
+func ReadLockedFile(fileName string, parse func([]byte) error) error {
+	var err error
+	for i := 0; i < READ_RETRIES; i++ {
+		if i > 0 {
+			log.WithError(err).Debugf("Retrying read of %s", fileName)
+			time.Sleep(READ_RETRY_DELAY)
+		}
+
+		var data []byte
+		err = WithFileLock(fileName, false, func() error {
+			var rerr error
+			data, rerr = os.ReadFile(fileName)
+			return rerr
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			return err
+		} else if err != nil {
+			continue
+		}
+
+		if err = parse(data); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package utils
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockedFileConcurrency(t *testing.T) {
+	dir, err := os.MkdirTemp("", "flock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "cache.json")
+
+	// large enough that a non-atomic write could be read half written
+	data := map[string]string{}
+	for i := 0; i < 5000; i++ {
+		data[fmt.Sprintf("key-%d", i)] = "Lorem ipsum dolor sit amet, consectetur adipiscing elit"
+	}
+	jbytes, err := json.Marshal(data)
+	assert.NoError(t, err)
+	assert.NoError(t, WriteLockedFile(fileName, jbytes, 0600))
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- WriteLockedFile(fileName, jbytes, 0600)
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- ReadLockedFile(fileName, func(b []byte) error {
+				read := map[string]string{}
+				return json.Unmarshal(b, &read)
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// only our file and the lock file remain
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestReadLockedFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "flock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "cache.json")
+
+	err = ReadLockedFile(fileName, func(b []byte) error { return nil })
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.NoError(t, os.WriteFile(fileName, []byte("{}"), 0600))
+
+	// parse failures are retried
+	count := 0
+	err = ReadLockedFile(fileName, func(b []byte) error {
+		count++
+		if count < 3 {
+			return fmt.Errorf("truncated")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// but not forever
+	count = 0
+	err = ReadLockedFile(fileName, func(b []byte) error {
+		count++
+		return fmt.Errorf("invalid")
+	})
+	assert.ErrorContains(t, err, "invalid")
+	assert.Equal(t, READ_RETRIES, count)
+}
+
+func TestFileLock(t *testing.T) {
+	dir, err := os.MkdirTemp("", "flock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "store.json")
+
+	// shared locks don't block each other
+	r1, err := RLockFile(fileName)
+	assert.NoError(t, err)
+	r2, err := RLockFile(fileName)
+	assert.NoError(t, err)
+
+	f, err := os.OpenFile(fileName+LOCK_FILE_SUFFIX, os.O_RDWR, 0600)
+	assert.NoError(t, err)
+	defer f.Close()
+	locked, err := tryLock(f, true)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	assert.NoError(t, r1.Unlock())
+	assert.NoError(t, r2.Unlock())
+
+	w, err := LockFile(fileName)
+	assert.NoError(t, err)
+	locked, err = tryLock(f, false)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	assert.NoError(t, w.Unlock())
+
+	locked, err = tryLock(f, true)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.NoError(t, unlock(f))
+}
@@ -85,6 +85,12 @@ func ParseUserARN(arn string) (int64, string, error) {
 	return ParseRoleARN(arn)
 }
 
+// IsUserARN returns true if the ARN is for an IAM user instead of a role
+func IsUserARN(arn string) bool {
+	s := strings.Split(arn, ":")
+	return len(s) == 6 && strings.HasPrefix(s[5], "user/")
+}
+
 // MakeRoleARN create an IAM Role ARN using an int64 for the account
 func MakeRoleARN(account int64, name string) string {
 	a, err := AccountIdToString(account)
@@ -137,6 +143,38 @@ func EnsureDirExists(filename string) error {
 	return nil
 }
 
+// AtomicWriteFile writes data to a temporary file in the same directory
+// as filename and then renames it so readers never see a partial file
+func AtomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+	if err := EnsureDirExists(filename); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
 // ParseTimeString converts a standard time string to Unix Epoch
 func ParseTimeString(t string) (int64, error) {
 	i, err := time.Parse("2006-01-02 15:04:05 -0700 MST", t)
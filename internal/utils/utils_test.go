@@ -82,6 +82,15 @@ func (suite *UtilsTestSuite) TestParseRoleARN() {
 	assert.Equal(t, "Foo", r)
 }
 
+func (suite *UtilsTestSuite) TestIsUserARN() {
+	t := suite.T()
+
+	assert.True(t, IsUserARN("arn:aws:iam::000000011111:user/Foo"))
+	assert.False(t, IsUserARN("arn:aws:iam::000000011111:role/Foo"))
+	assert.False(t, IsUserARN("000000011111:Foo"))
+	assert.False(t, IsUserARN(""))
+}
+
 func (suite *UtilsTestSuite) TestMakeRoleARN() {
 	t := suite.T()
 
@@ -131,6 +140,31 @@ func (suite *UtilsTestSuite) TestEnsureDirExists() {
 	assert.Error(t, EnsureDirExists("/foo/bar"))
 }
 
+func (suite *UtilsTestSuite) TestAtomicWriteFile() {
+	t := suite.T()
+
+	dir, err := os.MkdirTemp("", "atomic")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "sub", "store.json")
+
+	assert.NoError(t, AtomicWriteFile(fileName, []byte("first"), 0600))
+	assert.NoError(t, AtomicWriteFile(fileName, []byte("second"), 0600))
+
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	info, err := os.Stat(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// no temp files are left behind
+	files, err := os.ReadDir(filepath.Dir(fileName))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
 func (suite *UtilsTestSuite) TestGetHomePath() {
 	t := suite.T()
 
@@ -0,0 +1,229 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+// limits of sts:AssumeRole
+const (
+	MIN_ASSUME_ROLE_DURATION  = 15  // minutes
+	MAX_ROLE_CHAIN_DURATION   = 60  // minutes
+	MAX_ASSUME_ROLE_DURATION  = 720 // minutes, for a Via of an IAM user
+	MAX_SESSION_TAGS          = 50
+	MAX_SESSION_TAG_KEY_LEN   = 128
+	MAX_SESSION_TAG_VALUE_LEN = 256
+	MAX_SESSION_POLICY_LEN    = 2048
+	MAX_SESSION_POLICY_ARNS   = 10
+)
+
+var policyArnRegexp = regexp.MustCompile(`^arn:[a-z-]+:iam::(\d{12}|aws):policy/.+$`)
+
+// hasAssumeRoleOptions returns true if any of our sts:AssumeRole options are set
+func (r *SSORole) hasAssumeRoleOptions() bool {
+	return r.Duration != 0 || len(r.SessionTags) > 0 || len(r.TransitiveTagKeys) > 0 ||
+		r.Policy != "" || len(r.PolicyArns) > 0 || r.MfaSerial != ""
+}
+
+// validateAssumeRole returns an error if our sts:AssumeRole options are invalid
+func (r *SSORole) validateAssumeRole() error {
+	if r.Via == "" {
+		if r.hasAssumeRoleOptions() {
+			return fmt.Errorf("Duration, SessionTags, TransitiveTagKeys, Policy, PolicyArns and MfaSerial require Via")
+		}
+		return nil
+	}
+
+	// role chaining is limited to one hour, but not roles assumed by an IAM user
+	maxDuration := int32(MAX_ROLE_CHAIN_DURATION)
+	if utils.IsUserARN(r.Via) {
+		maxDuration = MAX_ASSUME_ROLE_DURATION
+	}
+	if r.Duration != 0 && (r.Duration < MIN_ASSUME_ROLE_DURATION || r.Duration > maxDuration) {
+		return fmt.Errorf("Duration must be between %d and %d minutes",
+			MIN_ASSUME_ROLE_DURATION, maxDuration)
+	}
+
+	if len(r.SessionTags) > MAX_SESSION_TAGS {
+		return fmt.Errorf("No more than %d SessionTags are allowed", MAX_SESSION_TAGS)
+	}
+	for key, value := range r.SessionTags {
+		if len(key) > MAX_SESSION_TAG_KEY_LEN {
+			return fmt.Errorf("SessionTags key %s is longer than %d characters", key, MAX_SESSION_TAG_KEY_LEN)
+		}
+		if _, err := template.New(key).Funcs(templateFuncMap()).Parse(value); err != nil {
+			return fmt.Errorf("Invalid SessionTags value for %s: %s", key, err.Error())
+		}
+	}
+	for _, key := range r.TransitiveTagKeys {
+		if _, ok := r.SessionTags[key]; !ok {
+			return fmt.Errorf("TransitiveTagKeys %s is not in SessionTags", key)
+		}
+	}
+
+	if r.Policy != "" {
+		if !json.Valid([]byte(r.Policy)) {
+			return fmt.Errorf("Policy is not valid JSON")
+		}
+		if len(r.Policy) > MAX_SESSION_POLICY_LEN {
+			return fmt.Errorf("Policy is longer than %d characters", MAX_SESSION_POLICY_LEN)
+		}
+	}
+
+	if len(r.PolicyArns) > MAX_SESSION_POLICY_ARNS {
+		return fmt.Errorf("No more than %d PolicyArns are allowed", MAX_SESSION_POLICY_ARNS)
+	}
+	for _, arn := range r.PolicyArns {
+		if !policyArnRegexp.MatchString(arn) {
+			return fmt.Errorf("Invalid PolicyArns %s", arn)
+		}
+	}
+
+	if r.MfaSerial != "" {
+		return ValidateMfaSerial(r.MfaSerial)
+	}
+	return nil
+}
+
+// sessionTags returns our SessionTags with the values generated from their
+// templates using the given role
+func (r *SSORole) sessionTags(role *AWSRoleFlat) ([]ststypes.Tag, error) {
+	keys := make([]string, 0, len(r.SessionTags))
+	for key := range r.SessionTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := []ststypes.Tag{}
+	for _, key := range keys {
+		// a missing role tag must not become "<no value>"
+		templ, err := template.New(key).Funcs(templateFuncMap()).Option("missingkey=error").
+			Parse(r.SessionTags[key])
+		if err != nil {
+			return tags, fmt.Errorf("Invalid SessionTags value for %s: %s", key, err.Error())
+		}
+
+		buf := new(bytes.Buffer)
+		if err = templ.Execute(buf, role); err != nil {
+			return tags, fmt.Errorf("Unable to generate SessionTags value for %s: %s", key, err.Error())
+		}
+		if buf.Len() > MAX_SESSION_TAG_VALUE_LEN {
+			return tags, fmt.Errorf("SessionTags value for %s is longer than %d characters",
+				key, MAX_SESSION_TAG_VALUE_LEN)
+		}
+
+		tags = append(tags, ststypes.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(buf.String()),
+		})
+	}
+	return tags, nil
+}
+
+// assumeRoleInput returns the sts:AssumeRole request for this role.  The
+// role is used to generate our SessionTags.
+func (r *SSORole) assumeRoleInput(roleArn, sessionName string, role *AWSRoleFlat) (*sts.AssumeRoleInput, error) {
+	input := sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleArn),
+		RoleSessionName: aws.String(sessionName),
+	}
+	if r.ExternalId != "" {
+		// Optional vlaue: https://docs.aws.amazon.com/sdk-for-go/api/service/sts/#AssumeRoleInput
+		input.ExternalId = aws.String(r.ExternalId)
+	}
+	if r.SourceIdentity != "" {
+		input.SourceIdentity = aws.String(r.SourceIdentity)
+	}
+	if r.Duration > 0 {
+		input.DurationSeconds = aws.Int32(r.Duration * 60)
+	}
+
+	if len(r.SessionTags) > 0 {
+		tags, err := r.sessionTags(role)
+		if err != nil {
+			return nil, err
+		}
+		input.Tags = tags
+		input.TransitiveTagKeys = r.TransitiveTagKeys
+	}
+
+	if r.Policy != "" {
+		input.Policy = aws.String(r.Policy)
+	}
+	for _, arn := range r.PolicyArns {
+		input.PolicyArns = append(input.PolicyArns, ststypes.PolicyDescriptorType{
+			Arn: aws.String(arn),
+		})
+	}
+	return &input, nil
+}
+
+// templateRole returns the role used as the data of our SessionTags and
+// RoleSessionNameFormat templates.  Uses our cache if possible for the tags
+// AWS SSO knows about.
+func (as *AWSSSO) templateRole(accountId int64, roleName string, configRole *SSORole) *AWSRoleFlat {
+	if s := as.SSOConfig.settings; s != nil && s.Cache != nil {
+		if cache, ok := s.Cache.SSO[as.key]; ok {
+			if role, err := cache.Roles.GetRole(accountId, roleName); err == nil {
+				return role
+			}
+		}
+	}
+
+	// fall back to what is in our config
+	idStr, _ := utils.AccountIdToString(accountId)
+	role := &AWSRoleFlat{
+		AccountId:    accountId,
+		AccountIdPad: idStr,
+		Arn:          utils.MakeRoleARN(accountId, roleName),
+		RoleName:     roleName,
+		SSO:          as.key,
+		SSORegion:    as.SsoRegion,
+		StartUrl:     as.StartUrl,
+		Via:          configRole.Via,
+		Tags:         map[string]string{},
+	}
+	for k, v := range configRole.Tags {
+		role.Tags[k] = v
+	}
+	if configRole.account != nil {
+		role.AccountName = configRole.account.Name
+		role.DefaultRegion = configRole.account.DefaultRegion
+		for k, v := range configRole.account.Tags {
+			if _, ok := role.Tags[k]; !ok {
+				role.Tags[k] = v
+			}
+		}
+	}
+	if configRole.DefaultRegion != "" {
+		role.DefaultRegion = configRole.DefaultRegion
+	}
+	return role
+}
@@ -0,0 +1,148 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/fakeaws"
+	"github.com/synfinatic/aws-sso-cli/internal/url"
+)
+
+func TestValidateAssumeRole(t *testing.T) {
+	via := "arn:aws:iam::000000000001:role/Admin"
+	tests := []struct {
+		Role  *SSORole
+		Error string
+	}{
+		{&SSORole{}, ""},
+		{&SSORole{Via: via}, ""},
+		{&SSORole{
+			Via:               via,
+			Duration:          60,
+			SessionTags:       map[string]string{"Team": "{{ .Tags.Team }}", "Project": "aws-sso"},
+			TransitiveTagKeys: []string{"Team"},
+			Policy:            `{"Version":"2012-10-17","Statement":[]}`,
+			PolicyArns:        []string{"arn:aws:iam::aws:policy/ReadOnlyAccess", "arn:aws-us-gov:iam::000000000001:policy/Foo"},
+		}, ""},
+		{&SSORole{Duration: 30}, "require Via"},
+		{&SSORole{Policy: "{}"}, "require Via"},
+		{&SSORole{Via: via, Duration: 10}, "Duration must be between"},
+		{&SSORole{Via: via, Duration: 61}, "Duration must be between 15 and 60"},
+		{&SSORole{Via: "arn:aws:iam::000000000001:user/tester", Duration: 720}, ""},
+		{&SSORole{Via: "arn:aws:iam::000000000001:user/tester", Duration: 721}, "Duration must be between 15 and 720"},
+		{&SSORole{Via: via, SessionTags: map[string]string{"Team": "{{ .Tags.Team"}}, "Invalid SessionTags value for Team"},
+		{&SSORole{Via: via, SessionTags: map[string]string{"Team": "a"}, TransitiveTagKeys: []string{"Project"}}, "Project is not in SessionTags"},
+		{&SSORole{Via: via, Policy: `{"Version":`}, "not valid JSON"},
+		{&SSORole{Via: via, PolicyArns: []string{"ReadOnlyAccess"}}, "Invalid PolicyArns"},
+		{&SSORole{Via: via, PolicyArns: []string{"arn:aws:iam::000000000001:role/Admin"}}, "Invalid PolicyArns"},
+		{&SSORole{Via: via, MfaSerial: "arn:aws:iam::000000000001:mfa/tester"}, ""},
+		{&SSORole{MfaSerial: "arn:aws:iam::000000000001:mfa/tester"}, "require Via"},
+		{&SSORole{Via: via, MfaSerial: "bad serial"}, "Invalid MfaSerial"},
+	}
+	for _, test := range tests {
+		err := test.Role.validateAssumeRole()
+		if test.Error == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, test.Error)
+		}
+	}
+}
+
+func TestAssumeRoleInput(t *testing.T) {
+	r := &SSORole{
+		Via:               "arn:aws:iam::000000000001:role/Admin",
+		ExternalId:        "external",
+		Duration:          30,
+		SessionTags:       map[string]string{"Team": "{{ .Tags.Team }}", "Account": "{{ .AccountName }}"},
+		TransitiveTagKeys: []string{"Team"},
+		Policy:            `{"Version":"2012-10-17","Statement":[]}`,
+		PolicyArns:        []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+	}
+	role := &AWSRoleFlat{
+		AccountName: "Prod",
+		Tags:        map[string]string{"Team": "platform"},
+	}
+
+	input, err := r.assumeRoleInput("arn:aws:iam::000000000002:role/Chained", "Admin@000000000001", role)
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::000000000002:role/Chained", aws.ToString(input.RoleArn))
+	assert.Equal(t, "Admin@000000000001", aws.ToString(input.RoleSessionName))
+	assert.Equal(t, "external", aws.ToString(input.ExternalId))
+	assert.Nil(t, input.SourceIdentity)
+	assert.Equal(t, int32(1800), aws.ToInt32(input.DurationSeconds))
+	assert.Equal(t, []ststypes.Tag{
+		{Key: aws.String("Account"), Value: aws.String("Prod")},
+		{Key: aws.String("Team"), Value: aws.String("platform")},
+	}, input.Tags)
+	assert.Equal(t, []string{"Team"}, input.TransitiveTagKeys)
+	assert.Equal(t, r.Policy, aws.ToString(input.Policy))
+	assert.Equal(t, []ststypes.PolicyDescriptorType{
+		{Arn: aws.String("arn:aws:iam::aws:policy/ReadOnlyAccess")},
+	}, input.PolicyArns)
+
+	// defaults are left to AWS
+	input, err = (&SSORole{}).assumeRoleInput("arn:aws:iam::000000000002:role/Chained", "Admin@000000000001", role)
+	assert.NoError(t, err)
+	assert.Nil(t, input.DurationSeconds)
+	assert.Empty(t, input.Tags)
+	assert.Nil(t, input.Policy)
+	assert.Empty(t, input.PolicyArns)
+
+	// missing tags are an error instead of an empty value
+	r.SessionTags = map[string]string{"Owner": "{{ .Tags.Owner }}"}
+	_, err = r.assumeRoleInput("arn:aws:iam::000000000002:role/Chained", "Admin@000000000001", role)
+	assert.ErrorContains(t, err, "Unable to generate SessionTags value for Owner")
+}
+
+func TestFakeAWSAssumeRoleOptions(t *testing.T) {
+	as, fake := newFakeAWSSSO(t, AUTH_FLOW_DEVICE_CODE)
+	account := as.SSOConfig.Accounts["000000000002"]
+	account.Name = "Prod"
+	account.Tags = map[string]string{"Team": "platform"}
+	chained := account.Roles["Chained"]
+	chained.Duration = 15
+	chained.SessionTags = map[string]string{"Team": "{{ .Tags.Team }}", "Account": "{{ .AccountName }}"}
+	chained.TransitiveTagKeys = []string{"Team"}
+	chained.Policy = `{"Version":"2012-10-17","Statement":[]}`
+	chained.PolicyArns = []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}
+	as.SSOConfig.Refresh(as.SSOConfig.settings)
+
+	assert.NoError(t, as.Authenticate(url.Undef, ""))
+	creds, err := as.GetRoleCredentials(2, "Chained")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), time.UnixMilli(creds.Expiration), time.Minute)
+
+	reqs := fake.Requests(fakeaws.OP_ASSUME_ROLE)
+	assert.Len(t, reqs, 1)
+	form := reqs[0].Form
+	assert.Equal(t, "900", form.Get("DurationSeconds"))
+	assert.Equal(t, "Account", form.Get("Tags.member.1.Key"))
+	assert.Equal(t, "Prod", form.Get("Tags.member.1.Value"))
+	assert.Equal(t, "Team", form.Get("Tags.member.2.Key"))
+	assert.Equal(t, "platform", form.Get("Tags.member.2.Value"))
+	assert.Equal(t, "Team", form.Get("TransitiveTagKeys.member.1"))
+	assert.Equal(t, chained.Policy, form.Get("Policy"))
+	assert.Equal(t, "arn:aws:iam::aws:policy/ReadOnlyAccess", form.Get("PolicyArns.member.1.arn"))
+}
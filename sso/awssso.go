@@ -29,13 +29,11 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sso"
 	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
 	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
-	"github.com/davecgh/go-spew/spew"
 	"github.com/synfinatic/aws-sso-cli/internal/storage"
 	"github.com/synfinatic/aws-sso-cli/internal/url"
 	"github.com/synfinatic/aws-sso-cli/internal/utils"
@@ -80,9 +78,15 @@ type AWSSSO struct {
 	browser          string                      // cache for future calls
 	urlExecCommand   []string                    // cache for future calls
 	authenticateLock sync.RWMutex                // lock for reauthenticate()
+	identity         SSOIdentity                 // cache for ssoIdentity()
+	identityToken    string                      // AccessToken of our identity
+	identityLock     sync.Mutex                  // lock for identity
+	mfaToken         MfaTokenFunc                // returns MFA token codes
 }
 
-func NewAWSSSO(s *SSOConfig, store *storage.SecureStorage) *AWSSSO {
+// NewAWSSSO returns an AWSSSO for the given SSO instance or an error if
+// its HttpsProxy or CABundle are invalid
+func NewAWSSSO(s *SSOConfig, store *storage.SecureStorage) (*AWSSSO, error) {
 	var maxRetry int = MAX_RETRY_ATTEMPTS
 	if s.MaxRetry > 0 {
 		maxRetry = s.MaxRetry
@@ -98,15 +102,33 @@ func NewAWSSSO(s *SSOConfig, store *storage.SecureStorage) *AWSSSO {
 		o.MaxBackoff = time.Duration(maxBackoff) * time.Second
 	})
 
-	oidcSession := ssooidc.New(ssooidc.Options{
-		Region:  s.SSORegion,
-		Retryer: r,
-	})
+	httpClient, err := s.HTTPClient()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to configure HTTP client for %s: %s", s.key, err.Error())
+	}
 
-	ssoSession := sso.New(sso.Options{
-		Region:  s.SSORegion,
-		Retryer: r,
-	})
+	oidcOptions := ssooidc.Options{
+		Region:     s.SSORegion,
+		Retryer:    r,
+		HTTPClient: httpClient,
+	}
+	if endpoint := s.Endpoint(SERVICE_OIDC); endpoint != "" {
+		log.Debugf("Using SSO OIDC endpoint: %s", endpoint)
+		oidcOptions.BaseEndpoint = aws.String(endpoint)
+	}
+
+	ssoOptions := sso.Options{
+		Region:     s.SSORegion,
+		Retryer:    r,
+		HTTPClient: httpClient,
+	}
+	if endpoint := s.Endpoint(SERVICE_SSO); endpoint != "" {
+		log.Debugf("Using SSO endpoint: %s", endpoint)
+		ssoOptions.BaseEndpoint = aws.String(endpoint)
+	}
+
+	oidcSession := ssooidc.New(oidcOptions)
+	ssoSession := sso.New(ssoOptions)
 
 	as := AWSSSO{
 		key:            s.key,
@@ -123,7 +145,7 @@ func NewAWSSSO(s *SSOConfig, store *storage.SecureStorage) *AWSSSO {
 		browser:        s.settings.Browser,
 		urlExecCommand: s.settings.UrlExecCommand,
 	}
-	return &as
+	return &as, nil
 }
 
 type RoleInfo struct {
@@ -388,11 +410,16 @@ func (as *AWSSSO) GetAccounts() ([]AccountInfo, error) {
 	return as.Accounts, nil
 }
 
-var roleChainMap map[string]bool = map[string]bool{} // track our roles
-
 // GetRoleCredentials recursively does any sts:AssumeRole calls as necessary for role-chaining
 // through `Via` and returns the final set of RoleCredentials for the requested role
 func (as *AWSSSO) GetRoleCredentials(accountId int64, role string) (storage.RoleCredentials, error) {
+	return as.getRoleCredentials(accountId, role, RoleChain{utils.MakeRoleARN(accountId, role)}, &chainIdentity{})
+}
+
+// getRoleCredentials implements GetRoleCredentials.  chain ends with this role
+// and starts with the role originally requested.  identity is shared by every
+// role in the chain.
+func (as *AWSSSO) getRoleCredentials(accountId int64, role string, chain RoleChain, identity *chainIdentity) (storage.RoleCredentials, error) {
 	aId, err := utils.AccountIdToString(accountId)
 	if err != nil {
 		return storage.RoleCredentials{}, err
@@ -427,69 +454,88 @@ func (as *AWSSSO) GetRoleCredentials(accountId int64, role string) (storage.Role
 			Expiration:      output.RoleCredentials.Expiration,
 		}
 
+		// first hop of the chain, so these are the credentials which know
+		// who we are logged into AWS SSO as
+		identity.ssoCreds = &ret
 		return ret, nil
 	}
 
 	// Detect loops
-	roleChainMap[configRole.ARN] = true
-	for k := range roleChainMap {
-		if k == configRole.Via {
-			log.Fatalf("Detected role chain loop!  Getting %s via %s", configRole.ARN, configRole.Via)
-		}
-		roleChainMap[k] = true
+	via, err := viaARN(configRole.Via)
+	if err != nil {
+		return storage.RoleCredentials{}, fmt.Errorf("Invalid Via %s: %s", configRole.Via, err.Error())
+	}
+	if chain, err = chain.add(via); err != nil {
+		return storage.RoleCredentials{}, err
 	}
 
 	// Need to recursively call sts:AssumeRole in order to retrieve the STS creds for
 	// the requested role
 	// role has a Via
 	log.Debugf("Getting %s:%s via %s", aId, role, configRole.Via)
-	viaAccountId, viaRole, err := utils.ParseRoleARN(configRole.Via)
-	if err != nil {
-		return storage.RoleCredentials{}, fmt.Errorf("Invalid Via %s: %s", configRole.Via, err.Error())
+	var stsSession *sts.Client
+	var previousRole *AWSRoleFlat
+	if utils.IsUserARN(configRole.Via) {
+		// static IAM user credentials added via `aws-sso static add`
+		if stsSession, previousRole, err = as.staticUserSession(configRole.Via); err != nil {
+			return storage.RoleCredentials{}, err
+		}
+	} else {
+		viaAccountId, viaRole, err := utils.ParseRoleARN(configRole.Via)
+		if err != nil {
+			return storage.RoleCredentials{}, fmt.Errorf("Invalid Via %s: %s", configRole.Via, err.Error())
+		}
+
+		// recurse
+		creds, err := as.getRoleCredentials(viaAccountId, viaRole, chain, identity)
+		if err != nil {
+			return storage.RoleCredentials{}, err
+		}
+
+		cfgCreds := credentials.NewStaticCredentialsProvider(
+			creds.AccessKeyId,
+			creds.SecretAccessKey,
+			creds.SessionToken,
+		)
+
+		if stsSession, err = as.SSOConfig.STSClient(cfgCreds); err != nil {
+			return storage.RoleCredentials{}, err
+		}
+
+		viaConfigRole, _ := as.SSOConfig.GetRole(viaAccountId, viaRole)
+		previousRole = as.templateRole(viaAccountId, viaRole, viaConfigRole)
 	}
 
-	// recurse
-	creds, err := as.GetRoleCredentials(viaAccountId, viaRole)
+	sessionData := &RoleSessionNameData{
+		Role:         as.templateRole(accountId, role, configRole),
+		PreviousRole: previousRole,
+		identity: func() (SSOIdentity, error) {
+			return as.ssoIdentity(identity)
+		},
+	}
+	sessionName, err := as.roleSessionName(sessionData)
 	if err != nil {
 		return storage.RoleCredentials{}, err
 	}
 
-	cfgCreds := credentials.NewStaticCredentialsProvider(
-		creds.AccessKeyId,
-		creds.SecretAccessKey,
-		creds.SessionToken,
-	)
-
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(as.SsoRegion),
-		config.WithCredentialsProvider(cfgCreds),
-	)
+	input, err := configRole.assumeRoleInput(utils.MakeRoleARN(accountId, role), sessionName, sessionData.Role)
 	if err != nil {
 		return storage.RoleCredentials{}, err
 	}
-	stsSession := sts.NewFromConfig(cfg)
-
-	previousAccount, _ := utils.AccountIdToString(creds.AccountId)
-	previousRole := fmt.Sprintf("%s@%s", creds.RoleName, previousAccount)
-
-	input := sts.AssumeRoleInput{
-		//		DurationSeconds: aws.Int64(900),
-		RoleArn:         aws.String(utils.MakeRoleARN(accountId, role)),
-		RoleSessionName: aws.String(previousRole),
-	}
-	if configRole.ExternalId != "" {
-		// Optional vlaue: https://docs.aws.amazon.com/sdk-for-go/api/service/sts/#AssumeRoleInput
-		input.ExternalId = aws.String(configRole.ExternalId)
-	}
-	if configRole.SourceIdentity != "" {
-		input.SourceIdentity = aws.String(configRole.SourceIdentity)
+	if configRole.MfaSerial != "" {
+		token, err := as.getMfaToken(configRole.MfaSerial)
+		if err != nil {
+			return storage.RoleCredentials{}, err
+		}
+		input.SerialNumber = aws.String(configRole.MfaSerial)
+		input.TokenCode = aws.String(token)
 	}
 
-	output, err := stsSession.AssumeRole(context.TODO(), &input)
+	output, err := stsSession.AssumeRole(context.TODO(), input)
 	if err != nil {
 		return storage.RoleCredentials{}, err
 	}
-	log.Debugf("%s", spew.Sdump(output))
+	log.Debugf("Assumed %s", aws.ToString(output.AssumedRoleUser.Arn))
 	ret := storage.RoleCredentials{
 		AccountId:       accountId,
 		RoleName:        role,
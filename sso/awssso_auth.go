@@ -22,6 +22,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -30,11 +33,14 @@ import (
 	oidctypes "github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
 	"github.com/synfinatic/aws-sso-cli/internal/storage"
 	"github.com/synfinatic/aws-sso-cli/internal/url"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
 )
 
 const (
 	DEFAULT_AUTH_COLOR = "blue"
 	DEFAULT_AUTH_ICON  = "fingerprint"
+	// how long to wait for another aws-sso process to finish authenticating
+	AUTH_LOCK_TIMEOUT = 15 * time.Minute
 )
 
 // Authenticate retrieves an AWS SSO AccessToken from our cache or by
@@ -61,6 +67,14 @@ func (as *AWSSSO) Authenticate(urlAction url.Action, browser string) error {
 	} else if err != nil {
 		log.Debugf(err.Error())
 	} else {
+		if token.RefreshToken != "" {
+			// try to silently refresh our AccessToken before making the user re-auth
+			if err = as.refreshToken(token.RefreshToken); err == nil {
+				return nil
+			}
+			log.WithError(err).Debugf("Unable to refresh SSO token")
+		}
+
 		if token.ExpiresAt != 0 {
 			t := time.Unix(token.ExpiresAt, 0)
 			log.Infof("Cached SSO token expired at: %s.  Reauthenticating...\n",
@@ -78,13 +92,90 @@ func (as *AWSSSO) StoreKey() string {
 	return as.key
 }
 
+// authLockFile returns the file used to serialize authentication to this
+// AWS SSO instance across aws-sso processes
+func (as *AWSSSO) authLockFile() string {
+	dir := os.TempDir()
+	if as.SSOConfig != nil && as.SSOConfig.settings != nil && as.SSOConfig.settings.cacheFile != "" {
+		dir = filepath.Dir(as.SSOConfig.settings.cacheFile)
+	}
+	return filepath.Join(dir, "auth-"+neturl.PathEscape(as.StoreKey()))
+}
+
+// lockAuth serializes authentication within this process and with any
+// other aws-sso process using the same AWS SSO instance
+func (as *AWSSSO) lockAuth() (func(), error) {
+	as.authenticateLock.Lock()
+
+	lock, err := utils.LockFileTimeout(as.authLockFile(), AUTH_LOCK_TIMEOUT)
+	if err != nil {
+		as.authenticateLock.Unlock()
+		return nil, fmt.Errorf("Unable to wait for another aws-sso process to authenticate: %s", err.Error())
+	}
+
+	return func() {
+		if err := lock.Unlock(); err != nil {
+			log.WithError(err).Warnf("Unable to release authentication lock")
+		}
+		as.authenticateLock.Unlock()
+	}, nil
+}
+
+// useStoredToken returns true if another aws-sso process has saved a valid
+// AccessToken for us which isn't the stale one we were using
+func (as *AWSSSO) useStoredToken(stale string) bool {
+	token := storage.CreateTokenResponse{}
+	if err := as.store.GetCreateTokenResponse(as.StoreKey(), &token); err != nil {
+		return false
+	}
+	if token.Expired() || token.AccessToken == "" || token.AccessToken == stale {
+		return false
+	}
+
+	log.Debugf("Using SSO token for %s from another aws-sso process", as.StoreKey())
+	as.tokenLock.Lock()
+	as.Token = token
+	as.tokenLock.Unlock()
+	return true
+}
+
+// staleToken returns the AccessToken we are currently using
+func (as *AWSSSO) staleToken() string {
+	as.tokenLock.RLock()
+	defer as.tokenLock.RUnlock()
+	return as.Token.AccessToken
+}
+
 // reauthenticate talks to AWS SSO to generate a new AWS SSO AccessToken
 func (as *AWSSSO) reauthenticate() error {
+	stale := as.staleToken()
+
 	// This should only be happening one at a time!
-	as.authenticateLock.Lock()
-	defer as.authenticateLock.Unlock()
+	unlock, err := as.lockAuth()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// did another process authenticate while we were waiting?
+	if as.useStoredToken(stale) {
+		return nil
+	}
 
 	log.Tracef("reauthenticate() for %s", as.StoreKey())
+	switch flow := as.SSOConfig.GetAuthFlow(); flow {
+	case AUTH_FLOW_DEVICE_CODE:
+		return as.reauthenticateDeviceCode()
+	case AUTH_FLOW_AUTH_CODE:
+		return as.reauthenticateAuthCode()
+	default:
+		return fmt.Errorf("Invalid AuthFlow for %s: %s", as.StoreKey(), flow)
+	}
+}
+
+// reauthenticateDeviceCode uses the OIDC device authorization grant to
+// generate a new AWS SSO AccessToken
+func (as *AWSSSO) reauthenticateDeviceCode() error {
 	err := as.registerClient(false)
 	log.Tracef("<- reauthenticate()")
 	if err != nil {
@@ -110,16 +201,7 @@ func (as *AWSSSO) reauthenticate() error {
 		return fmt.Errorf("Unable to get device auth info from AWS SSO: %s", err.Error())
 	}
 
-	action := as.urlAction
-	if as.SSOConfig.AuthUrlAction != url.Undef {
-		// specific action for authentication?
-		action = as.SSOConfig.AuthUrlAction
-	}
-
-	urlOpener := url.NewHandleUrl(action, auth.VerificationUriComplete, as.browser, as.urlExecCommand)
-	urlOpener.ContainerSettings(as.StoreKey(), DEFAULT_AUTH_COLOR, DEFAULT_AUTH_ICON)
-
-	if err = urlOpener.Open(); err != nil {
+	if err = as.openAuthUrl(auth.VerificationUriComplete); err != nil {
 		return err
 	}
 
@@ -133,10 +215,31 @@ func (as *AWSSSO) reauthenticate() error {
 	return nil
 }
 
+// openAuthUrl presents the given SSO authentication URL to the user
+func (as *AWSSSO) openAuthUrl(authUrl string) error {
+	action := as.urlAction
+	if as.SSOConfig.AuthUrlAction != url.Undef {
+		// specific action for authentication?
+		action = as.SSOConfig.AuthUrlAction
+	}
+
+	urlOpener := url.NewHandleUrl(action, authUrl, as.browser, as.urlExecCommand)
+	urlOpener.ContainerSettings(as.StoreKey(), DEFAULT_AUTH_COLOR, DEFAULT_AUTH_ICON)
+
+	return urlOpener.Open()
+}
+
 const (
 	awsSSOClientName = "aws-sso-cli"
 	awsSSOClientType = "public"
 	awsSSOGrantType  = "urn:ietf:params:oauth:grant-type:device_code"
+	// required to get a RefreshToken
+	awsSSOScope             = "sso:account:access"
+	awsSSORefreshGrantType  = "refresh_token"
+	awsSSOAuthCodeGrantType = "authorization_code"
+	// The OIDC flows we support for authenticating to AWS SSO
+	AUTH_FLOW_DEVICE_CODE = "device-code"
+	AUTH_FLOW_AUTH_CODE   = "auth-code"
 	// The default values for ODIC defined in:
 	// https://tools.ietf.org/html/draft-ietf-oauth-device-flow-15#section-3.5
 	SLOW_DOWN_SEC  = 5
@@ -147,31 +250,39 @@ const (
 // RegisterClientData for later steps and saves it to our secret store
 func (as *AWSSSO) registerClient(force bool) error {
 	log.Tracef("registerClient()")
+	input := ssooidc.RegisterClientInput{
+		ClientName: aws.String(as.ClientName),
+		ClientType: aws.String(as.ClientType),
+		Scopes:     []string{awsSSOScope},
+	}
+	if as.SSOConfig.GetAuthFlow() == AUTH_FLOW_AUTH_CODE {
+		input.GrantTypes = []string{awsSSOAuthCodeGrantType, awsSSORefreshGrantType}
+		input.IssuerUrl = aws.String(as.StartUrl)
+		input.RedirectUris = []string{fmt.Sprintf("http://%s%s", AUTH_CODE_LISTEN_HOST, AUTH_CODE_CALLBACK_PATH)}
+	}
+
 	if !force {
 		err := as.store.GetRegisterClientData(as.StoreKey(), &as.ClientData)
-		if err == nil && !as.ClientData.Expired() {
+		if err == nil && !as.ClientData.Expired() && clientDataMatches(as.ClientData, input) {
 			log.Debugf("Using RegisterClient cache for %s", as.StoreKey())
 			return nil
 		}
 	}
 
-	input := ssooidc.RegisterClientInput{
-		ClientName: aws.String(as.ClientName),
-		ClientType: aws.String(as.ClientType),
-		Scopes:     nil,
-	}
 	resp, err := as.ssooidc.RegisterClient(context.TODO(), &input)
 	if err != nil {
 		return err
 	}
 
 	as.ClientData = storage.RegisterClientData{
-		AuthorizationEndpoint: aws.ToString(resp.AuthorizationEndpoint), // not used?
+		AuthorizationEndpoint: aws.ToString(resp.AuthorizationEndpoint),
 		ClientId:              aws.ToString(resp.ClientId),
 		ClientSecret:          aws.ToString(resp.ClientSecret),
 		ClientIdIssuedAt:      resp.ClientIdIssuedAt,
 		ClientSecretExpiresAt: resp.ClientSecretExpiresAt,
 		TokenEndpoint:         aws.ToString(resp.TokenEndpoint), // not used?
+		GrantTypes:            input.GrantTypes,
+		Scopes:                input.Scopes,
 	}
 	err = as.store.SaveRegisterClientData(as.StoreKey(), as.ClientData)
 	if err != nil {
@@ -180,6 +291,22 @@ func (as *AWSSSO) registerClient(force bool) error {
 	return nil
 }
 
+// clientDataMatches returns true if the cached RegisterClientData was registered
+// with all the scopes & grant types we need
+func clientDataMatches(data storage.RegisterClientData, input ssooidc.RegisterClientInput) bool {
+	for _, scope := range input.Scopes {
+		if !data.HasScope(scope) {
+			return false
+		}
+	}
+	for _, grantType := range input.GrantTypes {
+		if !data.HasGrantType(grantType) {
+			return false
+		}
+	}
+	return true
+}
+
 // startDeviceAuthorization makes the call to AWS to initiate the OIDC auth
 // to the SSO provider.
 func (as *AWSSSO) startDeviceAuthorization() error {
@@ -238,7 +365,7 @@ func (as *AWSSSO) createToken() error {
 		ClientSecret: aws.String(as.ClientData.ClientSecret),
 		DeviceCode:   aws.String(as.DeviceAuth.DeviceCode),
 		GrantType:    aws.String(awsSSOGrantType),
-		// RefreshToken is not supported by AWS
+		// RefreshToken is only used with the refresh_token grant, see refreshToken()
 	}
 
 	// figure out our timings
@@ -271,6 +398,57 @@ func (as *AWSSSO) createToken() error {
 		}
 	}
 
+	return as.saveToken(resp)
+}
+
+// refreshToken uses the given RefreshToken to silently get a new AccessToken
+// and saves it to our secret store
+func (as *AWSSSO) refreshToken(refreshToken string) error {
+	stale := as.staleToken()
+
+	unlock, err := as.lockAuth()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// did another process refresh our token while we were waiting?
+	if as.useStoredToken(stale) {
+		return nil
+	}
+
+	log.Tracef("refreshToken() for %s", as.StoreKey())
+	err = as.store.GetRegisterClientData(as.StoreKey(), &as.ClientData)
+	if err != nil {
+		return err
+	}
+	if as.ClientData.Expired() {
+		return fmt.Errorf("RegisterClient data for %s has expired", as.StoreKey())
+	}
+
+	input := ssooidc.CreateTokenInput{
+		ClientId:     aws.String(as.ClientData.ClientId),
+		ClientSecret: aws.String(as.ClientData.ClientSecret),
+		GrantType:    aws.String(awsSSORefreshGrantType),
+		RefreshToken: aws.String(refreshToken),
+	}
+	resp, err := as.ssooidc.CreateToken(context.TODO(), &input)
+	if err != nil {
+		return fmt.Errorf("createToken: %s", err.Error())
+	}
+
+	// AWS may or may not rotate our RefreshToken
+	if aws.ToString(resp.RefreshToken) == "" {
+		resp.RefreshToken = aws.String(refreshToken)
+	}
+
+	log.Debugf("Refreshed SSO token for %s", as.StoreKey())
+	return as.saveToken(resp)
+}
+
+// saveToken updates our Token from the CreateToken output and saves it
+// to our secret store
+func (as *AWSSSO) saveToken(resp *ssooidc.CreateTokenOutput) error {
 	secs, _ := time.ParseDuration(fmt.Sprintf("%ds", resp.ExpiresIn)) // seconds
 	as.tokenLock.Lock()
 	as.Token = storage.CreateTokenResponse{
@@ -278,17 +456,16 @@ func (as *AWSSSO) createToken() error {
 		ExpiresIn:    resp.ExpiresIn,
 		ExpiresAt:    time.Now().Add(secs).Unix(),
 		IdToken:      aws.ToString(resp.IdToken),      // per AWS docs, this may be undefined
-		RefreshToken: aws.ToString(resp.RefreshToken), // per AWS docs, not currently implemented
+		RefreshToken: aws.ToString(resp.RefreshToken), // requires the sso:account:access scope
 		TokenType:    aws.ToString(resp.TokenType),
 	}
 	as.tokenLock.Unlock()
 	as.tokenLock.RLock()
-	err = as.store.SaveCreateTokenResponse(as.StoreKey(), as.Token)
+	err := as.store.SaveCreateTokenResponse(as.StoreKey(), as.Token)
 	as.tokenLock.RUnlock()
 	if err != nil {
 		log.WithError(err).Errorf("Unable to save CreateTokenResponse")
 	}
-
 	return nil
 }
 
@@ -0,0 +1,258 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+)
+
+const (
+	// AWS SSO OIDC authorization endpoint if RegisterClient doesn't tell us
+	AUTH_CODE_AUTHORIZE_URL = "https://oidc.%s.amazonaws.com/authorize"
+	// Loopback redirect listener per RFC 8252.  The port is picked at runtime.
+	AUTH_CODE_LISTEN_HOST   = "127.0.0.1"
+	AUTH_CODE_CALLBACK_PATH = "/oauth/callback"
+	AUTH_CODE_TIMEOUT       = 10 * time.Minute
+)
+
+// PKCE contains the code verifier and challenge as defined in RFC 7636
+type PKCE struct {
+	Verifier  string
+	Challenge string
+	State     string
+}
+
+// NewPKCE generates a new random code verifier, S256 challenge and state
+func NewPKCE() (PKCE, error) {
+	verifier, err := randomUrlString(32)
+	if err != nil {
+		return PKCE{}, err
+	}
+	state, err := randomUrlString(16)
+	if err != nil {
+		return PKCE{}, err
+	}
+
+	hash := sha256.Sum256([]byte(verifier))
+	return PKCE{
+		Verifier:  verifier,
+		Challenge: base64.RawURLEncoding.EncodeToString(hash[:]),
+		State:     state,
+	}, nil
+}
+
+// randomUrlString returns a URL safe base64 encoded string of the given number
+// of random bytes
+func randomUrlString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// authCodeResult is what our loopback listener receives from the browser
+type authCodeResult struct {
+	Code  string
+	Error error
+}
+
+// authCodeListener is the loopback HTTP server which receives the OIDC redirect
+type authCodeListener struct {
+	listener net.Listener
+	server   *http.Server
+	state    string
+	result   chan authCodeResult
+}
+
+// newAuthCodeListener starts listening on a random loopback port
+func newAuthCodeListener(state string) (*authCodeListener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:0", AUTH_CODE_LISTEN_HOST))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &authCodeListener{
+		listener: listener,
+		state:    state,
+		result:   make(chan authCodeResult, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(AUTH_CODE_CALLBACK_PATH, l.callback)
+	l.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := l.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Errorf("Auth code listener failed")
+		}
+	}()
+	return l, nil
+}
+
+// RedirectUri returns the URL the browser should be redirected to
+func (l *authCodeListener) RedirectUri() string {
+	return fmt.Sprintf("http://%s%s", l.listener.Addr().String(), AUTH_CODE_CALLBACK_PATH)
+}
+
+// callback handles the redirect from the SSO provider
+func (l *authCodeListener) callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	// not the redirect for our authorization request, so keep waiting for it
+	if query.Get("state") != l.state {
+		log.Warnf("Ignoring OIDC redirect with an invalid state")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "aws-sso: Invalid state in OIDC redirect\n")
+		return
+	}
+
+	var result authCodeResult
+	switch {
+	case query.Get("error") != "":
+		result.Error = fmt.Errorf("SSO authorization failed: %s %s",
+			query.Get("error"), query.Get("error_description"))
+	case query.Get("code") == "":
+		result.Error = fmt.Errorf("No authorization code in OIDC redirect")
+	default:
+		result.Code = query.Get("code")
+	}
+
+	if result.Error != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "aws-sso: %s\n", result.Error.Error())
+	} else {
+		fmt.Fprintf(w, "aws-sso: Authorization complete.  You may close this window.\n")
+	}
+
+	// only the first redirect counts
+	select {
+	case l.result <- result:
+	default:
+	}
+}
+
+// Wait blocks until we receive the redirect or the timeout expires
+func (l *authCodeListener) Wait(timeout time.Duration) (string, error) {
+	select {
+	case result := <-l.result:
+		return result.Code, result.Error
+	case <-time.After(timeout):
+		return "", fmt.Errorf("Timed out waiting for SSO authorization")
+	}
+}
+
+// Close shuts down the loopback listener
+func (l *authCodeListener) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := l.server.Shutdown(ctx); err != nil {
+		log.WithError(err).Debugf("Unable to shutdown auth code listener")
+	}
+}
+
+// reauthenticateAuthCode uses the OIDC authorization code grant with PKCE
+// and a loopback redirect to generate a new AWS SSO AccessToken
+func (as *AWSSSO) reauthenticateAuthCode() error {
+	if err := as.registerClient(false); err != nil {
+		return fmt.Errorf("Unable to register client with AWS SSO: %s", err.Error())
+	}
+
+	if err := as.authorizeAuthCode(as.openAuthUrl, AUTH_CODE_TIMEOUT); err != nil {
+		return fmt.Errorf("Unable to create new AWS SSO token: %s", err.Error())
+	}
+	return nil
+}
+
+// authorizeAuthCode starts the loopback listener, passes the authorization URL
+// to the open function and exchanges the returned code for an AccessToken
+func (as *AWSSSO) authorizeAuthCode(open func(string) error, timeout time.Duration) error {
+	log.Tracef("authorizeAuthCode() for %s", as.StoreKey())
+	pkce, err := NewPKCE()
+	if err != nil {
+		return err
+	}
+
+	listener, err := newAuthCodeListener(pkce.State)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	redirectUri := listener.RedirectUri()
+	if err = open(as.authorizeUrl(pkce, redirectUri)); err != nil {
+		return err
+	}
+
+	log.Infof("Waiting for SSO authentication...")
+
+	code, err := listener.Wait(timeout)
+	if err != nil {
+		return err
+	}
+
+	input := ssooidc.CreateTokenInput{
+		ClientId:     aws.String(as.ClientData.ClientId),
+		ClientSecret: aws.String(as.ClientData.ClientSecret),
+		GrantType:    aws.String(awsSSOAuthCodeGrantType),
+		Code:         aws.String(code),
+		CodeVerifier: aws.String(pkce.Verifier),
+		RedirectUri:  aws.String(redirectUri),
+	}
+	resp, err := as.ssooidc.CreateToken(context.TODO(), &input)
+	if err != nil {
+		return fmt.Errorf("createToken: %s", err.Error())
+	}
+
+	return as.saveToken(resp)
+}
+
+// authorizeUrl returns the URL the user needs to open in their browser
+func (as *AWSSSO) authorizeUrl(pkce PKCE, redirectUri string) string {
+	endpoint := as.ClientData.AuthorizationEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf(AUTH_CODE_AUTHORIZE_URL, as.SsoRegion)
+	}
+
+	params := neturl.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", as.ClientData.ClientId)
+	params.Set("redirect_uri", redirectUri)
+	params.Set("state", pkce.State)
+	params.Set("code_challenge_method", "S256")
+	params.Set("code_challenge", pkce.Challenge)
+	params.Set("scopes", awsSSOScope)
+
+	return fmt.Sprintf("%s?%s", endpoint, params.Encode())
+}
@@ -0,0 +1,259 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+// fakeOidcEndpoint implements SsoOidcAPI plus the browser side of the
+// authorization code flow
+type fakeOidcEndpoint struct {
+	RegisterInput *ssooidc.RegisterClientInput
+	Challenge     string
+	Code          string
+	State         string // override the state returned to the redirect
+	Error         string // return this OAuth error to the redirect
+}
+
+func (f *fakeOidcEndpoint) RegisterClient(ctx context.Context, params *ssooidc.RegisterClientInput, optFns ...func(*ssooidc.Options)) (*ssooidc.RegisterClientOutput, error) {
+	f.RegisterInput = params
+	return &ssooidc.RegisterClientOutput{
+		AuthorizationEndpoint: aws.String("https://oidc.fake.local/authorize"),
+		ClientId:              aws.String("auth-code-client-id"),
+		ClientSecret:          aws.String("auth-code-client-secret"),
+		ClientIdIssuedAt:      time.Now().Unix(),
+		ClientSecretExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+	}, nil
+}
+
+func (f *fakeOidcEndpoint) StartDeviceAuthorization(ctx context.Context, params *ssooidc.StartDeviceAuthorizationInput, optFns ...func(*ssooidc.Options)) (*ssooidc.StartDeviceAuthorizationOutput, error) {
+	return nil, fmt.Errorf("StartDeviceAuthorization is not supported")
+}
+
+func (f *fakeOidcEndpoint) CreateToken(ctx context.Context, params *ssooidc.CreateTokenInput, optFns ...func(*ssooidc.Options)) (*ssooidc.CreateTokenOutput, error) {
+	if aws.ToString(params.GrantType) != awsSSOAuthCodeGrantType {
+		return nil, fmt.Errorf("invalid grant type: %s", aws.ToString(params.GrantType))
+	}
+	if aws.ToString(params.Code) != f.Code {
+		return nil, fmt.Errorf("invalid code: %s", aws.ToString(params.Code))
+	}
+	hash := sha256.Sum256([]byte(aws.ToString(params.CodeVerifier)))
+	if base64.RawURLEncoding.EncodeToString(hash[:]) != f.Challenge {
+		return nil, fmt.Errorf("invalid code verifier")
+	}
+	return &ssooidc.CreateTokenOutput{
+		AccessToken: aws.String("auth-code-access-token"),
+		ExpiresIn:   3600,
+		TokenType:   aws.String("Bearer"),
+	}, nil
+}
+
+// Authorize plays the role of the browser & SSO provider
+func (f *fakeOidcEndpoint) Authorize(authUrl string) error {
+	u, err := neturl.Parse(authUrl)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		return fmt.Errorf("invalid code_challenge_method")
+	}
+	f.Challenge = query.Get("code_challenge")
+
+	state := query.Get("state")
+	if f.State != "" {
+		state = f.State
+	}
+	redirect := neturl.Values{}
+	redirect.Set("state", state)
+	if f.Error != "" {
+		redirect.Set("error", f.Error)
+	} else {
+		redirect.Set("code", f.Code)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s?%s", query.Get("redirect_uri"), redirect.Encode()))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func newAuthCodeAWSSSO(t *testing.T) (*AWSSSO, *fakeOidcEndpoint) {
+	tfile, err := os.CreateTemp("", "*storage.json")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.Remove(tfile.Name()) })
+
+	jstore, err := storage.OpenJsonStore(tfile.Name())
+	assert.NoError(t, err)
+
+	fake := &fakeOidcEndpoint{Code: "the-auth-code"}
+	as := &AWSSSO{
+		key:       "authcode",
+		SsoRegion: "us-west-1",
+		StartUrl:  "https://testing.awsapps.com/start",
+		store:     jstore,
+		ssooidc:   fake,
+		SSOConfig: &SSOConfig{
+			AuthFlow: AUTH_FLOW_AUTH_CODE,
+			settings: &Settings{},
+		},
+	}
+	return as, fake
+}
+
+func TestNewPKCE(t *testing.T) {
+	pkce, err := NewPKCE()
+	assert.NoError(t, err)
+	assert.Len(t, pkce.Verifier, 43)
+	assert.NotEmpty(t, pkce.State)
+
+	hash := sha256.Sum256([]byte(pkce.Verifier))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(hash[:]), pkce.Challenge)
+
+	pkce2, err := NewPKCE()
+	assert.NoError(t, err)
+	assert.NotEqual(t, pkce.Verifier, pkce2.Verifier)
+	assert.NotEqual(t, pkce.State, pkce2.State)
+}
+
+func TestAuthCodeFlow(t *testing.T) {
+	as, fake := newAuthCodeAWSSSO(t)
+
+	err := as.registerClient(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{awsSSOAuthCodeGrantType, awsSSORefreshGrantType}, fake.RegisterInput.GrantTypes)
+	assert.Equal(t, []string{awsSSOScope}, fake.RegisterInput.Scopes)
+	assert.Equal(t, []string{"http://127.0.0.1/oauth/callback"}, fake.RegisterInput.RedirectUris)
+	assert.Equal(t, "https://testing.awsapps.com/start", aws.ToString(fake.RegisterInput.IssuerUrl))
+	assert.True(t, as.ClientData.HasGrantType(awsSSOAuthCodeGrantType))
+
+	err = as.authorizeAuthCode(fake.Authorize, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "auth-code-access-token", as.Token.AccessToken)
+	assert.False(t, as.Token.Expired())
+
+	token := storage.CreateTokenResponse{}
+	assert.NoError(t, as.store.GetCreateTokenResponse(as.StoreKey(), &token))
+	assert.Equal(t, "auth-code-access-token", token.AccessToken)
+
+	// cached registration is re-used
+	fake.RegisterInput = nil
+	assert.NoError(t, as.registerClient(false))
+	assert.Nil(t, fake.RegisterInput)
+
+	// but a device code registration is not
+	as.ClientData.GrantTypes = nil
+	assert.NoError(t, as.store.SaveRegisterClientData(as.StoreKey(), as.ClientData))
+	assert.NoError(t, as.registerClient(false))
+	assert.NotNil(t, fake.RegisterInput)
+}
+
+func TestAuthCodeFlowFailures(t *testing.T) {
+	as, fake := newAuthCodeAWSSSO(t)
+	assert.NoError(t, as.registerClient(false))
+
+	// redirects with the wrong state are rejected and we keep waiting
+	fake.State = "invalid-state"
+	err := as.authorizeAuthCode(fake.Authorize, 100*time.Millisecond)
+	assert.ErrorContains(t, err, "Timed out")
+
+	err = as.authorizeAuthCode(func(u string) error {
+		authUrl, err := neturl.Parse(u)
+		if err != nil {
+			return err
+		}
+		resp, err := http.Get(authUrl.Query().Get("redirect_uri") + "?state=invalid-state&code=evil")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		fake.State = ""
+		return fake.Authorize(u)
+	}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "auth-code-access-token", as.Token.AccessToken)
+	as.Token = storage.CreateTokenResponse{}
+
+	fake.Error = "access_denied"
+	err = as.authorizeAuthCode(fake.Authorize, 5*time.Second)
+	assert.ErrorContains(t, err, "access_denied")
+
+	fake.Error = ""
+	err = as.authorizeAuthCode(func(string) error { return fmt.Errorf("no browser") }, 5*time.Second)
+	assert.ErrorContains(t, err, "no browser")
+
+	err = as.authorizeAuthCode(func(string) error { return nil }, 100*time.Millisecond)
+	assert.ErrorContains(t, err, "Timed out")
+
+	fake.Code = "some-other-code"
+	err = as.authorizeAuthCode(func(u string) error {
+		fake.Code = "the-auth-code"
+		defer func() { fake.Code = "some-other-code" }()
+		return fake.Authorize(u)
+	}, 5*time.Second)
+	assert.ErrorContains(t, err, "invalid code")
+	assert.Empty(t, as.Token.AccessToken)
+}
+
+func TestAuthorizeUrl(t *testing.T) {
+	as, _ := newAuthCodeAWSSSO(t)
+	as.ClientData.ClientId = "my-client"
+	pkce := PKCE{Verifier: "verifier", Challenge: "challenge", State: "state"}
+
+	u, err := neturl.Parse(as.authorizeUrl(pkce, "http://127.0.0.1:1234/oauth/callback"))
+	assert.NoError(t, err)
+	assert.Equal(t, "oidc.us-west-1.amazonaws.com", u.Host)
+	assert.Equal(t, "/authorize", u.Path)
+	query := u.Query()
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "my-client", query.Get("client_id"))
+	assert.Equal(t, "http://127.0.0.1:1234/oauth/callback", query.Get("redirect_uri"))
+	assert.Equal(t, "state", query.Get("state"))
+	assert.Equal(t, "challenge", query.Get("code_challenge"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Empty(t, query.Get("code_verifier"))
+
+	as.ClientData.AuthorizationEndpoint = "https://oidc.fake.local/authorize"
+	u, err = neturl.Parse(as.authorizeUrl(pkce, "http://127.0.0.1:1234/oauth/callback"))
+	assert.NoError(t, err)
+	assert.Equal(t, "oidc.fake.local", u.Host)
+}
+
+func TestReauthenticateInvalidFlow(t *testing.T) {
+	as, _ := newAuthCodeAWSSSO(t)
+	as.SSOConfig.AuthFlow = "invalid"
+	assert.ErrorContains(t, as.reauthenticate(), "Invalid AuthFlow")
+}
@@ -22,6 +22,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -31,6 +32,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/stretchr/testify/assert"
 	"github.com/synfinatic/aws-sso-cli/internal/storage"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
 )
 
 // mock ssooidc
@@ -436,6 +438,131 @@ func TestAuthenticateFailure(t *testing.T) {
 	assert.Contains(t, err.Error(), "Unsupported Open action")
 }
 
+func TestRefreshToken(t *testing.T) {
+	tfile, err := os.CreateTemp("", "*storage.json")
+	assert.NoError(t, err)
+
+	jstore, err := storage.OpenJsonStore(tfile.Name())
+	assert.NoError(t, err)
+
+	defer os.Remove(tfile.Name())
+
+	as := &AWSSSO{
+		key:       "refresh",
+		SsoRegion: "us-west-1",
+		StartUrl:  "https://testing.awsapps.com/start",
+		store:     jstore,
+		SSOConfig: &SSOConfig{
+			settings: &Settings{},
+		},
+	}
+
+	clientData := storage.RegisterClientData{
+		ClientId:              "this-is-my-client-id",
+		ClientSecret:          "this-is-my-client-secret",
+		ClientIdIssuedAt:      time.Now().Unix(),
+		ClientSecretExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+		Scopes:                []string{awsSSOScope},
+	}
+	assert.NoError(t, jstore.SaveRegisterClientData(as.StoreKey(), clientData))
+
+	expiredToken := storage.CreateTokenResponse{
+		AccessToken:  "old-access-token",
+		ExpiresIn:    42,
+		ExpiresAt:    time.Now().Add(-1 * time.Hour).Unix(),
+		RefreshToken: "refresh-token",
+		TokenType:    "token-type",
+	}
+	assert.NoError(t, jstore.SaveCreateTokenResponse(as.StoreKey(), expiredToken))
+
+	// silent refresh without any RegisterClient or StartDeviceAuthorization
+	as.ssooidc = &mockSsoOidcAPI{
+		Results: []mockSsoOidcAPIResults{
+			{
+				CreateToken: &ssooidc.CreateTokenOutput{
+					AccessToken: aws.String("new-access-token"),
+					ExpiresIn:   3600,
+					TokenType:   aws.String("token-type"),
+				},
+				Error: nil,
+			},
+		},
+	}
+
+	err = as.Authenticate("print", "fake-browser")
+	assert.NoError(t, err)
+	assert.Equal(t, "new-access-token", as.Token.AccessToken)
+	assert.Equal(t, "refresh-token", as.Token.RefreshToken)
+
+	token := storage.CreateTokenResponse{}
+	assert.NoError(t, jstore.GetCreateTokenResponse(as.StoreKey(), &token))
+	assert.Equal(t, "new-access-token", token.AccessToken)
+	assert.Equal(t, "refresh-token", token.RefreshToken)
+	assert.False(t, token.Expired())
+
+	// failed refresh falls back to reauthenticate()
+	assert.NoError(t, jstore.SaveCreateTokenResponse(as.StoreKey(), expiredToken))
+	as.ssooidc = &mockSsoOidcAPI{
+		Results: []mockSsoOidcAPIResults{
+			{
+				CreateToken: &ssooidc.CreateTokenOutput{},
+				Error:       fmt.Errorf("invalid_grant"),
+			},
+			{
+				StartDeviceAuthorization: &ssooidc.StartDeviceAuthorizationOutput{
+					DeviceCode:              aws.String("device-code"),
+					UserCode:                aws.String("user-code"),
+					VerificationUri:         aws.String("verification-uri"),
+					VerificationUriComplete: aws.String("verification-uri-complete"),
+					ExpiresIn:               42,
+					Interval:                5,
+				},
+				Error: nil,
+			},
+			{
+				CreateToken: &ssooidc.CreateTokenOutput{
+					AccessToken:  aws.String("reauth-access-token"),
+					ExpiresIn:    3600,
+					RefreshToken: aws.String("new-refresh-token"),
+					TokenType:    aws.String("token-type"),
+				},
+				Error: nil,
+			},
+		},
+	}
+
+	err = as.Authenticate("print", "fake-browser")
+	assert.NoError(t, err)
+	assert.Equal(t, "reauth-access-token", as.Token.AccessToken)
+	assert.Equal(t, "new-refresh-token", as.Token.RefreshToken)
+
+	// expired client registration can't be used to refresh
+	clientData.ClientSecretExpiresAt = time.Now().Unix()
+	assert.NoError(t, jstore.SaveRegisterClientData(as.StoreKey(), clientData))
+	assert.ErrorContains(t, as.refreshToken("refresh-token"), "has expired")
+
+	// client registrations without our scope are not re-used
+	clientData.ClientSecretExpiresAt = time.Now().Add(24 * time.Hour).Unix()
+	clientData.Scopes = []string{}
+	assert.NoError(t, jstore.SaveRegisterClientData(as.StoreKey(), clientData))
+	as.ssooidc = &mockSsoOidcAPI{
+		Results: []mockSsoOidcAPIResults{
+			{
+				RegisterClient: &ssooidc.RegisterClientOutput{
+					ClientId:              aws.String("this-is-my-new-client-id"),
+					ClientSecret:          aws.String("this-is-my-client-secret"),
+					ClientIdIssuedAt:      time.Now().Unix(),
+					ClientSecretExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+				},
+				Error: nil,
+			},
+		},
+	}
+	assert.NoError(t, as.registerClient(false))
+	assert.Equal(t, "this-is-my-new-client-id", as.ClientData.ClientId)
+	assert.True(t, as.ClientData.HasScope(awsSSOScope))
+}
+
 func TestReauthenticate(t *testing.T) {
 	tfile, err := os.CreateTemp("", "*storage.json")
 	assert.NoError(t, err)
@@ -531,6 +658,67 @@ func TestReauthenticate(t *testing.T) {
 	assert.Contains(t, err.Error(), "Unable to exec")
 }
 
+func TestReauthenticateOtherProcess(t *testing.T) {
+	dir, err := os.MkdirTemp("", "reauth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	storeFile := filepath.Join(dir, "store.json")
+	jstore, err := storage.OpenJsonStore(storeFile)
+	assert.NoError(t, err)
+
+	as := &AWSSSO{
+		key:       "Default",
+		SsoRegion: "us-west-1",
+		StartUrl:  "https://testing.awsapps.com/start",
+		store:     jstore,
+		SSOConfig: &SSOConfig{
+			settings: &Settings{
+				cacheFile: filepath.Join(dir, "cache.json"),
+			},
+		},
+		// any OIDC call fails the test
+		ssooidc: &mockSsoOidcAPI{},
+	}
+	assert.Equal(t, filepath.Join(dir, "auth-Default"), as.authLockFile())
+
+	// another aws-sso process is authenticating
+	lock, err := utils.LockFile(as.authLockFile())
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- as.Authenticate("print", "fake-browser")
+	}()
+
+	// and saves its new token before releasing the lock
+	time.Sleep(100 * time.Millisecond)
+	other, err := storage.OpenJsonStore(storeFile)
+	assert.NoError(t, err)
+	token := storage.CreateTokenResponse{
+		AccessToken: "other-access-token",
+		ExpiresIn:   3600,
+		ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+	}
+	assert.NoError(t, other.SaveCreateTokenResponse(as.StoreKey(), token))
+	assert.NoError(t, lock.Unlock())
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, "other-access-token", as.Token.AccessToken)
+
+	// a rejected token is never re-used
+	as.ssooidc = &mockSsoOidcAPI{
+		Results: []mockSsoOidcAPIResults{
+			{
+				RegisterClient: &ssooidc.RegisterClientOutput{},
+				Error:          fmt.Errorf("register failed"),
+			},
+		},
+	}
+	assert.ErrorContains(t, as.reauthenticate(), "register failed")
+	assert.Equal(t, "other-access-token", as.Token.AccessToken)
+}
+
 func TestLogout(t *testing.T) {
 	tfile, err := os.CreateTemp("", "*storage.json")
 	assert.NoError(t, err)
@@ -0,0 +1,173 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/fakeaws"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+	"github.com/synfinatic/aws-sso-cli/internal/url"
+)
+
+// newFakeAWSSSO returns an AWSSSO using the real AWS SDK clients talking to fakeaws
+func newFakeAWSSSO(t *testing.T, authFlow string) (*AWSSSO, *fakeaws.Server) {
+	fake := fakeaws.New()
+	t.Cleanup(fake.Close)
+	t.Setenv(ENV_ENDPOINT_URL, fake.URL)
+
+	fake.AddAccount("000000000001", "Dev", "dev@example.com", "Admin", "ReadOnly", "Billing")
+	fake.AddAccount("000000000002", "Prod", "prod@example.com", "ReadOnly")
+	fake.AddAccount("000000000003", "Audit", "audit@example.com", "Auditor")
+	fake.SetPageSize(2)
+
+	store, err := storage.OpenJsonStore(filepath.Join(t.TempDir(), "store.json"))
+	assert.NoError(t, err)
+	var secureStore storage.SecureStorage = store
+
+	c := &SSOConfig{
+		key:       "Default",
+		settings:  &Settings{UrlAction: url.PrintUrl},
+		StartUrl:  "https://testing.awsapps.com/start",
+		SSORegion: "us-east-1",
+		AuthFlow:  authFlow,
+		Accounts: map[string]*SSOAccount{
+			"000000000002": {
+				Roles: map[string]*SSORole{
+					"Chained": {
+						Via:            "arn:aws:iam::000000000001:role/Admin",
+						SourceIdentity: "tester",
+					},
+				},
+			},
+		},
+		MaxRetry:   3,
+		MaxBackoff: 1,
+	}
+	as, err := NewAWSSSO(c, &secureStore)
+	assert.NoError(t, err)
+	return as, fake
+}
+
+func TestFakeAWSDeviceCode(t *testing.T) {
+	as, fake := newFakeAWSSSO(t, AUTH_FLOW_DEVICE_CODE)
+	fake.SetPendingPolls(1)
+
+	assert.NoError(t, as.Authenticate(url.Undef, ""))
+	assert.NotEmpty(t, as.Token.AccessToken)
+	assert.NotEmpty(t, as.Token.RefreshToken)
+	assert.Equal(t, 1, fake.Calls(fakeaws.OP_REGISTER_CLIENT))
+	assert.Equal(t, 1, fake.Calls(fakeaws.OP_START_DEVICE_AUTHORIZATION))
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_CREATE_TOKEN))
+
+	// accounts & roles are paginated
+	accounts, err := as.GetAccounts()
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 3)
+	assert.Equal(t, "Audit", accounts[2].AccountName)
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_LIST_ACCOUNTS))
+
+	roles, err := as.GetRoles(accounts[0])
+	assert.NoError(t, err)
+	assert.Len(t, roles, 3)
+	assert.Equal(t, "arn:aws:iam::000000000001:role/Billing", roles[2].Arn)
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_LIST_ACCOUNT_ROLES))
+
+	// throttling is retried by the SDK
+	fake.Throttle(fakeaws.OP_GET_ROLE_CREDENTIALS, 1)
+	creds, err := as.GetRoleCredentials(1, "Admin")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, creds.AccessKeyId)
+	assert.False(t, creds.Expired())
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_GET_ROLE_CREDENTIALS))
+
+	_, err = as.GetRoleCredentials(2, "Admin")
+	assert.Error(t, err)
+
+	// role chaining uses STS
+	creds, err = as.GetRoleCredentials(2, "Chained")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::000000000002:role/Chained", creds.RoleArn())
+	reqs := fake.Requests(fakeaws.OP_ASSUME_ROLE)
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "arn:aws:iam::000000000002:role/Chained", reqs[0].Form.Get("RoleArn"))
+	assert.Equal(t, "Admin@000000000001", reqs[0].Form.Get("RoleSessionName"))
+	assert.Equal(t, "tester", reqs[0].Form.Get("SourceIdentity"))
+
+	// expired tokens are mapped to the SDK error
+	fake.ExpireTokens()
+	_, err = as.GetRoleCredentials(1, "Admin")
+	var ue *ssotypes.UnauthorizedException
+	assert.True(t, errors.As(err, &ue))
+
+	// and silently refreshed
+	as.Token.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	assert.NoError(t, as.store.SaveCreateTokenResponse(as.StoreKey(), as.Token))
+	assert.NoError(t, as.Authenticate(url.Undef, ""))
+	assert.Equal(t, 3, fake.Calls(fakeaws.OP_CREATE_TOKEN))
+	_, err = as.GetRoleCredentials(1, "Admin")
+	assert.NoError(t, err)
+
+	assert.NoError(t, as.Logout())
+	_, err = as.GetRoleCredentials(1, "Admin")
+	assert.Error(t, err)
+}
+
+func TestFakeAWSAuthFailures(t *testing.T) {
+	as, fake := newFakeAWSSSO(t, AUTH_FLOW_DEVICE_CODE)
+
+	fake.DenyDeviceAuth(true)
+	assert.ErrorContains(t, as.reauthenticate(), "AccessDeniedException")
+
+	fake.DenyDeviceAuth(false)
+	fake.Fail(fakeaws.OP_START_DEVICE_AUTHORIZATION, http.StatusUnauthorized, "InvalidClientException", "Invalid client", 1)
+	assert.NoError(t, as.reauthenticate())
+	// cached client registration was rejected, so we registered again
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_REGISTER_CLIENT))
+
+	fake.RevokeRefreshTokens()
+	assert.ErrorContains(t, as.refreshToken(as.Token.RefreshToken), "InvalidGrantException")
+}
+
+func TestFakeAWSAuthCode(t *testing.T) {
+	as, fake := newFakeAWSSSO(t, AUTH_FLOW_AUTH_CODE)
+
+	assert.NoError(t, as.registerClient(false))
+	err := as.authorizeAuthCode(func(authUrl string) error {
+		// the fake approves & redirects to our loopback listener
+		resp, err := http.Get(authUrl) // #nosec
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}, 5*time.Second)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, as.Token.AccessToken)
+	assert.Equal(t, 1, fake.Calls(fakeaws.OP_AUTHORIZE))
+
+	accounts, err := as.GetAccounts()
+	assert.NoError(t, err)
+	assert.Len(t, accounts, 3)
+}
@@ -102,8 +102,8 @@ func TestNewAWSSSO(t *testing.T) {
 		MaxBackoff: 1,
 	}
 
-	s := NewAWSSSO(&c, &jstore)
-
+	s, err := NewAWSSSO(&c, &jstore)
+	assert.NoError(t, err)
 	assert.NotNil(t, s)
 	assert.Equal(t, jstore, s.store)
 	assert.Equal(t, awsSSOClientName, s.ClientName)
@@ -112,6 +112,15 @@ func TestNewAWSSSO(t *testing.T) {
 	assert.Equal(t, c.StartUrl, s.StartUrl)
 	assert.Empty(t, s.Roles)
 	assert.Equal(t, &c, s.SSOConfig)
+
+	bad := SSOConfig{
+		StartUrl:  "https://starturl.com/start",
+		SSORegion: "us-east-1",
+		settings:  &Settings{},
+		CABundle:  "/does/not/exist",
+	}
+	_, err = NewAWSSSO(&bad, &jstore)
+	assert.ErrorContains(t, err, "Unable to configure HTTP client")
 }
 
 func TestRoleARN(t *testing.T) {
@@ -20,10 +20,12 @@ package sso
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	// "github.com/davecgh/go-spew/spew"
@@ -37,20 +39,25 @@ const (
 )
 
 type SSOCache struct {
-	LastUpdate int64    `json:"LastUpdate,omitempty"` // when these records for this SSO were updated
-	History    []string `json:"History,omitempty"`
-	Roles      *Roles   `json:"Roles,omitempty"`
-	name       string   // name of this SSO Instance
+	LastUpdate int64                     `json:"LastUpdate,omitempty"` // when these records for this SSO were updated
+	History    []string                  `json:"History,omitempty"`
+	Roles      *Roles                    `json:"Roles,omitempty"`
+	Accounts   map[string]*CachedAccount `json:"Accounts,omitempty"` // AWS SSO accounts by AccountId
+	name       string                    // name of this SSO Instance
 }
 
 // Our Cachefile.  Sub-structs defined in sso/cache.go
 type Cache struct {
-	Version         int64                `json:"Version"`
-	settings        *Settings            // pointer back up
-	ConfigCreatedAt int64                `json:"ConfigCreatedAt"` // track config.yaml
-	SSO             map[string]*SSOCache `json:"SSO,omitempty"`
-	ssoName         string               // name of SSO that is active
-	refreshed       bool                 // track if we have run Refresh() since this is expensive
+	Version         int64                      `json:"Version"`
+	settings        *Settings                  // pointer back up
+	ConfigCreatedAt int64                      `json:"ConfigCreatedAt"` // track config.yaml
+	SSO             map[string]*SSOCache       `json:"SSO,omitempty"`
+	LastGC          int64                      `json:"LastGC,omitempty"` // when we last garbage collected the SecureStore
+	ssoName         string                     // name of SSO that is active
+	refreshed       map[string]bool            // track if we have run Refresh() since this is expensive
+	summaries       map[string]*RefreshSummary // what changed during Refresh()
+	fullRefresh     bool                       // query the roles of every account during Refresh()
+	lock            sync.Mutex                 // protects SSO & refreshed during RefreshAll()
 }
 
 func OpenCache(f string, s *Settings) (*Cache, error) {
@@ -63,13 +70,13 @@ func OpenCache(f string, s *Settings) (*Cache, error) {
 	}
 
 	var err error
-	var cacheBytes []byte
 	if f != "" {
-		cacheBytes, err = os.ReadFile(f)
-		if err != nil {
+		err = utils.ReadLockedFile(f, func(cacheBytes []byte) error {
+			return json.Unmarshal(cacheBytes, &cache)
+		})
+		if errors.Is(err, os.ErrNotExist) {
 			return &cache, err // return empty struct
 		}
-		err = json.Unmarshal(cacheBytes, &cache)
 	}
 
 	c := &cache
@@ -80,23 +87,28 @@ func OpenCache(f string, s *Settings) (*Cache, error) {
 
 // GetSSO returns the current SSOCache object for the current SSO instance
 func (c *Cache) GetSSO() *SSOCache {
-	if v, ok := c.SSO[c.ssoName]; ok {
-		v.name = c.ssoName
-		v.Roles.ssoName = c.ssoName
+	return c.getSSO(c.ssoName)
+}
+
+// getSSO returns the SSOCache object for the given SSO instance
+func (c *Cache) getSSO(ssoName string) *SSOCache {
+	if v, ok := c.SSO[ssoName]; ok {
+		v.name = ssoName
+		v.Roles.ssoName = ssoName
 		return v
 	}
 
 	// else, init a new one
-	c.SSO[c.ssoName] = &SSOCache{
-		name:       c.ssoName,
+	c.SSO[ssoName] = &SSOCache{
+		name:       ssoName,
 		LastUpdate: 0,
 		History:    []string{},
 		Roles: &Roles{
 			Accounts: map[int64]*AWSAccount{},
-			ssoName:  c.ssoName,
+			ssoName:  ssoName,
 		},
 	}
-	return c.SSO[c.ssoName]
+	return c.SSO[ssoName]
 }
 
 // Expired returns if our Roles cache data is too old.
@@ -143,7 +155,8 @@ func (c *Cache) Save(updateTime bool) error {
 	if err != nil {
 		return fmt.Errorf("Unable to create directory for %s: %s", c.CacheFile(), err.Error())
 	}
-	err = os.WriteFile(c.CacheFile(), jbytes, 0600)
+	// other aws-sso processes may be reading or writing the cache
+	err = utils.WriteLockedFile(c.CacheFile(), jbytes, 0600)
 	if err != nil {
 		return fmt.Errorf("Unable to write %s: %s", c.CacheFile(), err.Error())
 	}
@@ -272,16 +285,22 @@ func (c *Cache) deleteOldHistory() {
 // Refresh updates our cached Roles based on AWS SSO & our Config
 // but does not save this data!
 func (c *Cache) Refresh(sso *AWSSSO, config *SSOConfig, ssoName string) error {
+	c.lock.Lock()
 	// Only refresh once per execution
-	if c.refreshed {
+	if c.refreshed == nil {
+		c.refreshed = map[string]bool{}
+	}
+	if c.refreshed[ssoName] {
+		c.lock.Unlock()
 		return nil
 	}
-	c.refreshed = true
+	c.refreshed[ssoName] = true
 	log.Debugf("refreshing %s SSO cache", ssoName)
 
-	// save role creds expires time
+	// save role creds expires time & existing History tags
 	expires := map[string]int64{}
-	cache := c.GetSSO()
+	historyTags := map[string]string{}
+	cache := c.getSSO(ssoName)
 	for _, account := range cache.Roles.Accounts {
 		for _, role := range account.Roles {
 			if role.Expires > 0 {
@@ -289,11 +308,12 @@ func (c *Cache) Refresh(sso *AWSSSO, config *SSOConfig, ssoName string) error {
 			}
 		}
 	}
-
-	// save existing History tags
-	historyTags := map[string]string{}
-	for _, arn := range c.SSO[ssoName].History {
-		roleFlat, err := c.GetRole(arn)
+	for _, arn := range cache.History {
+		accountId, roleName, err := utils.ParseRoleARN(arn)
+		if err != nil {
+			continue
+		}
+		roleFlat, err := cache.Roles.GetRole(accountId, roleName)
 		if err != nil {
 			continue
 		}
@@ -301,19 +321,27 @@ func (c *Cache) Refresh(sso *AWSSSO, config *SSOConfig, ssoName string) error {
 			historyTags[arn] = value
 		}
 	}
+	c.lock.Unlock()
 
-	// zero out our current roles cache entries so they don't get merged
-	c.SSO[ssoName].Roles = &Roles{}
-
-	// load our AWSSSO & Config
-	r, err := c.NewRoles(sso, config)
+	// load our AWSSSO & Config into a new Roles so they don't get merged
+	refresh := newAccountsRefresh(cache)
+	r, err := c.newRoles(sso, config, refresh, ssoName)
 	if err != nil {
 		return err
 	}
-	c.SSO[ssoName].Roles = r
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cache.Roles = r
+	cache.Accounts = refresh.accounts
+	if c.summaries == nil {
+		c.summaries = map[string]*RefreshSummary{}
+	}
+	c.summaries[ssoName] = refresh.summary
+	log.Debugf("%s: %s", ssoName, refresh.summary.String())
 
 	// restore our history tags & expires
-	for _, account := range c.SSO[ssoName].Roles.Accounts {
+	for _, account := range cache.Roles.Accounts {
 		for _, role := range account.Roles {
 			if value, ok := historyTags[role.Arn]; ok {
 				role.Tags["History"] = value
@@ -327,6 +355,50 @@ func (c *Cache) Refresh(sso *AWSSSO, config *SSOConfig, ssoName string) error {
 	return nil
 }
 
+// SetFullRefresh forces Refresh() to query the roles of every account instead
+// of only those which are new, changed or stale
+func (c *Cache) SetFullRefresh(full bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fullRefresh = full
+}
+
+// GetRefreshSummary returns what changed the last time Refresh() updated
+// the given SSO instance or nil if it has not been refreshed
+func (c *Cache) GetRefreshSummary(ssoName string) *RefreshSummary {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.summaries[ssoName]
+}
+
+// RefreshAll refreshes the cached Roles of each of the authenticated
+// SSO instances in parallel and updates their LastUpdate time.  Returns
+// the error, if any, for each SSO instance by name.  Like Refresh(), this
+// does not save the cache!
+func (c *Cache) RefreshAll(instances map[string]*AWSSSO) map[string]error {
+	results := map[string]error{}
+	var resultsLock sync.Mutex
+	var wg sync.WaitGroup
+
+	for ssoName, as := range instances {
+		wg.Add(1)
+		go func(ssoName string, as *AWSSSO) {
+			defer wg.Done()
+			err := c.Refresh(as, as.SSOConfig, ssoName)
+			if err == nil {
+				c.lock.Lock()
+				c.SSO[ssoName].LastUpdate = time.Now().Unix()
+				c.lock.Unlock()
+			}
+			resultsLock.Lock()
+			results[ssoName] = err
+			resultsLock.Unlock()
+		}(ssoName, as)
+	}
+	wg.Wait()
+	return results
+}
+
 // pruneSSO removes any SSO instances that are no longer configured
 func (c *Cache) PruneSSO(settings *Settings) {
 	log.Debugf("pruning our cache of outdated SSO instances")
@@ -419,15 +491,21 @@ func (c *Cache) GetRole(arn string) (*AWSRoleFlat, error) {
 // Merges the AWS SSO and our Config file to create our Roles struct
 // which is defined in cache_roles.go
 func (c *Cache) NewRoles(as *AWSSSO, config *SSOConfig) (*Roles, error) {
+	return c.newRoles(as, config, newAccountsRefresh(c.GetSSO()), config.settings.DefaultSSO)
+}
+
+// newRoles creates the Roles struct for the named SSO instance, only
+// querying AWS SSO for the accounts which are not up to date in our cache
+func (c *Cache) newRoles(as *AWSSSO, config *SSOConfig, refresh *accountsRefresh, ssoName string) (*Roles, error) {
 	r := Roles{
 		SSORegion:     config.SSORegion,
 		StartUrl:      config.StartUrl,
 		DefaultRegion: config.DefaultRegion,
 		Accounts:      map[int64]*AWSAccount{},
-		ssoName:       config.settings.DefaultSSO,
+		ssoName:       ssoName,
 	}
 
-	if err := c.addSSORoles(&r, as); err != nil {
+	if err := c.addSSORoles(&r, as, refresh); err != nil {
 		return &Roles{}, err
 	}
 
@@ -442,23 +520,6 @@ func (c *Cache) NewRoles(as *AWSSSO, config *SSOConfig) (*Roles, error) {
 	return &r, nil
 }
 
-// goroutine worker to fetch the RoleInfo for the given account
-func fetchSSORole(id int, as *AWSSSO, aInfo <-chan AccountInfo, rInfo chan<- []RoleInfo) {
-	for {
-		a := <-aInfo
-		if a.AccountId == "" {
-			// need some way to exit our worker...
-			break
-		}
-		log.Debugf("Worker %d processing AccountId: %s", id, a.AccountId)
-		roles, err := as.GetRoles(a)
-		if err != nil {
-			log.Panicf("Unable to get AWS SSO roles: %s", err.Error())
-		}
-		rInfo <- roles
-	}
-}
-
 // processSSORoles updates the *Roles with ith the list of RoleInfo
 // and using our SSOCache
 func processSSORoles(roles []RoleInfo, cache *SSOCache, r *Roles) {
@@ -498,68 +559,6 @@ func processSSORoles(roles []RoleInfo, cache *SSOCache, r *Roles) {
 	}
 }
 
-// addSSORoles retrieves all the SSO Roles from AWS SSO and places them in r
-func (c *Cache) addSSORoles(r *Roles, as *AWSSSO) error {
-	cache := c.GetSSO()
-
-	accounts, err := as.GetAccounts()
-	if err != nil {
-		return fmt.Errorf("Unable to get AWS SSO accounts: %s", err.Error())
-	}
-
-	// Our first query must NOT be part of the worker pool so our AccessToken
-	// can be updated
-	firstJob, accounts := accounts[0], accounts[1:]
-	roles, err := as.GetRoles(firstJob)
-	if err != nil {
-		return err
-	}
-	processSSORoles(roles, cache, r)
-
-	// Per #448, doing this serially is too slow for many accounts.  Hence,
-	// we'll use a worker pool.
-	if len(accounts) > 0 {
-		workers := 1
-		if c.settings.Threads > 0 {
-			workers = c.settings.Threads
-		}
-		if workers > len(accounts) {
-			workers = len(accounts)
-		}
-
-		tasks := make(chan AccountInfo, len(accounts))
-		results := make(chan []RoleInfo, len(accounts))
-
-		// feed our workers with our other accounts
-		for _, aInfo := range accounts {
-			tasks <- aInfo
-		}
-		close(tasks)
-
-		// start our workers...
-		for w := 1; w <= workers; w++ {
-			go fetchSSORole(w, as, tasks, results)
-		}
-
-		// Notify
-		ticker := time.NewTicker(SLOW_FETCH_SECONDS * time.Second)
-
-		for count := 0; count < len(accounts); {
-			select {
-			case roles := <-results:
-				processSSORoles(roles, cache, r)
-				count++ // increment count only when processing results
-				log.Debugf("proccessed %d accounts, added %d roles, total %d", count, len(roles), len(r.GetAllRoles()))
-			case <-ticker.C:
-				log.Warnf("Fetching roles for %d accounts, this might take a while...\n", len(accounts)+1)
-				ticker.Stop()
-			}
-		}
-		close(results)
-	}
-	return nil
-}
-
 // addConfigRoles decorates the provided Roles with the contents of our config
 func (c *Cache) addConfigRoles(r *Roles, config *SSOConfig) error {
 	// The load all the Config file stuff.  Normally this is just adding markup, but
@@ -0,0 +1,294 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+// CachedAccount is what AWS SSO returned for an account the last time we
+// called ListAccountRoles so we can incrementally refresh our Roles
+type CachedAccount struct {
+	AccountName  string   `json:"AccountName,omitempty"`
+	EmailAddress string   `json:"EmailAddress,omitempty"`
+	Roles        []string `json:"Roles,omitempty"`
+	LastUpdate   int64    `json:"LastUpdate"` // when we last called ListAccountRoles
+}
+
+// changed returns true if the account returned by ListAccounts differs
+// from our cache
+func (ca *CachedAccount) changed(a AccountInfo) bool {
+	return ca.AccountName != a.AccountName || ca.EmailAddress != a.EmailAddress
+}
+
+// roleInfo returns the RoleInfo for each of our cached roles
+func (ca *CachedAccount) roleInfo(a AccountInfo) []RoleInfo {
+	roles := []RoleInfo{}
+	for i, roleName := range ca.Roles {
+		roles = append(roles, RoleInfo{
+			Id:           i,
+			RoleName:     roleName,
+			AccountId:    a.AccountId,
+			AccountName:  a.AccountName,
+			EmailAddress: a.EmailAddress,
+		})
+	}
+	return roles
+}
+
+// RefreshSummary describes what changed during a cache refresh of a
+// single SSO instance
+type RefreshSummary struct {
+	AddedAccounts   []string // AccountIds
+	RemovedAccounts []string // AccountIds
+	AddedRoles      []string // Role ARNs
+	RemovedRoles    []string // Role ARNs
+	Fetched         int      // number of accounts we called ListAccountRoles for
+	Reused          int      // number of accounts using our cached roles
+}
+
+// Changed returns true if any accounts or roles were added or removed
+func (rs *RefreshSummary) Changed() bool {
+	return len(rs.AddedAccounts)+len(rs.RemovedAccounts)+len(rs.AddedRoles)+len(rs.RemovedRoles) > 0
+}
+
+func (rs *RefreshSummary) String() string {
+	return fmt.Sprintf("%d accounts added, %d accounts removed, %d roles added, %d roles removed",
+		len(rs.AddedAccounts), len(rs.RemovedAccounts), len(rs.AddedRoles), len(rs.RemovedRoles))
+}
+
+// sort sorts all of our lists for consistent output
+func (rs *RefreshSummary) sort() {
+	sort.Strings(rs.AddedAccounts)
+	sort.Strings(rs.RemovedAccounts)
+	sort.Strings(rs.AddedRoles)
+	sort.Strings(rs.RemovedRoles)
+}
+
+// accountsRefresh tracks the state of an incremental refresh of the AWS SSO
+// accounts & roles for a single SSO instance
+type accountsRefresh struct {
+	cache    *SSOCache                 // our previous cache
+	accounts map[string]*CachedAccount // our new CachedAccounts by AccountId
+	summary  *RefreshSummary
+	now      int64
+}
+
+func newAccountsRefresh(cache *SSOCache) *accountsRefresh {
+	return &accountsRefresh{
+		cache:    cache,
+		accounts: map[string]*CachedAccount{},
+		summary:  &RefreshSummary{},
+		now:      time.Now().Unix(),
+	}
+}
+
+// accountRoles is the result of calling ListAccountRoles for an account
+type accountRoles struct {
+	account AccountInfo
+	roles   []RoleInfo
+	err     error
+}
+
+// reuse adds the cached roles for the account to r
+func (ar *accountsRefresh) reuse(a AccountInfo, r *Roles) {
+	cached := ar.cache.Accounts[a.AccountId]
+	processSSORoles(cached.roleInfo(a), ar.cache, r)
+	ar.accounts[a.AccountId] = cached
+	ar.summary.Reused++
+}
+
+// update adds the roles we fetched for the account to r.  Errors are only
+// fatal if we have no cached roles we can fall back on.
+func (ar *accountsRefresh) update(result accountRoles, verify bool, r *Roles) error {
+	a := result.account
+	if result.err != nil {
+		if verify {
+			log.WithError(result.err).Warnf("Unable to verify roles for %s, using cached roles", a.AccountId)
+			ar.reuse(a, r)
+			return nil
+		}
+		return fmt.Errorf("Unable to get AWS SSO roles for %s: %s", a.AccountId, result.err.Error())
+	}
+
+	oldRoles := map[string]bool{}
+	if cached, ok := ar.cache.Accounts[a.AccountId]; ok {
+		for _, roleName := range cached.Roles {
+			oldRoles[roleName] = true
+		}
+	}
+
+	accountId := a.GetAccountId64()
+	names := []string{}
+	for _, role := range result.roles {
+		names = append(names, role.RoleName)
+		if !oldRoles[role.RoleName] {
+			ar.summary.AddedRoles = append(ar.summary.AddedRoles, utils.MakeRoleARN(accountId, role.RoleName))
+		}
+		delete(oldRoles, role.RoleName)
+	}
+	for roleName := range oldRoles {
+		ar.summary.RemovedRoles = append(ar.summary.RemovedRoles, utils.MakeRoleARN(accountId, roleName))
+	}
+	sort.Strings(names)
+
+	ar.accounts[a.AccountId] = &CachedAccount{
+		AccountName:  a.AccountName,
+		EmailAddress: a.EmailAddress,
+		Roles:        names,
+		LastUpdate:   ar.now,
+	}
+	processSSORoles(result.roles, ar.cache, r)
+	ar.summary.Fetched++
+	return nil
+}
+
+// stale returns true if we should re-verify the roles of an unchanged account
+func (c *Cache) stale(cached *CachedAccount, now int64) bool {
+	if c.settings.CacheAccountRefresh < 0 {
+		return false
+	}
+	ttl := c.settings.CacheAccountRefresh * 60 * 60 // convert hours to seconds
+	return cached.LastUpdate+ttl <= now
+}
+
+// goroutine worker to fetch the RoleInfo for the given account
+func fetchSSORole(id int, as *AWSSSO, aInfo <-chan AccountInfo, rInfo chan<- accountRoles) {
+	for a := range aInfo {
+		log.Debugf("Worker %d processing AccountId: %s", id, a.AccountId)
+		roles, err := as.GetRoles(a)
+		rInfo <- accountRoles{
+			account: a,
+			roles:   roles,
+			err:     err,
+		}
+	}
+}
+
+// addSSORoles retrieves the SSO Roles from AWS SSO and places them in r.
+// Only accounts which are new, changed or stale are queried via
+// ListAccountRoles, all others use the roles in our cache unless
+// SetFullRefresh() was called.
+func (c *Cache) addSSORoles(r *Roles, as *AWSSSO, refresh *accountsRefresh) error {
+	accounts, err := as.GetAccounts()
+	if err != nil {
+		return fmt.Errorf("Unable to get AWS SSO accounts: %s", err.Error())
+	}
+
+	fetch := []AccountInfo{}    // accounts we need to query
+	verify := map[string]bool{} // unchanged, but stale accounts
+	current := map[string]bool{}
+	for _, a := range accounts {
+		current[a.AccountId] = true
+		cached, ok := refresh.cache.Accounts[a.AccountId]
+		switch {
+		case !ok:
+			refresh.summary.AddedAccounts = append(refresh.summary.AddedAccounts, a.AccountId)
+			fetch = append(fetch, a)
+		case cached.changed(a), c.fullRefresh:
+			fetch = append(fetch, a)
+		case c.stale(cached, refresh.now):
+			verify[a.AccountId] = true
+			fetch = append(fetch, a)
+		default:
+			refresh.reuse(a, r)
+		}
+	}
+
+	for accountId, cached := range refresh.cache.Accounts {
+		if current[accountId] {
+			continue
+		}
+		refresh.summary.RemovedAccounts = append(refresh.summary.RemovedAccounts, accountId)
+		id, _ := utils.AccountIdToInt64(accountId)
+		for _, roleName := range cached.Roles {
+			refresh.summary.RemovedRoles = append(refresh.summary.RemovedRoles, utils.MakeRoleARN(id, roleName))
+		}
+	}
+	defer refresh.summary.sort()
+
+	if len(fetch) == 0 {
+		return nil
+	}
+	log.Debugf("fetching roles for %d of %d accounts", len(fetch), len(accounts))
+
+	// Our first query must NOT be part of the worker pool so our AccessToken
+	// can be updated
+	firstJob, fetch := fetch[0], fetch[1:]
+	roles, err := as.GetRoles(firstJob)
+	result := accountRoles{account: firstJob, roles: roles, err: err}
+	if err = refresh.update(result, verify[firstJob.AccountId], r); err != nil {
+		return err
+	}
+
+	// Per #448, doing this serially is too slow for many accounts.  Hence,
+	// we'll use a worker pool.
+	if len(fetch) > 0 {
+		workers := 1
+		if c.settings.Threads > 0 {
+			workers = c.settings.Threads
+		}
+		if workers > len(fetch) {
+			workers = len(fetch)
+		}
+
+		tasks := make(chan AccountInfo, len(fetch))
+		results := make(chan accountRoles, len(fetch))
+
+		// feed our workers with our other accounts
+		for _, aInfo := range fetch {
+			tasks <- aInfo
+		}
+		close(tasks)
+
+		// start our workers...
+		for w := 1; w <= workers; w++ {
+			go fetchSSORole(w, as, tasks, results)
+		}
+
+		// Notify
+		ticker := time.NewTicker(SLOW_FETCH_SECONDS * time.Second)
+		defer ticker.Stop()
+
+		var fetchErr error
+		for count := 0; count < len(fetch); {
+			select {
+			case result := <-results:
+				// keep reading results so our workers can exit
+				if err := refresh.update(result, verify[result.account.AccountId], r); err != nil && fetchErr == nil {
+					fetchErr = err
+				}
+				count++ // increment count only when processing results
+				log.Debugf("proccessed %d accounts, added %d roles, total %d", count, len(result.roles), len(r.GetAllRoles()))
+			case <-ticker.C:
+				log.Warnf("Fetching roles for %d accounts, this might take a while...\n", len(fetch)+1)
+				ticker.Stop()
+			}
+		}
+		close(results)
+		if fetchErr != nil {
+			return fetchErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func listAccountsResult(accounts ...ssotypes.AccountInfo) mockSsoAPIResults {
+	return mockSsoAPIResults{
+		ListAccounts: &sso.ListAccountsOutput{AccountList: accounts},
+	}
+}
+
+func listAccountRolesResult(accountId string, roles ...string) mockSsoAPIResults {
+	list := []ssotypes.RoleInfo{}
+	for _, role := range roles {
+		list = append(list, ssotypes.RoleInfo{
+			AccountId: aws.String(accountId),
+			RoleName:  aws.String(role),
+		})
+	}
+	return mockSsoAPIResults{
+		ListAccountRoles: &sso.ListAccountRolesOutput{RoleList: list},
+	}
+}
+
+func accountInfo(accountId, name string) ssotypes.AccountInfo {
+	return ssotypes.AccountInfo{
+		AccountId:    aws.String(accountId),
+		AccountName:  aws.String(name),
+		EmailAddress: aws.String("test@example.com"),
+	}
+}
+
+func TestIncrementalRefresh(t *testing.T) {
+	f, err := os.CreateTemp("", "*config.yaml")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	settings := &Settings{
+		configFile:          f.Name(),
+		CacheAccountRefresh: 24,
+		DefaultSSO:          "Default",
+	}
+	now := time.Now().Unix()
+	c := &Cache{
+		settings: settings,
+		ssoName:  "Default",
+		SSO: map[string]*SSOCache{
+			"Default": {
+				History: []string{},
+				Roles:   &Roles{Accounts: map[int64]*AWSAccount{}},
+				Accounts: map[string]*CachedAccount{
+					"000001111111": {
+						AccountName:  "One",
+						EmailAddress: "test@example.com",
+						Roles:        []string{"Admin", "ReadOnly"},
+						LastUpdate:   now,
+					},
+					"000002222222": {
+						AccountName:  "Two",
+						EmailAddress: "test@example.com",
+						Roles:        []string{"Dev"},
+						LastUpdate:   now,
+					},
+					"000003333333": {
+						AccountName:  "Three",
+						EmailAddress: "test@example.com",
+						Roles:        []string{"Old"},
+						LastUpdate:   now,
+					},
+				},
+			},
+		},
+	}
+
+	as := &AWSSSO{
+		Roles:     map[string][]RoleInfo{},
+		SSOConfig: &SSOConfig{settings: settings},
+		sso: &mockSsoAPI{
+			Results: []mockSsoAPIResults{
+				listAccountsResult(
+					accountInfo("000001111111", "One"),         // unchanged
+					accountInfo("000002222222", "Two-Renamed"), // changed
+					accountInfo("000004444444", "Four"),        // new
+				),
+				listAccountRolesResult("000002222222", "Dev", "Ops"),
+				listAccountRolesResult("000004444444", "Admin"),
+			},
+		},
+	}
+
+	assert.NoError(t, c.Refresh(as, as.SSOConfig, "Default"))
+	summary := c.GetRefreshSummary("Default")
+	assert.NotNil(t, summary)
+	assert.True(t, summary.Changed())
+	assert.Equal(t, 2, summary.Fetched)
+	assert.Equal(t, 1, summary.Reused)
+	assert.Equal(t, []string{"000004444444"}, summary.AddedAccounts)
+	assert.Equal(t, []string{"000003333333"}, summary.RemovedAccounts)
+	assert.Equal(t, []string{
+		"arn:aws:iam::000002222222:role/Ops",
+		"arn:aws:iam::000004444444:role/Admin",
+	}, summary.AddedRoles)
+	assert.Equal(t, []string{"arn:aws:iam::000003333333:role/Old"}, summary.RemovedRoles)
+	assert.Equal(t, "1 accounts added, 1 accounts removed, 2 roles added, 1 roles removed", summary.String())
+
+	// cached roles are reused for the unchanged account
+	cache := c.GetSSO()
+	for _, role := range []string{"Admin", "ReadOnly"} {
+		flat, err := cache.Roles.GetRole(1111111, role)
+		assert.NoError(t, err)
+		assert.Equal(t, "One", flat.AccountAlias)
+	}
+	_, err = cache.Roles.GetRole(2222222, "Ops")
+	assert.NoError(t, err)
+	_, err = cache.Roles.GetRole(3333333, "Old")
+	assert.Error(t, err)
+
+	assert.Len(t, cache.Accounts, 3)
+	assert.Equal(t, "Two-Renamed", cache.Accounts["000002222222"].AccountName)
+	assert.Equal(t, []string{"Dev", "Ops"}, cache.Accounts["000002222222"].Roles)
+	assert.NotContains(t, cache.Accounts, "000003333333")
+
+	// stale accounts are re-verified and keep their cached roles on error
+	cache.Accounts["000001111111"].LastUpdate = now - 25*60*60
+	c.refreshed = nil
+	as = &AWSSSO{
+		Roles:     map[string][]RoleInfo{},
+		SSOConfig: &SSOConfig{settings: settings},
+		sso: &mockSsoAPI{
+			Results: []mockSsoAPIResults{
+				listAccountsResult(
+					accountInfo("000001111111", "One"),
+					accountInfo("000002222222", "Two-Renamed"),
+					accountInfo("000004444444", "Four"),
+				),
+			},
+		},
+	}
+	assert.NoError(t, c.Refresh(as, as.SSOConfig, "Default"))
+	summary = c.GetRefreshSummary("Default")
+	assert.False(t, summary.Changed())
+	assert.Equal(t, 0, summary.Fetched)
+	assert.Equal(t, 3, summary.Reused)
+	_, err = c.GetSSO().Roles.GetRole(1111111, "ReadOnly")
+	assert.NoError(t, err)
+
+	// a full refresh queries every account, even if it is unchanged
+	c.refreshed = nil
+	c.SetFullRefresh(true)
+	as = &AWSSSO{
+		Roles:     map[string][]RoleInfo{},
+		SSOConfig: &SSOConfig{settings: settings},
+		sso: &mockSsoAPI{
+			Results: []mockSsoAPIResults{
+				listAccountsResult(
+					accountInfo("000001111111", "One"),
+					accountInfo("000002222222", "Two-Renamed"),
+					accountInfo("000004444444", "Four"),
+				),
+				listAccountRolesResult("000001111111", "Admin", "ReadOnly", "NewPermSet"),
+				listAccountRolesResult("000002222222", "Dev", "Ops"),
+				listAccountRolesResult("000004444444", "Admin"),
+			},
+		},
+	}
+	assert.NoError(t, c.Refresh(as, as.SSOConfig, "Default"))
+	summary = c.GetRefreshSummary("Default")
+	assert.Equal(t, 3, summary.Fetched)
+	assert.Equal(t, 0, summary.Reused)
+	assert.Equal(t, []string{"arn:aws:iam::000001111111:role/NewPermSet"}, summary.AddedRoles)
+	_, err = c.GetSSO().Roles.GetRole(1111111, "NewPermSet")
+	assert.NoError(t, err)
+	c.SetFullRefresh(false)
+
+	// negative values disable re-verification
+	settings.CacheAccountRefresh = -1
+	assert.False(t, c.stale(&CachedAccount{LastUpdate: 0}, now))
+	settings.CacheAccountRefresh = 0
+	assert.True(t, c.stale(&CachedAccount{LastUpdate: now}, now))
+}
@@ -25,6 +25,9 @@ import (
 	"time"
 
 	// "github.com/davecgh/go-spew/spew"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
@@ -575,3 +578,84 @@ func TestOpenCacheFailure(t *testing.T) {
 	assert.Equal(t, int64(0), c.ConfigCreatedAt)
 	assert.Equal(t, int64(1), c.Version)
 }
+
+func TestRefreshAll(t *testing.T) {
+	f, err := os.CreateTemp("", "*config.yaml")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	settings := &Settings{
+		configFile:   f.Name(),
+		HistoryLimit: 1,
+		DefaultSSO:   "Prod",
+	}
+	c := &Cache{
+		settings: settings,
+		ssoName:  "Prod",
+		SSO:      map[string]*SSOCache{},
+	}
+
+	newAWSSSO := func(accountId, role string, fail bool) *AWSSSO {
+		results := []mockSsoAPIResults{
+			{
+				ListAccounts: &sso.ListAccountsOutput{
+					AccountList: []ssotypes.AccountInfo{
+						{
+							AccountId:    aws.String(accountId),
+							AccountName:  aws.String("Alias" + role),
+							EmailAddress: aws.String("test@example.com"),
+						},
+					},
+				},
+			},
+			{
+				ListAccountRoles: &sso.ListAccountRolesOutput{
+					RoleList: []ssotypes.RoleInfo{
+						{
+							AccountId: aws.String(accountId),
+							RoleName:  aws.String(role),
+						},
+					},
+				},
+			},
+		}
+		if fail {
+			results = []mockSsoAPIResults{}
+		}
+		return &AWSSSO{
+			sso:       &mockSsoAPI{Results: results},
+			Roles:     map[string][]RoleInfo{},
+			SSOConfig: &SSOConfig{settings: settings},
+		}
+	}
+
+	results := c.RefreshAll(map[string]*AWSSSO{
+		"Prod":    newAWSSSO("000001111111", "Admin", false),
+		"Sandbox": newAWSSSO("000002222222", "Dev", false),
+		"Broken":  newAWSSSO("000003333333", "Foo", true),
+	})
+	assert.Len(t, results, 3)
+	assert.NoError(t, results["Prod"])
+	assert.NoError(t, results["Sandbox"])
+	assert.ErrorContains(t, results["Broken"], "Unable to get AWS SSO accounts")
+
+	_, err = c.SSO["Prod"].Roles.GetRole(1111111, "Admin")
+	assert.NoError(t, err)
+	assert.NotZero(t, c.SSO["Prod"].LastUpdate)
+
+	flat, err := c.SSO["Sandbox"].Roles.GetRole(2222222, "Dev")
+	assert.NoError(t, err)
+	assert.Equal(t, "Sandbox", flat.SSO)
+	assert.NotZero(t, c.SSO["Sandbox"].LastUpdate)
+
+	assert.Zero(t, c.SSO["Broken"].LastUpdate)
+
+	// only refresh once per execution
+	results = c.RefreshAll(map[string]*AWSSSO{
+		"Prod": newAWSSSO("000001111111", "Other", false),
+	})
+	assert.NoError(t, results["Prod"])
+	_, err = c.SSO["Prod"].Roles.GetRole(1111111, "Other")
+	assert.Error(t, err)
+}
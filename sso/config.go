@@ -21,7 +21,9 @@ package sso
 import (
 	"fmt"
 	"strings"
+	"sync"
 
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/synfinatic/aws-sso-cli/internal/tags"
 	"github.com/synfinatic/aws-sso-cli/internal/url"
 	"github.com/synfinatic/aws-sso-cli/internal/utils"
@@ -37,6 +39,16 @@ type SSOConfig struct {
 
 	// overrides for this SSO Instance
 	AuthUrlAction url.Action `koanf:"AuthUrlAction" yaml:"AuthUrlAction,omitempty"`
+	AuthFlow      string     `koanf:"AuthFlow" yaml:"AuthFlow,omitempty"` // device-code or auth-code
+
+	// network overrides for this SSO Instance
+	SSOEndpoint  string                   `koanf:"SSOEndpoint" yaml:"SSOEndpoint,omitempty"`
+	OIDCEndpoint string                   `koanf:"OIDCEndpoint" yaml:"OIDCEndpoint,omitempty"`
+	STSEndpoint  string                   `koanf:"STSEndpoint" yaml:"STSEndpoint,omitempty"`
+	HttpsProxy   string                   `koanf:"HttpsProxy" yaml:"HttpsProxy,omitempty"`
+	CABundle     string                   `koanf:"CABundle" yaml:"CABundle,omitempty"`
+	httpClient   *awshttp.BuildableClient // built from HttpsProxy & CABundle
+	httpLock     sync.Mutex               // lock for httpClient
 
 	// passed to AWSSSO from our Settings
 	MaxBackoff int `koanf:"-" yaml:"-"`
@@ -60,6 +72,14 @@ type SSORole struct {
 	Via            string            `koanf:"Via" yaml:"Via,omitempty"`
 	ExternalId     string            `koanf:"ExternalId" yaml:"ExternalId,omitempty"`
 	SourceIdentity string            `koanf:"SourceIdentity" yaml:"SourceIdentity,omitempty"`
+
+	// sts:AssumeRole options for Via
+	Duration          int32             `koanf:"Duration" yaml:"Duration,omitempty"` // minutes
+	SessionTags       map[string]string `koanf:"SessionTags" yaml:"SessionTags,omitempty"`
+	TransitiveTagKeys []string          `koanf:"TransitiveTagKeys" yaml:"TransitiveTagKeys,omitempty"`
+	Policy            string            `koanf:"Policy" yaml:"Policy,omitempty"` // inline JSON session policy
+	PolicyArns        []string          `koanf:"PolicyArns" yaml:"PolicyArns,omitempty"`
+	MfaSerial         string            `koanf:"MfaSerial" yaml:"MfaSerial,omitempty"`
 }
 
 // Refresh should be called any time you load the SSOConfig into memory or add a role
@@ -82,6 +102,14 @@ func (c *SSOConfig) Refresh(s *Settings) {
 	c.settings = s
 }
 
+// GetAuthFlow returns the OIDC flow used to authenticate to this SSO instance
+func (c *SSOConfig) GetAuthFlow() string {
+	if c.AuthFlow == "" {
+		return AUTH_FLOW_DEVICE_CODE
+	}
+	return c.AuthFlow
+}
+
 // CreatedAt returns the Unix epoch seconds that this config file was created at
 func (c *SSOConfig) CreatedAt() int64 {
 	return c.settings.CreatedAt()
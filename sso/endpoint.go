@@ -0,0 +1,185 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+// ENV_ENDPOINT_URL replaces the AWS SSO, SSO OIDC, STS and console sign-in
+// endpoints of every SSO instance with a single URL.  Only meant for testing
+// against a fake AWS like internal/fakeaws.
+const ENV_ENDPOINT_URL = "AWS_SSO_ENDPOINT_URL"
+
+const (
+	SERVICE_SSO  = "sso"
+	SERVICE_OIDC = "oidc"
+	SERVICE_STS  = "sts"
+)
+
+// EndpointURL returns the URL which replaces all of the AWS endpoints or an
+// empty string to use the real ones
+func EndpointURL() string {
+	return os.Getenv(ENV_ENDPOINT_URL)
+}
+
+// Endpoint returns the URL to use for the given AWS service or an empty
+// string to use the default AWS endpoint
+func (c *SSOConfig) Endpoint(service string) string {
+	if endpoint := EndpointURL(); endpoint != "" {
+		return endpoint
+	}
+
+	switch service {
+	case SERVICE_SSO:
+		return c.SSOEndpoint
+	case SERVICE_OIDC:
+		return c.OIDCEndpoint
+	case SERVICE_STS:
+		return c.STSEndpoint
+	}
+	return ""
+}
+
+// HTTPClient returns the HTTP client used for every request to AWS for this
+// SSO instance which honors our HttpsProxy and CABundle
+func (c *SSOConfig) HTTPClient() (*awshttp.BuildableClient, error) {
+	c.httpLock.Lock()
+	defer c.httpLock.Unlock()
+
+	if c.httpClient != nil {
+		return c.httpClient, nil
+	}
+
+	var proxy *neturl.URL
+	var err error
+	if c.HttpsProxy != "" {
+		if proxy, err = parseEndpoint(c.HttpsProxy); err != nil {
+			return nil, fmt.Errorf("Invalid HttpsProxy: %s", err.Error())
+		}
+	}
+
+	var pool *x509.CertPool
+	if c.CABundle != "" {
+		if pool, err = loadCABundle(utils.GetHomePath(c.CABundle)); err != nil {
+			return nil, fmt.Errorf("Invalid CABundle: %s", err.Error())
+		}
+	}
+
+	// the AWS SDK needs a BuildableClient so it can add $AWS_CA_BUNDLE
+	c.httpClient = awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		if proxy != nil {
+			tr.Proxy = http.ProxyURL(proxy)
+		}
+		if pool != nil {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+			tr.TLSClientConfig.RootCAs = pool
+		}
+	})
+	return c.httpClient, nil
+}
+
+// STSClient returns an STS client for this SSO instance using the given credentials
+func (c *SSOConfig) STSClient(creds aws.CredentialsProvider) (*sts.Client, error) {
+	httpClient, err := c.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(c.SSORegion),
+		config.WithCredentialsProvider(creds),
+		config.WithHTTPClient(httpClient),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if endpoint := c.Endpoint(SERVICE_STS); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// validateNetwork returns an error if our endpoint, proxy or CA bundle
+// overrides are invalid
+func (c *SSOConfig) validateNetwork() error {
+	endpoints := map[string]string{
+		"SSOEndpoint":  c.SSOEndpoint,
+		"OIDCEndpoint": c.OIDCEndpoint,
+		"STSEndpoint":  c.STSEndpoint,
+	}
+	for name, endpoint := range endpoints {
+		if endpoint == "" {
+			continue
+		}
+		if _, err := parseEndpoint(endpoint); err != nil {
+			return fmt.Errorf("Invalid %s: %s", name, err.Error())
+		}
+	}
+
+	_, err := c.HTTPClient()
+	return err
+}
+
+// parseEndpoint returns the URL if it is an absolute http or https URL
+func parseEndpoint(endpoint string) (*neturl.URL, error) {
+	u, err := neturl.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s is not an http or https URL", endpoint)
+	}
+	return u, nil
+}
+
+// loadCABundle returns the system certificate pool with the PEM encoded
+// certificates in the given file added to it
+func loadCABundle(fileName string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.WithError(err).Debugf("Unable to load system certificates")
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No PEM certificates found in %s", fileName)
+	}
+	return pool, nil
+}
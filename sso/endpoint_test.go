@@ -0,0 +1,181 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/fakeaws"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+	"github.com/synfinatic/aws-sso-cli/internal/url"
+)
+
+func TestEndpoint(t *testing.T) {
+	t.Setenv(ENV_ENDPOINT_URL, "")
+	c := &SSOConfig{
+		SSOEndpoint:  "https://sso.example.com",
+		OIDCEndpoint: "https://oidc.example.com",
+	}
+	assert.Equal(t, "https://sso.example.com", c.Endpoint(SERVICE_SSO))
+	assert.Equal(t, "https://oidc.example.com", c.Endpoint(SERVICE_OIDC))
+	assert.Equal(t, "", c.Endpoint(SERVICE_STS))
+
+	// our env var overrides everything
+	t.Setenv(ENV_ENDPOINT_URL, "http://127.0.0.1:1234")
+	assert.Equal(t, "http://127.0.0.1:1234", c.Endpoint(SERVICE_SSO))
+	assert.Equal(t, "http://127.0.0.1:1234", c.Endpoint(SERVICE_STS))
+}
+
+func TestValidateNetwork(t *testing.T) {
+	dir := t.TempDir()
+	notPem := filepath.Join(dir, "not.pem")
+	assert.NoError(t, os.WriteFile(notPem, []byte("not a certificate"), 0600))
+
+	tests := []struct {
+		Config *SSOConfig
+		Error  string
+	}{
+		{&SSOConfig{SSOEndpoint: "https://portal.sso.us-east-1.amazonaws.com"}, ""},
+		{&SSOConfig{STSEndpoint: "https://sts.example.com", HttpsProxy: "http://proxy:3128"}, ""},
+		{&SSOConfig{SSOEndpoint: "portal.sso.us-east-1.amazonaws.com"}, "Invalid SSOEndpoint"},
+		{&SSOConfig{OIDCEndpoint: "ftp://oidc.example.com"}, "Invalid OIDCEndpoint"},
+		{&SSOConfig{STSEndpoint: "https://"}, "Invalid STSEndpoint"},
+		{&SSOConfig{HttpsProxy: "proxy:3128"}, "Invalid HttpsProxy"},
+		{&SSOConfig{CABundle: filepath.Join(dir, "missing.pem")}, "Invalid CABundle"},
+		{&SSOConfig{CABundle: notPem}, "No PEM certificates found"},
+	}
+	for _, test := range tests {
+		err := test.Config.validateNetwork()
+		if test.Error == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, test.Error)
+		}
+	}
+
+	s := &Settings{
+		SSO: map[string]*SSOConfig{
+			"Default": {HttpsProxy: "proxy:3128"},
+		},
+	}
+	assert.ErrorContains(t, s.Validate(), "Default: Invalid HttpsProxy")
+}
+
+// connectProxy is a minimal HTTPS proxy which counts CONNECT requests
+func connectProxy(t *testing.T, count *int32) *httptest.Server {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(count, 1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestFakeAWSNetworkOverrides(t *testing.T) {
+	t.Setenv(ENV_ENDPOINT_URL, "")
+	fake := fakeaws.NewTLS()
+	t.Cleanup(fake.Close)
+	fake.AddAccount("000000000001", "Dev", "dev@example.com", "Admin")
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: fake.Certificate().Raw,
+	}), 0600))
+
+	var connects int32
+	proxy := connectProxy(t, &connects)
+
+	newAWSSSO := func(c *SSOConfig) *AWSSSO {
+		store, err := storage.OpenJsonStore(filepath.Join(t.TempDir(), "store.json"))
+		assert.NoError(t, err)
+		var secureStore storage.SecureStorage = store
+
+		c.key = "Default"
+		c.settings = &Settings{UrlAction: url.PrintUrl}
+		c.StartUrl = "https://testing.awsapps.com/start"
+		c.SSORegion = "us-east-1"
+		c.SSOEndpoint = fake.URL
+		c.OIDCEndpoint = fake.URL
+		c.STSEndpoint = fake.URL
+		c.Accounts = map[string]*SSOAccount{
+			"000000000002": {
+				Roles: map[string]*SSORole{
+					"Chained": {Via: "arn:aws:iam::000000000001:role/Admin"},
+				},
+			},
+		}
+		c.MaxRetry = 1
+		as, err := NewAWSSSO(c, &secureStore)
+		assert.NoError(t, err)
+		return as
+	}
+
+	// the self-signed certificate is not trusted without our CABundle
+	as := newAWSSSO(&SSOConfig{})
+	assert.ErrorContains(t, as.reauthenticate(), "certificate")
+	assert.Equal(t, 0, fake.Calls(fakeaws.OP_REGISTER_CLIENT))
+
+	as = newAWSSSO(&SSOConfig{CABundle: caBundle, HttpsProxy: proxy.URL})
+	assert.NoError(t, as.reauthenticate())
+	assert.Equal(t, 1, fake.Calls(fakeaws.OP_REGISTER_CLIENT))
+
+	_, err := as.GetRoleCredentials(1, "Admin")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.Calls(fakeaws.OP_GET_ROLE_CREDENTIALS))
+
+	// STS uses the same endpoint, proxy & CA bundle
+	creds, err := as.GetRoleCredentials(2, "Chained")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::000000000002:role/Chained", creds.RoleArn())
+	assert.Equal(t, 1, fake.Calls(fakeaws.OP_ASSUME_ROLE))
+	assert.Positive(t, atomic.LoadInt32(&connects))
+}
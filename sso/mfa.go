@@ -0,0 +1,117 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// MfaTokenFunc returns the current token code of the given MFA device
+type MfaTokenFunc func(serial string) (string, error)
+
+var (
+	// sts:AssumeRole SerialNumber
+	mfaSerialRegexp = regexp.MustCompile(`^[\w+=/:,.@-]{9,256}$`)
+	// TOTP token code
+	mfaTokenRegexp = regexp.MustCompile(`^\d{6}$`)
+)
+
+// ValidateMfaSerial returns an error if the MFA device serial number or ARN is invalid
+func ValidateMfaSerial(serial string) error {
+	if !mfaSerialRegexp.MatchString(serial) {
+		return fmt.Errorf("Invalid MfaSerial %s", serial)
+	}
+	return nil
+}
+
+// validateMfaToken returns an error if the token is not a TOTP code
+func validateMfaToken(token string) error {
+	if !mfaTokenRegexp.MatchString(token) {
+		return fmt.Errorf("MFA token must be 6 digits")
+	}
+	return nil
+}
+
+// PromptMfaToken prompts the user on the terminal for the MFA token code
+func PromptMfaToken(serial string) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("Unable to prompt for the MFA token of %s without a terminal.  Use --mfa-token", serial)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter MFA token for %s: ", serial)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(line)
+	return token, validateMfaToken(token)
+}
+
+// FixedMfaToken returns an MfaTokenFunc which always returns the given token
+func FixedMfaToken(token string) MfaTokenFunc {
+	return func(serial string) (string, error) {
+		return token, validateMfaToken(token)
+	}
+}
+
+// ReaderMfaToken returns an MfaTokenFunc which reads the token from the first
+// line of the reader.  Useful for scripts which pipe the token via stdin.
+func ReaderMfaToken(r io.Reader) MfaTokenFunc {
+	var once sync.Once
+	var token string
+	var err error
+
+	return func(serial string) (string, error) {
+		once.Do(func() {
+			var line string
+			line, err = bufio.NewReader(r).ReadString('\n')
+			if err == io.EOF && line != "" {
+				err = nil
+			}
+			token = strings.TrimSpace(line)
+		})
+		if err != nil {
+			return "", fmt.Errorf("Unable to read MFA token: %s", err.Error())
+		}
+		return token, validateMfaToken(token)
+	}
+}
+
+// SetMfaTokenFunc selects how we get the MFA token code for roles and static
+// credentials which require MFA.  Defaults to PromptMfaToken.
+func (as *AWSSSO) SetMfaTokenFunc(f MfaTokenFunc) {
+	as.mfaToken = f
+}
+
+// getMfaToken returns the token code for the MFA device
+func (as *AWSSSO) getMfaToken(serial string) (string, error) {
+	f := as.mfaToken
+	if f == nil {
+		f = PromptMfaToken
+	}
+	return f(serial)
+}
@@ -0,0 +1,151 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+// MAX_ROLE_CHAIN_DEPTH is the most roles we will assume via Via in order to
+// get the credentials of a single role
+const MAX_ROLE_CHAIN_DEPTH = 10
+
+// RoleChain is the ARN of a role followed by the ARNs of the roles, and
+// possibly IAM user, it is assumed via
+type RoleChain []string
+
+func (rc RoleChain) String() string {
+	return strings.Join(rc, " -> ")
+}
+
+// add returns a new RoleChain with the ARN appended or an error if doing so
+// creates a loop or exceeds MAX_ROLE_CHAIN_DEPTH
+func (rc RoleChain) add(arn string) (RoleChain, error) {
+	chain := append(append(RoleChain{}, rc...), arn)
+	for _, prev := range rc {
+		if prev == arn {
+			return chain, fmt.Errorf("Role chain loop: %s", chain.String())
+		}
+	}
+	// the last role or user is not assumed via sts:AssumeRole
+	if len(chain) > MAX_ROLE_CHAIN_DEPTH+1 {
+		return chain, fmt.Errorf("Role chain is longer than %d roles: %s", MAX_ROLE_CHAIN_DEPTH, chain.String())
+	}
+	return chain, nil
+}
+
+// viaARN returns the Via of the role as an ARN in the same format as
+// utils.MakeRoleARN or utils.MakeUserARN
+func viaARN(via string) (string, error) {
+	accountId, name, err := utils.ParseRoleARN(via)
+	if err != nil {
+		return "", err
+	}
+	if utils.IsUserARN(via) {
+		return utils.MakeUserARN(accountId, name), nil
+	}
+	return utils.MakeRoleARN(accountId, name), nil
+}
+
+// validateRoleChains validates the Via of every role: loops, invalid ARNs,
+// chains longer than MAX_ROLE_CHAIN_DEPTH and chains which don't start with
+// a static IAM user or a role in our config or the AWS SSO roles in our cache
+// are errors.  If we have no cached roles, the latter is only a warning so
+// that the cache can be populated.
+func (c *SSOConfig) validateRoleChains(cache *SSOCache) error {
+	via := map[string]string{}      // role ARN => Via ARN
+	configured := map[string]bool{} // role ARNs in our config
+	for accountId, a := range c.Accounts {
+		if a == nil {
+			continue
+		}
+		for roleName, r := range a.Roles {
+			id, err := utils.AccountIdToInt64(accountId)
+			if r == nil || r.Via == "" {
+				if err == nil {
+					configured[utils.MakeRoleARN(id, roleName)] = true
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Invalid account %s for role %s: %s", accountId, roleName, err.Error())
+			}
+			arn := utils.MakeRoleARN(id, roleName)
+			if via[arn], err = viaARN(r.Via); err != nil {
+				return fmt.Errorf("Invalid Via %s for %s: %s", r.Via, arn, err.Error())
+			}
+		}
+	}
+
+	ssoRoles := cache.ssoRoles()
+	arns := make([]string, 0, len(via))
+	for arn := range via {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns) // consistent errors
+
+	for _, arn := range arns {
+		chain := RoleChain{arn}
+		next, ok := via[arn]
+		for ok {
+			var err error
+			if chain, err = chain.add(next); err != nil {
+				return err
+			}
+			next, ok = via[next]
+		}
+
+		first := chain[len(chain)-1]
+		if utils.IsUserARN(first) || configured[first] || ssoRoles[first] {
+			continue
+		}
+		if ssoRoles == nil {
+			// we can't tell until `aws-sso cache` has populated the cache
+			log.Warnf("%s is not a role in the config and the cache is empty: %s", first, chain.String())
+			continue
+		}
+		return fmt.Errorf("%s is not a role in the config or an AWS SSO role in the cache: %s",
+			first, chain.String())
+	}
+	return nil
+}
+
+// ssoRoles returns the ARNs of the roles AWS SSO gave us access to or nil if
+// we have not cached them yet
+func (sc *SSOCache) ssoRoles() map[string]bool {
+	if sc == nil || len(sc.Accounts) == 0 {
+		return nil
+	}
+
+	roles := map[string]bool{}
+	for accountId, account := range sc.Accounts {
+		id, err := utils.AccountIdToInt64(accountId)
+		if err != nil {
+			continue
+		}
+		for _, role := range account.Roles {
+			roles[utils.MakeRoleARN(id, role)] = true
+		}
+	}
+	return roles
+}
@@ -0,0 +1,207 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/fakeaws"
+	"github.com/synfinatic/aws-sso-cli/internal/url"
+)
+
+func TestRoleChainAdd(t *testing.T) {
+	chain := RoleChain{"a"}
+	chain, err := chain.add("b")
+	assert.NoError(t, err)
+	assert.Equal(t, "a -> b", chain.String())
+
+	// add does not modify the original chain
+	_, err = chain[:1].add("c")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleChain{"a", "b"}, chain)
+
+	_, err = chain.add("a")
+	assert.EqualError(t, err, "Role chain loop: a -> b -> a")
+
+	chain = RoleChain{}
+	for i := 0; i <= MAX_ROLE_CHAIN_DEPTH; i++ {
+		chain, err = chain.add(fmt.Sprintf("%d", i))
+		assert.NoError(t, err)
+	}
+	_, err = chain.add("too-long")
+	assert.ErrorContains(t, err, "Role chain is longer than 10 roles: 0 -> 1")
+}
+
+// roleChainConfig returns an SSOConfig with a role in account 000000000002
+// for each Via
+func roleChainConfig(vias map[string]string) *SSOConfig {
+	roles := map[string]*SSORole{}
+	for name, via := range vias {
+		roles[name] = &SSORole{Via: via}
+	}
+	return &SSOConfig{
+		Accounts: map[string]*SSOAccount{
+			"000000000002": {Roles: roles},
+			"000000000003": nil,
+		},
+	}
+}
+
+func TestValidateRoleChains(t *testing.T) {
+	cache := &SSOCache{
+		Accounts: map[string]*CachedAccount{
+			"000000000001": {Roles: []string{"Admin"}},
+		},
+	}
+
+	tests := []struct {
+		Vias    map[string]string
+		Error   string
+		Warning bool // only a warning without a cache
+	}{
+		{map[string]string{}, "", false},
+		{map[string]string{
+			"A": "arn:aws:iam::000000000001:role/Admin",
+			"B": "000000000002:A", // short format
+			"C": "arn:aws:iam::000000000002:role/B",
+			"D": "arn:aws:iam::000000000009:user/breakglass",
+			"E": "arn:aws:iam::000000000002:role/D",
+		}, "", false},
+		// roles in our config don't need to be in the cache
+		{map[string]string{
+			"A":      "arn:aws:iam::000000000002:role/Config",
+			"Config": "",
+		}, "", false},
+		{map[string]string{
+			"D": "arn:aws:iam::000000000009:user/breakglass",
+		}, "", false},
+		// only a warning if the cache is empty
+		{map[string]string{"A": "arn:aws:iam::000000000001:role/Missing"},
+			"arn:aws:iam::000000000001:role/Missing is not a role in the config or an AWS SSO role in the cache: " +
+				"arn:aws:iam::000000000002:role/A -> arn:aws:iam::000000000001:role/Missing", true},
+		{map[string]string{"A": "Admin"}, "Invalid Via Admin for arn:aws:iam::000000000002:role/A: Unable to parse ARN: Admin", false},
+		{map[string]string{"A": "arn:aws:iam::000000000002:role/A"},
+			"Role chain loop: arn:aws:iam::000000000002:role/A -> arn:aws:iam::000000000002:role/A", false},
+		{map[string]string{
+			"A": "arn:aws:iam::000000000002:role/B",
+			"B": "arn:aws:iam::000000000002:role/C",
+			"C": "arn:aws:iam::000000000002:role/A",
+		}, "Role chain loop: arn:aws:iam::000000000002:role/A -> arn:aws:iam::000000000002:role/B -> " +
+			"arn:aws:iam::000000000002:role/C -> arn:aws:iam::000000000002:role/A", false},
+	}
+	for _, test := range tests {
+		err := roleChainConfig(test.Vias).validateRoleChains(cache)
+		if test.Error == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, test.Error)
+		}
+
+		// the cache is optional
+		err = roleChainConfig(test.Vias).validateRoleChains(nil)
+		if test.Error == "" || test.Warning {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, test.Error)
+		}
+	}
+
+	// chain of MAX_ROLE_CHAIN_DEPTH roles via Admin
+	vias := map[string]string{"R0": "arn:aws:iam::000000000001:role/Admin"}
+	for i := 1; i < MAX_ROLE_CHAIN_DEPTH; i++ {
+		vias[fmt.Sprintf("R%d", i)] = fmt.Sprintf("arn:aws:iam::000000000002:role/R%d", i-1)
+	}
+	assert.NoError(t, roleChainConfig(vias).validateRoleChains(cache))
+
+	vias["R10"] = "arn:aws:iam::000000000002:role/R9"
+	assert.ErrorContains(t, roleChainConfig(vias).validateRoleChains(cache),
+		"Role chain is longer than 10 roles: arn:aws:iam::000000000002:role/R10 -> arn:aws:iam::000000000002:role/R9")
+
+	c := &SSOConfig{Accounts: map[string]*SSOAccount{
+		"bad": {Roles: map[string]*SSORole{"A": {Via: "arn:aws:iam::000000000001:role/Admin"}}},
+	}}
+	assert.ErrorContains(t, c.validateRoleChains(cache), "Invalid account bad for role A")
+}
+
+func TestLoadSettingsRoleChainLoop(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(config, []byte(`
+SSOConfig:
+  Default:
+    SSORegion: us-east-1
+    StartUrl: https://testing.awsapps.com/start
+    Accounts:
+      "000000000002":
+        Roles:
+          A:
+            Via: arn:aws:iam::000000000002:role/B
+          B:
+            Via: arn:aws:iam::000000000002:role/A
+`), 0600))
+
+	_, err := LoadSettings(config, filepath.Join(t.TempDir(), "cache.json"), map[string]interface{}{}, OverrideSettings{})
+	assert.EqualError(t, err, "Default: Role chain loop: arn:aws:iam::000000000002:role/A -> "+
+		"arn:aws:iam::000000000002:role/B -> arn:aws:iam::000000000002:role/A")
+}
+
+func TestLoadSettingsRoleChainEmptyCache(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(config, []byte(`
+SSOConfig:
+  Default:
+    SSORegion: us-east-1
+    StartUrl: https://testing.awsapps.com/start
+    Accounts:
+      "000000000002":
+        Roles:
+          A:
+            Via: arn:aws:iam::111111111111:role/SSOAdmin
+`), 0600))
+
+	// we must be able to load our settings to populate the cache
+	_, err := LoadSettings(config, filepath.Join(t.TempDir(), "cache.json"), map[string]interface{}{}, OverrideSettings{})
+	assert.NoError(t, err)
+}
+
+func TestFakeAWSRoleChainLoop(t *testing.T) {
+	as, fake := newFakeAWSSSO(t, AUTH_FLOW_DEVICE_CODE)
+	assert.NoError(t, as.Authenticate(url.Undef, ""))
+
+	// the same chain can be used more than once
+	for i := 0; i < 2; i++ {
+		_, err := as.GetRoleCredentials(2, "Chained")
+		assert.NoError(t, err)
+	}
+
+	// loops which were not caught by LoadSettings are an error, not fatal
+	as.SSOConfig.Accounts["000000000001"] = &SSOAccount{
+		Roles: map[string]*SSORole{
+			"Admin": {Via: "arn:aws:iam::000000000002:role/Chained"},
+		},
+	}
+	as.SSOConfig.Refresh(as.SSOConfig.settings)
+	_, err := as.GetRoleCredentials(2, "Chained")
+	assert.EqualError(t, err, "Role chain loop: arn:aws:iam::000000000002:role/Chained -> "+
+		"arn:aws:iam::000000000001:role/Admin -> arn:aws:iam::000000000002:role/Chained")
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_ASSUME_ROLE))
+}
@@ -0,0 +1,191 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+)
+
+// Note: uses the struct field names of RoleSessionNameData
+const DEFAULT_ROLE_SESSION_NAME_FORMAT = "{{.PreviousRole.RoleName}}@{{.PreviousRole.AccountIdPad}}"
+
+// RoleSessionName must be 2 to 64 characters long per sts:AssumeRole
+var roleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// SSOIdentity is who we are logged into AWS SSO as
+type SSOIdentity struct {
+	UserName string // login name
+	Email    string
+	Name     string // display name
+	UserId   string
+}
+
+// chainIdentity tracks the first hop of a role chain so every role in the
+// chain resolves our SSOIdentity the same way
+type chainIdentity struct {
+	ssoCreds *storage.RoleCredentials // nil if the chain starts with an IAM user
+}
+
+// RoleSessionNameData is the data for the RoleSessionNameFormat template
+type RoleSessionNameData struct {
+	Role         *AWSRoleFlat // role we are assuming
+	PreviousRole *AWSRoleFlat // role we are assuming it via
+	identity     func() (SSOIdentity, error)
+}
+
+// Identity returns who we are logged into AWS SSO as.  Only looked up if the
+// template uses it.
+func (d *RoleSessionNameData) Identity() (SSOIdentity, error) {
+	return d.identity()
+}
+
+// validateRoleSessionNameFormat returns an error if the template is invalid
+func validateRoleSessionNameFormat(format string) error {
+	_, err := template.New("role_session_name").Funcs(templateFuncMap()).Parse(format)
+	return err
+}
+
+// roleSessionName returns the RoleSessionName generated by our RoleSessionNameFormat
+func (as *AWSSSO) roleSessionName(data *RoleSessionNameData) (string, error) {
+	format := DEFAULT_ROLE_SESSION_NAME_FORMAT
+	if s := as.SSOConfig.settings; s != nil && s.RoleSessionNameFormat != "" {
+		format = s.RoleSessionNameFormat
+	}
+
+	templ, err := template.New("role_session_name").Funcs(templateFuncMap()).
+		Option("missingkey=error").Parse(format)
+	if err != nil {
+		return "", fmt.Errorf("Invalid RoleSessionNameFormat: %s", err.Error())
+	}
+
+	buf := new(bytes.Buffer)
+	if err = templ.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("Unable to generate RoleSessionName: %s", err.Error())
+	}
+
+	name := buf.String()
+	if !roleSessionNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("Invalid RoleSessionName '%s': must be 2 to 64 letters, numbers or any of: +=,.@_-", name)
+	}
+	return name, nil
+}
+
+// ssoIdentity returns who we are logged into AWS SSO as using the claims of
+// our IdToken.  If AWS did not give us one, we use the role session name which
+// AWS SSO set to our user name for the first hop of the role chain.
+func (as *AWSSSO) ssoIdentity(chain *chainIdentity) (SSOIdentity, error) {
+	as.tokenLock.RLock()
+	token := as.Token
+	as.tokenLock.RUnlock()
+
+	as.identityLock.Lock()
+	defer as.identityLock.Unlock()
+
+	if as.identityToken == token.AccessToken && as.identity.UserName != "" {
+		return as.identity, nil
+	}
+
+	identity, err := identityFromIdToken(token.IdToken)
+	if err != nil {
+		log.WithError(err).Debugf("Unable to parse IdToken")
+	}
+
+	if identity.UserName == "" {
+		if chain.ssoCreds == nil {
+			return identity, fmt.Errorf("Unable to determine AWS SSO identity")
+		}
+		stsSession, err := as.SSOConfig.STSClient(credentials.NewStaticCredentialsProvider(
+			chain.ssoCreds.AccessKeyId,
+			chain.ssoCreds.SecretAccessKey,
+			chain.ssoCreds.SessionToken,
+		))
+		if err != nil {
+			return identity, err
+		}
+		out, err := stsSession.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return identity, fmt.Errorf("Unable to determine AWS SSO identity: %s", err.Error())
+		}
+		// arn:aws:sts::<account>:assumed-role/AWSReservedSSO_<permission set>_<id>/<user name>
+		arn := strings.Split(aws.ToString(out.Arn), "/")
+		if len(arn) != 3 {
+			return identity, fmt.Errorf("Unable to determine AWS SSO identity from %s", aws.ToString(out.Arn))
+		}
+		identity.UserName = arn[2]
+	}
+
+	as.identity = identity
+	as.identityToken = token.AccessToken
+	return identity, nil
+}
+
+// identityFromIdToken returns the identity in the claims of the JWT.  The
+// signature is not verified since we only use it to name our sessions.
+func identityFromIdToken(idToken string) (SSOIdentity, error) {
+	identity := SSOIdentity{}
+	if idToken == "" {
+		return identity, nil
+	}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return identity, fmt.Errorf("IdToken is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return identity, err
+	}
+
+	claims := struct {
+		Subject           string `json:"sub"`
+		Email             string `json:"email"`
+		Name              string `json:"name"`
+		PreferredUserName string `json:"preferred_username"`
+		UserName          string `json:"username"`
+	}{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return identity, err
+	}
+
+	identity = SSOIdentity{
+		UserName: claims.PreferredUserName,
+		Email:    claims.Email,
+		Name:     claims.Name,
+		UserId:   claims.Subject,
+	}
+	if identity.UserName == "" {
+		identity.UserName = claims.UserName
+	}
+	if identity.UserName == "" {
+		identity.UserName = claims.Email
+	}
+	return identity, nil
+}
@@ -0,0 +1,158 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synfinatic/aws-sso-cli/internal/fakeaws"
+	"github.com/synfinatic/aws-sso-cli/internal/url"
+)
+
+// makeIdToken returns an unsigned JWT with the given claims
+func makeIdToken(claims string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims)) + "."
+}
+
+func TestIdentityFromIdToken(t *testing.T) {
+	identity, err := identityFromIdToken("")
+	assert.NoError(t, err)
+	assert.Empty(t, identity)
+
+	identity, err = identityFromIdToken(makeIdToken(
+		`{"sub":"1234","email":"jdoe@example.com","name":"Jane Doe","preferred_username":"jdoe"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, SSOIdentity{
+		UserName: "jdoe",
+		Email:    "jdoe@example.com",
+		Name:     "Jane Doe",
+		UserId:   "1234",
+	}, identity)
+
+	// fall back to the email
+	identity, err = identityFromIdToken(makeIdToken(`{"sub":"1234","email":"jdoe@example.com"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "jdoe@example.com", identity.UserName)
+
+	_, err = identityFromIdToken("not-a-jwt")
+	assert.Error(t, err)
+	_, err = identityFromIdToken("a.!!!.c")
+	assert.Error(t, err)
+}
+
+func TestRoleSessionName(t *testing.T) {
+	as := &AWSSSO{
+		SSOConfig: &SSOConfig{settings: &Settings{}},
+	}
+	lookups := 0
+	data := &RoleSessionNameData{
+		Role: &AWSRoleFlat{
+			AccountIdPad: "000000000002",
+			RoleName:     "Chained",
+			Tags:         map[string]string{"Team": "platform"},
+		},
+		PreviousRole: &AWSRoleFlat{
+			AccountIdPad: "000000000001",
+			RoleName:     "Admin",
+		},
+		identity: func() (SSOIdentity, error) {
+			lookups++
+			return SSOIdentity{UserName: "jdoe@example.com", Name: "Jane Doe"}, nil
+		},
+	}
+
+	name, err := as.roleSessionName(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Admin@000000000001", name)
+	assert.Equal(t, 0, lookups)
+
+	as.SSOConfig.settings.RoleSessionNameFormat = "{{ .Identity.UserName }}"
+	name, err = as.roleSessionName(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "jdoe@example.com", name)
+	assert.Equal(t, 1, lookups)
+
+	as.SSOConfig.settings.RoleSessionNameFormat = `{{ .Role.Tags.Team }}-{{ .Identity.Name | StringReplace " " "." }}`
+	name, err = as.roleSessionName(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "platform-Jane.Doe", name)
+
+	tests := map[string]string{
+		"{{ .Identity.Name }}":                 "Invalid RoleSessionName 'Jane Doe'",
+		"x":                                    "Invalid RoleSessionName 'x'",
+		strings.Repeat("x", 65):                "must be 2 to 64",
+		"{{ .Role.Tags.Owner }}":               "Unable to generate RoleSessionName",
+		"{{ .PreviousRole.RoleName":            "Invalid RoleSessionNameFormat",
+		"{{ .PreviousRole.RoleName }}:{{ 1 }}": "Invalid RoleSessionName",
+	}
+	for format, msg := range tests {
+		as.SSOConfig.settings.RoleSessionNameFormat = format
+		_, err = as.roleSessionName(data)
+		assert.ErrorContains(t, err, msg, format)
+	}
+
+	assert.NoError(t, validateRoleSessionNameFormat(DEFAULT_ROLE_SESSION_NAME_FORMAT))
+	assert.Error(t, validateRoleSessionNameFormat("{{ .Identity"))
+}
+
+func TestFakeAWSRoleSessionName(t *testing.T) {
+	as, fake := newFakeAWSSSO(t, AUTH_FLOW_DEVICE_CODE)
+	as.SSOConfig.settings.RoleSessionNameFormat = "{{ .Identity.UserName }}"
+	assert.NoError(t, as.Authenticate(url.Undef, ""))
+
+	// no IdToken, so we use the session name AWS SSO gave our credentials
+	_, err := as.GetRoleCredentials(2, "Chained")
+	assert.NoError(t, err)
+	_, err = as.GetRoleCredentials(2, "Chained")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.Calls(fakeaws.OP_GET_CALLER_IDENTITY))
+
+	reqs := fake.Requests(fakeaws.OP_ASSUME_ROLE)
+	assert.Len(t, reqs, 2)
+	assert.Equal(t, "aws-sso-user", reqs[0].Form.Get("RoleSessionName"))
+	assert.Equal(t, "aws-sso-user", reqs[1].Form.Get("RoleSessionName"))
+
+	// deeper chains use the session name of the first hop
+	as.SSOConfig.Accounts["000000000003"] = &SSOAccount{
+		Roles: map[string]*SSORole{
+			"Deep": {Via: "arn:aws:iam::000000000002:role/Chained"},
+		},
+	}
+	as.identityToken = ""
+	_, err = as.GetRoleCredentials(3, "Deep")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_GET_CALLER_IDENTITY))
+	reqs = fake.Requests(fakeaws.OP_ASSUME_ROLE)
+	assert.Len(t, reqs, 4)
+	assert.Equal(t, "aws-sso-user", reqs[2].Form.Get("RoleSessionName"))
+	assert.Equal(t, "aws-sso-user", reqs[3].Form.Get("RoleSessionName"))
+
+	// prefer the IdToken
+	as.Token.IdToken = makeIdToken(`{"sub":"1234","preferred_username":"jdoe"}`)
+	as.identityToken = ""
+	_, err = as.GetRoleCredentials(2, "Chained")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.Calls(fakeaws.OP_GET_CALLER_IDENTITY))
+	reqs = fake.Requests(fakeaws.OP_ASSUME_ROLE)
+	assert.Equal(t, "jdoe", reqs[4].Form.Get("RoleSessionName"))
+}
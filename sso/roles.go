@@ -222,28 +222,33 @@ func (r *Roles) GetRoleByProfile(profileName string, s *Settings) (*AWSRoleFlat,
 	return &AWSRoleFlat{}, fmt.Errorf("unable to locate role with Profile: %s", profileName)
 }
 
-// GetRoleChain figures out the AssumeRole chain required to assume the given role
-func (r *Roles) GetRoleChain(accountId int64, roleName string) []*AWSRoleFlat {
+// GetRoleChain figures out the AssumeRole chain required to assume the given role.
+// The chain starts with an AWS SSO role or the first role assumed via a static IAM user.
+func (r *Roles) GetRoleChain(accountId int64, roleName string) ([]*AWSRoleFlat, error) {
 	ret := []*AWSRoleFlat{}
 
 	f, err := r.GetRole(accountId, roleName)
 	if err != nil {
-		log.WithError(err).Fatalf("unable to get role: %s", utils.MakeRoleARN(accountId, roleName))
+		return ret, fmt.Errorf("unable to get role %s: %s", utils.MakeRoleARN(accountId, roleName), err.Error())
 	}
+	chain := RoleChain{f.Arn}
 	ret = append(ret, f)
-	for f.Via != "" {
+	for f.Via != "" && !utils.IsUserARN(f.Via) {
 		aId, rName, err := utils.ParseRoleARN(f.Via)
 		if err != nil {
-			log.WithError(err).Fatalf("unable to parse '%s'", f.Via)
+			return ret, fmt.Errorf("unable to parse Via %s: %s", f.Via, err.Error())
+		}
+		if chain, err = chain.add(utils.MakeRoleARN(aId, rName)); err != nil {
+			return ret, err
 		}
 		f, err = r.GetRole(aId, rName)
 		if err != nil {
-			log.WithError(err).Fatalf("unable to get role: %s", utils.MakeRoleARN(aId, rName))
+			return ret, fmt.Errorf("unable to get role %s: %s", utils.MakeRoleARN(aId, rName), err.Error())
 		}
 		ret = append([]*AWSRoleFlat{f}, ret...) // prepend
 	}
 
-	return ret
+	return ret, nil
 }
 
 // MatchingRoles returns all the roles matching the given tags
@@ -350,18 +355,8 @@ func (r *AWSRoleFlat) ExpiresIn() (string, error) {
 	return utils.TimeRemain(r.ExpiresEpoch, false)
 }
 
-// RoleProfile returns either the user-defined Profile value for the role from
-// the config.yaml or the generated Profile using the ProfileFormat template
-func (r *AWSRoleFlat) ProfileName(s *Settings) (string, error) {
-	if len(r.Profile) > 0 {
-		return r.Profile, nil
-	}
-
-	format := s.ProfileFormat
-	if len(format) == 0 {
-		format = DEFAULT_PROFILE_TEMPLATE
-	}
-
+// templateFuncMap returns the functions available to our AWSRoleFlat templates
+func templateFuncMap() template.FuncMap {
 	// our custom functions
 	customFuncs := template.FuncMap{
 		"AccountIdStr":  accountIdToStr,
@@ -376,8 +371,22 @@ func (r *AWSRoleFlat) ProfileName(s *Settings) (string, error) {
 	for k, v := range customFuncs {
 		funcMap[k] = v
 	}
+	return funcMap
+}
+
+// RoleProfile returns either the user-defined Profile value for the role from
+// the config.yaml or the generated Profile using the ProfileFormat template
+func (r *AWSRoleFlat) ProfileName(s *Settings) (string, error) {
+	if len(r.Profile) > 0 {
+		return r.Profile, nil
+	}
+
+	format := s.ProfileFormat
+	if len(format) == 0 {
+		format = DEFAULT_PROFILE_TEMPLATE
+	}
 
-	templ, err := template.New("profile_name").Funcs(funcMap).Parse(format)
+	templ, err := template.New("profile_name").Funcs(templateFuncMap()).Parse(format)
 	if err != nil {
 		return "", err
 	}
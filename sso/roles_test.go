@@ -485,9 +485,13 @@ func (suite *CacheRolesTestSuite) TestGetRoleChain() {
 	t := suite.T()
 
 	roles := suite.cache.SSO[suite.cache.ssoName].Roles
-	flat := roles.GetRoleChain(707513610766, "AWSPowerUserAccess")
+	flat, err := roles.GetRoleChain(707513610766, "AWSPowerUserAccess")
+	assert.NoError(t, err)
 	assert.Equal(t, 2, len(flat))
 
 	assert.Equal(t, "arn:aws:iam::707513610766:role/AWSReadOnlyAccess", flat[0].Arn)
 	assert.Equal(t, "arn:aws:iam::707513610766:role/AWSPowerUserAccess", flat[1].Arn)
+
+	_, err = roles.GetRoleChain(707513610766, "Missing")
+	assert.ErrorContains(t, err, "unable to get role arn:aws:iam::707513610766:role/Missing")
 }
@@ -34,6 +34,8 @@ import (
 	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/sirupsen/logrus"
+	"github.com/synfinatic/aws-sso-cli/internal/audit"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
 	"github.com/synfinatic/aws-sso-cli/internal/url"
 	"github.com/synfinatic/aws-sso-cli/internal/utils"
 )
@@ -49,11 +51,15 @@ type Settings struct {
 	Cache                     *Cache                   `yaml:"-"` // our cache data
 	SSO                       map[string]*SSOConfig    `koanf:"SSOConfig" yaml:"SSOConfig,omitempty"`
 	DefaultSSO                string                   `koanf:"DefaultSSO" yaml:"DefaultSSO,omitempty"`   // specify default SSO by key
-	SecureStore               string                   `koanf:"SecureStore" yaml:"SecureStore,omitempty"` // json or keyring
+	SecureStore               string                   `koanf:"SecureStore" yaml:"SecureStore,omitempty"` // json, encrypted-json or keyring
 	DefaultRegion             string                   `koanf:"DefaultRegion" yaml:"DefaultRegion,omitempty"`
 	ConsoleDuration           int32                    `koanf:"ConsoleDuration" yaml:"ConsoleDuration,omitempty"`
 	JsonStore                 string                   `koanf:"JsonStore" yaml:"JsonStore,omitempty"`
+	EncryptedJsonStore        string                   `koanf:"EncryptedJsonStore" yaml:"EncryptedJsonStore,omitempty"`
+	EncryptedJsonKeyFile      string                   `koanf:"EncryptedJsonKeyFile" yaml:"EncryptedJsonKeyFile,omitempty"`
+	EncryptedJsonKdf          string                   `koanf:"EncryptedJsonKdf" yaml:"EncryptedJsonKdf,omitempty"`
 	CacheRefresh              int64                    `koanf:"CacheRefresh" yaml:"CacheRefresh,omitempty"`
+	CacheAccountRefresh       int64                    `koanf:"CacheAccountRefresh" yaml:"CacheAccountRefresh,omitempty"`
 	Threads                   int                      `koanf:"Threads" yaml:"Threads,omitempty"`
 	MaxBackoff                int                      `koanf:"MaxBackoff" yaml:"MaxBackoff,omitempty"`
 	MaxRetry                  int                      `koanf:"MaxRetry" yaml:"MaxRetry,omitempty"`
@@ -70,6 +76,7 @@ type Settings struct {
 	HistoryLimit              int64                    `koanf:"HistoryLimit" yaml:"HistoryLimit,omitempty"`
 	HistoryMinutes            int64                    `koanf:"HistoryMinutes" yaml:"HistoryMinutes,omitempty"`
 	ProfileFormat             string                   `koanf:"ProfileFormat" yaml:"ProfileFormat,omitempty"`
+	RoleSessionNameFormat     string                   `koanf:"RoleSessionNameFormat" yaml:"RoleSessionNameFormat,omitempty"`
 	AccountPrimaryTag         []string                 `koanf:"AccountPrimaryTag" yaml:"AccountPrimaryTag,omitempty"`
 	FirstTag                  string                   `koanf:"FirstTag" yaml:"FirstTag,omitempty"`
 	PromptColors              PromptColors             `koanf:"PromptColors" yaml:"PromptColors,omitempty"` // go-prompt colors
@@ -77,6 +84,14 @@ type Settings struct {
 	ConfigVariables           map[string]interface{}   `koanf:"ConfigVariables" yaml:"ConfigVariables,omitempty"`
 	EnvVarTags                []string                 `koanf:"EnvVarTags" yaml:"EnvVarTags,omitempty"`
 	FullTextSearch            bool                     `koanf:"FullTextSearch" yaml:"FullTextSearch"`
+	AuditLog                  AuditLog                 `koanf:"AuditLog" yaml:"AuditLog,omitempty"`
+}
+
+// AuditLog configures the log of every time we hand out credentials
+type AuditLog struct {
+	File       string `koanf:"File" yaml:"File,omitempty"`       // disabled if empty
+	MaxSize    int    `koanf:"MaxSize" yaml:"MaxSize,omitempty"` // MB
+	MaxBackups int    `koanf:"MaxBackups" yaml:"MaxBackups,omitempty"`
 }
 
 // GetDefaultRegion scans the config settings file to pick the most local DefaultRegion from the tree
@@ -205,9 +220,28 @@ func LoadSettings(configFile, cacheFile string, defaults map[string]interface{},
 		log.Infof("%s", err.Error())
 	}
 
+	// needs the cache to know which roles are from AWS SSO
+	if err = s.validateRoleChains(); err != nil {
+		return s, err
+	}
+
 	return s, nil
 }
 
+// validateRoleChains validates the Via of every role in every SSO instance
+func (s *Settings) validateRoleChains() error {
+	for name, c := range s.SSO {
+		var cache *SSOCache
+		if s.Cache != nil {
+			cache = s.Cache.SSO[name]
+		}
+		if err := c.validateRoleChains(cache); err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
 func (s *Settings) Validate() error {
 	// Does either action call `exec` without firefox containers?
 	if s.UrlAction.IsContainer() != s.ConfigProfilesUrlAction.IsContainer() {
@@ -216,6 +250,42 @@ func (s *Settings) Validate() error {
 		}
 	}
 
+	if err := validateRoleSessionNameFormat(s.RoleSessionNameFormat); err != nil {
+		return fmt.Errorf("Invalid RoleSessionNameFormat: %s", err.Error())
+	}
+
+	switch s.EncryptedJsonKdf {
+	case "", storage.KDF_SCRYPT, storage.KDF_ARGON2ID:
+	default:
+		return fmt.Errorf("Invalid EncryptedJsonKdf '%s'. Valid options: %s, %s",
+			s.EncryptedJsonKdf, storage.KDF_SCRYPT, storage.KDF_ARGON2ID)
+	}
+
+	for name, c := range s.SSO {
+		switch c.GetAuthFlow() {
+		case AUTH_FLOW_DEVICE_CODE, AUTH_FLOW_AUTH_CODE:
+		default:
+			return fmt.Errorf("Invalid AuthFlow '%s' for %s. Valid options: %s, %s",
+				c.AuthFlow, name, AUTH_FLOW_DEVICE_CODE, AUTH_FLOW_AUTH_CODE)
+		}
+		if err := c.validateNetwork(); err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+		for accountId, a := range c.Accounts {
+			if a == nil {
+				continue
+			}
+			for roleName, r := range a.Roles {
+				if r == nil {
+					continue
+				}
+				if err := r.validateAssumeRole(); err != nil {
+					return fmt.Errorf("%s: Invalid role %s in account %s: %s", name, roleName, accountId, err.Error())
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -342,6 +412,14 @@ func (s *Settings) setOverrides(override OverrideSettings) {
 	}
 }
 
+// AuditLogger returns the audit log Logger or nil if it is not enabled
+func (s *Settings) AuditLogger() *audit.Logger {
+	if s.AuditLog.File == "" {
+		return nil
+	}
+	return audit.NewLogger(s.AuditLog.File, s.AuditLog.MaxSize, s.AuditLog.MaxBackups)
+}
+
 func (s *Settings) ConfigFile() string {
 	return s.configFile
 }
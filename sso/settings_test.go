@@ -298,6 +298,23 @@ func (suite *SettingsTestSuite) TestValidate() {
 	assert.Error(t, suite.settings.Validate())
 }
 
+func TestValidateAuthFlow(t *testing.T) {
+	s := &Settings{
+		SSO: map[string]*SSOConfig{
+			"Default": {},
+		},
+	}
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, AUTH_FLOW_DEVICE_CODE, s.SSO["Default"].GetAuthFlow())
+
+	s.SSO["Default"].AuthFlow = AUTH_FLOW_AUTH_CODE
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, AUTH_FLOW_AUTH_CODE, s.SSO["Default"].GetAuthFlow())
+
+	s.SSO["Default"].AuthFlow = "implicit"
+	assert.ErrorContains(t, s.Validate(), "Invalid AuthFlow")
+}
+
 func (suite *SettingsTestSuite) TestSetOverrides() {
 	t := suite.T()
 
@@ -0,0 +1,154 @@
+package sso
+
+/*
+ * AWS SSO CLI
+ * Copyright (c) 2021-2023 Aaron Turner  <synfinatic at gmail dot com>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/synfinatic/aws-sso-cli/internal/storage"
+	"github.com/synfinatic/aws-sso-cli/internal/utils"
+)
+
+// StaticSessionCredentials returns temporary credentials for the static IAM
+// user credentials stored under the given ARN via sts:GetSessionToken.  If
+// the user has an MfaSerial, we prompt for the MFA token.  Credentials are
+// cached in the SecureStore until they expire or refresh is true.
+func (as *AWSSSO) StaticSessionCredentials(arn string, refresh bool) (storage.SessionCredentials, error) {
+	static := storage.StaticCredentials{}
+	if err := as.store.GetStaticCredentials(arn, &static); err != nil {
+		return storage.SessionCredentials{}, err
+	}
+
+	if !refresh && static.Session != nil && !static.Session.Expired() {
+		log.Debugf("Using cached session credentials for %s", arn)
+		return *static.Session, nil
+	}
+
+	session, err := as.GetSessionToken(&static)
+	if err != nil {
+		return storage.SessionCredentials{}, fmt.Errorf("Unable to get session credentials for %s: %s", arn, err.Error())
+	}
+
+	static.Session = &session
+	if err = as.store.SaveStaticCredentials(arn, static); err != nil {
+		log.WithError(err).Errorf("Unable to save session credentials for %s", arn)
+	}
+	return session, nil
+}
+
+// staticUserSession returns an STS client using the session credentials of the
+// static IAM user for a role with a Via of the user ARN and the user as an
+// AWSRoleFlat for RoleSessionNameFormat.  RoleName is the user name.
+func (as *AWSSSO) staticUserSession(userArn string) (*sts.Client, *AWSRoleFlat, error) {
+	accountId, userName, err := utils.ParseUserARN(userArn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid Via %s: %s", userArn, err.Error())
+	}
+	// static credentials are stored under the ARN returned by AWS
+	arn := utils.MakeUserARN(accountId, userName)
+
+	session, err := as.StaticSessionCredentials(arn, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stsSession, err := as.SSOConfig.STSClient(credentials.NewStaticCredentialsProvider(
+		session.AccessKeyId,
+		session.SecretAccessKey,
+		session.SessionToken,
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	static := storage.StaticCredentials{}
+	_ = as.store.GetStaticCredentials(arn, &static)
+	idStr, _ := utils.AccountIdToString(accountId)
+	user := &AWSRoleFlat{
+		AccountId:    accountId,
+		AccountIdPad: idStr,
+		Arn:          arn,
+		RoleName:     userName,
+		Profile:      static.Profile,
+		SSO:          as.key,
+		Tags:         map[string]string{},
+	}
+	for k, v := range static.Tags {
+		user.Tags[k] = v
+	}
+	return stsSession, user, nil
+}
+
+// GetSessionToken calls sts:GetSessionToken using the static credentials,
+// prompting for the MFA token if they have an MfaSerial
+func (as *AWSSSO) GetSessionToken(static *storage.StaticCredentials) (storage.SessionCredentials, error) {
+	stsSession, err := as.SSOConfig.STSClient(credentials.NewStaticCredentialsProvider(
+		static.AccessKeyId,
+		static.SecretAccessKey,
+		"",
+	))
+	if err != nil {
+		return storage.SessionCredentials{}, err
+	}
+
+	input := &sts.GetSessionTokenInput{}
+	if static.MfaSerial != "" {
+		token, err := as.getMfaToken(static.MfaSerial)
+		if err != nil {
+			return storage.SessionCredentials{}, err
+		}
+		input.SerialNumber = aws.String(static.MfaSerial)
+		input.TokenCode = aws.String(token)
+	}
+
+	output, err := stsSession.GetSessionToken(context.TODO(), input)
+	if err != nil {
+		return storage.SessionCredentials{}, err
+	}
+
+	return storage.SessionCredentials{
+		AccessKeyId:     aws.ToString(output.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(output.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(output.Credentials.SessionToken),
+		Expiration:      aws.ToTime(output.Credentials.Expiration).UnixMilli(),
+	}, nil
+}
+
+// StaticUserArn validates the static credentials by calling
+// sts:GetCallerIdentity and returns the ARN of the IAM user they belong to
+func (as *AWSSSO) StaticUserArn(static *storage.StaticCredentials) (string, error) {
+	stsSession, err := as.SSOConfig.STSClient(credentials.NewStaticCredentialsProvider(
+		static.AccessKeyId,
+		static.SecretAccessKey,
+		"",
+	))
+	if err != nil {
+		return "", err
+	}
+
+	output, err := stsSession.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.Arn), nil
+}